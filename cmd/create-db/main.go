@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/url"
@@ -85,11 +86,11 @@ func main() {
 }
 
 func createTables(dbUrl string) {
-	pgDb, err := database.NewPostgresDB(dbUrl)
+	pgDb, err := database.NewPostgresDB(dbUrl, database.PoolOptions{})
 	if err != nil {
 		logger.FatalLog("Error connecting to new DB: %v", err)
 	}
-	err = pgDb.Setup()
+	err = pgDb.Setup(context.Background())
 	if err != nil {
 		logger.FatalLog("Error creating tables: %v", err)
 	}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/monitoring"
+)
+
+// Generates the Prometheus rules and the Grafana dashboard of the service from its metric definitions:
+//
+//	go run ./cmd/monitoring-rules          # writes deploy/prometheus/rules.yml and deploy/grafana/dashboard.json
+//	go run ./cmd/monitoring-rules -check   # fails when they reference unknown metrics or are out of date
+func main() {
+	opts := monitoring.RuleOptions{}
+	opts.Default()
+	outDir := flag.String("out", "deploy", "directory the files are written to")
+	check := flag.Bool("check", false, "only check the files are valid and up to date")
+	flag.Float64Var(&opts.ClickAvailability, "click-availability", opts.ClickAvailability, "objective for the share of click tracking requests not failing")
+	flag.DurationVar(&opts.ClickLatencyP99, "click-latency-p99", opts.ClickLatencyP99, "objective for the p99 latency of click tracking requests")
+	flag.DurationVar(&opts.ArchiveMaxAge, "archive-max-age", opts.ArchiveMaxAge, "how long archiving may go without succeeding")
+	flag.Float64Var(&opts.PoolSaturation, "pool-saturation", opts.PoolSaturation, "share of database connections in use considered saturated")
+	flag.Parse()
+
+	rules := monitoring.Rules(opts)
+	dashboard := monitoring.NewDashboard()
+	if err := errors.Join(rules.Check(), dashboard.Check(rules.Recorded())); err != nil {
+		logger.FatalLog("Invalid monitoring config:\n%v", err)
+	}
+
+	var rulesFile bytes.Buffer
+	rulesFile.WriteString("# Generated by go run ./cmd/monitoring-rules, DO NOT EDIT\n")
+	encoder := yaml.NewEncoder(&rulesFile)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(rules); err != nil {
+		logger.FatalLog("Failed to encode rules: %v", err)
+	}
+	dashboardFile, err := json.MarshalIndent(dashboard, "", "  ")
+	if err != nil {
+		logger.FatalLog("Failed to encode dashboard: %v", err)
+	}
+
+	files := map[string][]byte{
+		filepath.Join(*outDir, "prometheus", "rules.yml"):   rulesFile.Bytes(),
+		filepath.Join(*outDir, "grafana", "dashboard.json"): append(dashboardFile, '\n'),
+	}
+	stale := false
+	for path, content := range files {
+		if *check {
+			current, err := os.ReadFile(path)
+			if err != nil || !bytes.Equal(current, content) {
+				logger.ErrorLog("%s is out of date, run go run ./cmd/monitoring-rules", path)
+				stale = true
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			logger.FatalLog("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			logger.FatalLog("Failed to write %s: %v", path, err)
+		}
+		logger.InfoLog("Wrote %s", path)
+	}
+	if stale {
+		os.Exit(1)
+	}
+}
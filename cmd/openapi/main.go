@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/handlers"
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/openapi"
+)
+
+// Writes the OpenAPI spec served at GET /openapi.json to docs/openapi.json:
+//
+//	go run ./cmd/openapi          # regenerate after changing a route or a model
+//	go run ./cmd/openapi -check   # fails when docs/openapi.json is out of date
+func main() {
+	out := flag.String("out", "docs/openapi.json", "file the spec is written to")
+	check := flag.Bool("check", false, "only check the file is up to date")
+	flag.Parse()
+
+	spec, err := json.MarshalIndent(openapi.Spec(handlers.APIInfo, handlers.Routes(nil, nil, nil, nil, nil, nil, nil)), "", "  ")
+	if err != nil {
+		logger.FatalLog("Failed to encode spec: %v", err)
+	}
+	spec = append(spec, '\n')
+
+	if *check {
+		current, err := os.ReadFile(*out)
+		if err != nil || !bytes.Equal(current, spec) {
+			logger.ErrorLog("%s is out of date, a route or model changed: run go run ./cmd/openapi and review the diff", *out)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := os.WriteFile(*out, spec, 0o644); err != nil {
+		logger.FatalLog("Failed to write %s: %v", *out, err)
+	}
+	logger.InfoLog("Wrote %s", *out)
+}
@@ -0,0 +1,28 @@
+package apihelpers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AdminAuthMiddleware only lets through requests carrying the admin token as a bearer token.
+// The token is looked up on every request so it can be rotated without a restart.
+// When no token is configured the admin API is disabled altogether.
+func AdminAuthMiddleware(token func() string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := token()
+			if token == "" {
+				ErrorResponse(r, w, http.StatusForbidden, "Admin API is disabled")
+				return
+			}
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				ErrorResponse(r, w, http.StatusUnauthorized, "Invalid admin token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,11 +1,8 @@
 package apihelpers
 
 import (
-	"cmp"
 	"encoding/json"
-	"errors"
 	"net/http"
-	"strconv"
 )
 
 func SuccessResponse(r *http.Request, w http.ResponseWriter, status int, result any, message string) {
@@ -32,18 +29,14 @@ func ErrorResponse(r *http.Request, w http.ResponseWriter, status int, message s
 	)
 }
 
-type PaginationOptions struct {
-	Limit  int
-	Offset int
-}
-
-func (p *PaginationOptions) Default() {
-	if p.Limit == 0 {
-		p.Limit = 25
-	}
-	if p.Offset == 0 {
-		p.Offset = 0
-	}
+// ErrorResponseWithResult is like ErrorResponse, with a result explaining the failure (e.g. a health report)
+func ErrorResponseWithResult(r *http.Request, w http.ResponseWriter, status int, message string, result any) {
+	traceID := GetTraceId(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(
+		map[string]any{"success": false, "trace_id": traceID, "message": message, "result": result},
+	)
 }
 
 type SortOrderOptions struct {
@@ -55,27 +48,3 @@ func (s *SortOrderOptions) Default() {
 		s.Order = "desc"
 	}
 }
-
-func Pagination(r *http.Request) (PaginationOptions, func(count int, total int) map[string]int, error) {
-	opts := PaginationOptions{}
-	query := r.URL.Query()
-	page, err := strconv.Atoi(cmp.Or(query.Get("page"), "1"))
-	if err != nil || page < 1 {
-		return opts, nil, errors.New("invalid value for query param `page` provided")
-	}
-	rows, err := strconv.Atoi(cmp.Or(query.Get("rows"), "25"))
-	if err != nil {
-		return opts, nil, errors.New("invalid value for query param `rows` provided")
-	}
-	opts.Limit = rows
-	opts.Offset = (page - 1) * rows
-
-	getPaginationObject := func(count int, total int) map[string]int {
-		totalPages := total / rows
-		if total%rows > 0 {
-			totalPages++
-		}
-		return map[string]int{"page_number": page, "total_pages": totalPages, "total_rows": count, "page_size": rows}
-	}
-	return opts, getPaginationObject, nil
-}
@@ -0,0 +1,153 @@
+package apihelpers
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	DefaultPageSize = 25
+	MaxPageSize     = 100
+)
+
+// Cursor marks the last row of a page of a keyset paginated list, the next page starts after it.
+// Clients get it encoded (see Encode) and pass it back as is.
+type Cursor struct {
+	// sort the cursor was issued for, e.g. "created_at desc", a cursor can't be used with another one
+	Sort string `json:"s"`
+	// sort column value of the last row, as text
+	Value string `json:"v"`
+	// id of the last row, breaking ties between rows with the same Value
+	ID string `json:"id"`
+}
+
+// Encode returns the cursor as an opaque url safe string
+func (c Cursor) Encode() string {
+	encoded, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+var ErrInvalidCursor = errors.New("invalid value for query param `cursor` provided")
+
+// DecodeCursor parses a cursor returned by Encode
+func DecodeCursor(value string) (Cursor, error) {
+	var cursor Cursor
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(decoded, &cursor) != nil || cursor.ID == "" {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// PaginationOptions selects a page of a list, either by offset (page mode) or after a cursor (cursor mode)
+type PaginationOptions struct {
+	Limit  int
+	Offset int
+	// cursor mode: the page starts after this row, nil for the first page
+	After *Cursor
+	// whether the list uses cursors, rather than offsets
+	Keyset bool
+	// whether the total number of rows is returned, always in page mode
+	WithTotal bool
+}
+
+func (p *PaginationOptions) Default() {
+	if p.Limit <= 0 {
+		p.Limit = DefaultPageSize
+	}
+}
+
+// Pages describes the page of a list in page mode
+type Pages struct {
+	PageNumber int `json:"page_number"`
+	TotalPages int `json:"total_pages"`
+	TotalRows  int `json:"total_rows"` // rows in this page
+	PageSize   int `json:"page_size"`
+}
+
+// Pagination reads the pagination query params of a list:
+//   - rows: page size, 1 to MaxPageSize
+//   - cursor: selects cursor mode, empty for the first page, then the next_cursor of the previous page
+//   - include_total: also count the rows in cursor mode, which costs a query
+//   - page: page number in page mode, the default mode for backwards compatibility
+func Pagination(r *http.Request) (PaginationOptions, error) {
+	query := r.URL.Query()
+	if query.Has("cursor") {
+		if query.Has("page") {
+			return PaginationOptions{}, errors.New("query params `page` and `cursor` can't be used together")
+		}
+		return CursorPagination(r)
+	}
+
+	opts, err := pageSize(query)
+	if err != nil {
+		return opts, err
+	}
+	page, err := strconv.Atoi(cmp.Or(query.Get("page"), "1"))
+	if err != nil || page < 1 {
+		return opts, errors.New("invalid value for query param `page` provided")
+	}
+	opts.Offset = (page - 1) * opts.Limit
+	opts.WithTotal = true
+	return opts, nil
+}
+
+// CursorPagination reads the pagination query params of a list only paginated by cursor, like
+// Pagination in cursor mode except that the cursor may be left out for the first page
+func CursorPagination(r *http.Request) (PaginationOptions, error) {
+	query := r.URL.Query()
+	if query.Has("page") {
+		return PaginationOptions{}, errors.New("query param `page` can't be used, this list is paginated by `cursor`")
+	}
+	opts, err := pageSize(query)
+	if err != nil {
+		return opts, err
+	}
+	opts.Keyset = true
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := DecodeCursor(value)
+		if err != nil {
+			return opts, err
+		}
+		opts.After = &cursor
+	}
+	if value := query.Get("include_total"); value != "" {
+		if opts.WithTotal, err = strconv.ParseBool(value); err != nil {
+			return opts, errors.New("invalid value for query param `include_total` provided")
+		}
+	}
+	return opts, nil
+}
+
+func pageSize(query url.Values) (PaginationOptions, error) {
+	rows, err := strconv.Atoi(cmp.Or(query.Get("rows"), strconv.Itoa(DefaultPageSize)))
+	if err != nil || rows < 1 || rows > MaxPageSize {
+		return PaginationOptions{}, errors.New("invalid value for query param `rows` provided, it must be between 1 and " + strconv.Itoa(MaxPageSize))
+	}
+	return PaginationOptions{Limit: rows}, nil
+}
+
+// Pages describes the page of opts in page mode, count is the number of rows in the page
+func (p PaginationOptions) Pages(count, total int) *Pages {
+	totalPages := total / p.Limit
+	if total%p.Limit > 0 {
+		totalPages++
+	}
+	return &Pages{PageNumber: p.Offset/p.Limit + 1, TotalPages: totalPages, TotalRows: count, PageSize: p.Limit}
+}
+
+// NextCursor trims the extra row fetched to know whether there is a next page (lists in cursor
+// mode fetch Limit+1 rows) and returns the cursor of the next page, empty on the last page.
+// cursor builds the cursor of a row.
+func NextCursor[T any](opts PaginationOptions, rows *[]T, cursor func(row T) Cursor) string {
+	if len(*rows) <= opts.Limit {
+		return ""
+	}
+	*rows = (*rows)[:opts.Limit]
+	return cursor((*rows)[opts.Limit-1]).Encode()
+}
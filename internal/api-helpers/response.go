@@ -0,0 +1,60 @@
+package apihelpers
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// ResponseRecorder is a wrapper for http.ResponseWriter that captures the status code
+// and the number of body bytes written, shared by the logging and metrics middlewares
+type ResponseRecorder struct {
+	http.ResponseWriter
+	StatusCode  int
+	Size        int64
+	wroteHeader bool
+}
+
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, StatusCode: http.StatusOK}
+}
+
+func (rw *ResponseRecorder) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.StatusCode = code
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *ResponseRecorder) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(b)
+	rw.Size += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. to flush streams)
+func (rw *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Hijack lets handlers take over the connection (e.g. for WebSockets), which is recorded as
+// 101 Switching Protocols unless a status was written before
+func (rw *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil && !rw.wroteHeader {
+		rw.StatusCode = http.StatusSwitchingProtocols
+		rw.wroteHeader = true
+	}
+	return conn, buf, err
+}
+
+// ClientIP returns the IP address of the client without the port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
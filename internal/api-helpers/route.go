@@ -0,0 +1,39 @@
+package apihelpers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type routeKey struct{}
+
+// UnmatchedRoute is the route of requests which don't match any registered pattern
+const UnmatchedRoute = "unmatched"
+
+// RouteMiddleware looks up the ServeMux pattern matching each request (e.g. /ads/{id}) up front,
+// so middlewares running outside the mux can label requests by route instead of by raw path
+func RouteMiddleware(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := UnmatchedRoute
+			if _, pattern := mux.Handler(r); pattern != "" {
+				// drop the method, it is labelled separately
+				if _, path, ok := strings.Cut(pattern, " "); ok {
+					pattern = path
+				}
+				route = pattern
+			}
+			ctx := context.WithValue(r.Context(), routeKey{}, route)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetRoute retrieves the route pattern matching the request
+func GetRoute(r *http.Request) string {
+	if route, ok := r.Context().Value(routeKey{}).(string); ok {
+		return route
+	}
+	return UnmatchedRoute
+}
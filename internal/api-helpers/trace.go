@@ -2,33 +2,110 @@ package apihelpers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"regexp"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type traceIDKey string
+var tracer = otel.Tracer("github.com/JalajGoswami/video-ad-metrics/internal/api-helpers")
 
-const TraceIDKey traceIDKey = "x-trace-id"
+type requestIDKey struct{}
 
-// TraceMiddleware adds a trace ID to each request
+// inbound request IDs are copied into logs, metric exemplars and SQL comments,
+// so only short IDs made of safe characters are accepted
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestIDMiddleware reuses the request ID assigned upstream (e.g. by the edge proxy) in
+// header, or generates one when it is missing or invalid, and echoes it in the response header
+func RequestIDMiddleware(header string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := r.Header.Get(header)
+			if !validRequestID.MatchString(requestID) {
+				requestID = uuid.New().String()
+			}
+			w.Header().Set(header, requestID)
+
+			ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequestIDFromContext retrieves the request ID, empty outside of requests
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// GetRequestId retrieves the request ID from the request context
+func GetRequestId(r *http.Request) string {
+	if requestID := RequestIDFromContext(r.Context()); requestID != "" {
+		return requestID
+	}
+	return "-"
+}
+
+// TraceMiddleware starts a server span for each request, continuing the trace of the
+// caller when it sends a W3C traceparent header. Its trace ID is the request's trace ID.
 func TraceMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Generate a trace ID for this request
-		traceID := uuid.New().String()
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		route := GetRoute(r)
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(ClientIP(r)),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+		if requestID := RequestIDFromContext(ctx); requestID != "" {
+			span.SetAttributes(attribute.String("request_id", requestID))
+		}
+
+		recorder := NewResponseRecorder(w)
 
-		// Add trace ID to the request context
-		ctx := context.WithValue(r.Context(), TraceIDKey, traceID)
-		r = r.WithContext(ctx)
+		// set before the span ends, also when the handler aborts the response by panicking
+		completed := false
+		defer func() {
+			span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.StatusCode))
+			if !completed {
+				span.SetStatus(codes.Error, "response aborted")
+			} else if recorder.StatusCode >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", recorder.StatusCode))
+			}
+		}()
 
 		// Call the next handler
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		completed = true
 	})
 }
 
-// GetTraceId retrieves the trace ID from the request context
+// TraceIDFromContext retrieves the trace ID of the span in ctx, empty when there is none
+func TraceIDFromContext(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+// GetTraceId retrieves the trace ID of the request's span
 func GetTraceId(r *http.Request) string {
-	if traceID, ok := r.Context().Value(TraceIDKey).(string); ok {
+	if traceID := TraceIDFromContext(r.Context()); traceID != "" {
 		return traceID
 	}
 	return "-"
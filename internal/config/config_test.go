@@ -0,0 +1,48 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		set   func(c *Config)
+		error string // empty when the config is valid
+	}{
+		{name: "no idle connections", set: func(c *Config) { c.Database.MaxIdleConns = 0 }},
+		{
+			name:  "more idle than open connections",
+			set:   func(c *Config) { c.Database.MaxOpenConns, c.Database.MaxIdleConns = 3, 5 },
+			error: "database.max_idle_conns",
+		},
+		{name: "purge disabled", set: func(c *Config) { c.Archive.RetentionDays, c.Archive.PurgeAfterDays = 400, 0 }},
+		{name: "purge after retention", set: func(c *Config) { c.Archive.RetentionDays, c.Archive.PurgeAfterDays = 30, 62 }},
+		{
+			name:  "purge before retention",
+			set:   func(c *Config) { c.Archive.RetentionDays, c.Archive.PurgeAfterDays = 400, 62 },
+			error: "archive.purge_after_days must be 0 (keep forever) or more than archive.retention_days",
+		},
+		{
+			name:  "purge with retention",
+			set:   func(c *Config) { c.Archive.RetentionDays, c.Archive.PurgeAfterDays = 90, 90 },
+			error: "more than archive.retention_days",
+		},
+	}
+	for _, tt := range tests {
+		c := Default()
+		tt.set(c)
+		err := c.Validate()
+		switch {
+		case tt.error == "" && err != nil:
+			t.Errorf("%s: Validate() = %v, want no error", tt.name, err)
+		case tt.error != "" && (err == nil || !strings.Contains(err.Error(), tt.error)):
+			t.Errorf("%s: Validate() = %v, want %q", tt.name, err, tt.error)
+		}
+	}
+}
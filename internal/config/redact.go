@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+// Redacted returns the config as YAML with secrets hidden, safe to print in logs.
+// Fields tagged secret:"url" only have their password hidden, other secrets are hidden entirely.
+func (c *Config) Redacted() string {
+	copied := *c
+	copied.Database.ReplicaURLs = append([]string(nil), c.Database.ReplicaURLs...)
+	redactSecrets(reflect.ValueOf(&copied).Elem())
+
+	out, err := yaml.Marshal(&copied)
+	if err != nil {
+		return fmt.Sprintf("failed to print config: %v", err)
+	}
+	return string(out)
+}
+
+func redactSecrets(v reflect.Value) {
+	for i := range v.NumField() {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			redactSecrets(field)
+			continue
+		}
+
+		switch v.Type().Field(i).Tag.Get("secret") {
+		case "url":
+			if field.Kind() == reflect.Slice {
+				for j := range field.Len() {
+					field.Index(j).SetString(redactURL(field.Index(j).String()))
+				}
+			} else {
+				field.SetString(redactURL(field.String()))
+			}
+		case "true":
+			if !field.IsZero() {
+				field.SetString(redacted)
+			}
+		}
+	}
+}
+
+// redactURL hides the password of a url, or the whole value if it can't be parsed
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return redacted
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"gopkg.in/yaml.v3"
+)
+
+const fileCheckInterval = 5 * time.Second
+
+// Runtime holds the effective config and reloads it on SIGHUP or when the config file changes.
+// Only fields tagged reload:"true" are applied live, changes to other fields are rejected
+// (and logged) since they need a restart, the rest of the reload still goes through.
+type Runtime struct {
+	path string
+
+	mu          sync.RWMutex
+	current     *Config
+	modTime     time.Time
+	subscribers []func(old, new *Config)
+}
+
+// NewRuntime creates a new Runtime starting from cfg, loaded from path
+func NewRuntime(path string, cfg *Config) *Runtime {
+	r := &Runtime{path: path, current: cfg}
+	if info, err := os.Stat(path); err == nil {
+		r.modTime = info.ModTime()
+	}
+	return r
+}
+
+// Current returns the effective config, it must not be modified
+func (r *Runtime) Current() *Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// OnChange registers fn to be called with the previous and new config after every reload applying changes
+func (r *Runtime) OnChange(fn func(old, new *Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribers = append(r.subscribers, fn)
+}
+
+// Reload loads the config again and applies the changes which are safe to apply live
+func (r *Runtime) Reload() error {
+	loaded, err := Load(r.path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	old := r.current
+	next := *old
+	var applied, rejected []string
+	mergeReloadable(reflect.ValueOf(&next).Elem(), reflect.ValueOf(loaded).Elem(), "", &applied, &rejected)
+	if len(applied) > 0 {
+		r.current = &next
+	}
+	subscribers := r.subscribers
+	r.mu.Unlock()
+
+	for _, field := range rejected {
+		logger.ErrorLog("Config change to %s rejected, it requires a restart", field)
+	}
+	if len(applied) == 0 {
+		logger.InfoLog("Config reloaded, nothing to apply")
+		return nil
+	}
+	logger.InfoLog("Config reloaded, applied changes to %s", strings.Join(applied, ", "))
+	for _, fn := range subscribers {
+		fn(old, &next)
+	}
+	return nil
+}
+
+// mergeReloadable copies the reloadable fields of loaded which differ into current,
+// collecting the yaml paths of the fields applied and rejected
+func mergeReloadable(current, loaded reflect.Value, prefix string, applied, rejected *[]string) {
+	for i := range current.NumField() {
+		fieldType := current.Type().Field(i)
+		name := prefix + strings.Split(fieldType.Tag.Get("yaml"), ",")[0]
+		if fieldType.Type.Kind() == reflect.Struct {
+			mergeReloadable(current.Field(i), loaded.Field(i), name+".", applied, rejected)
+			continue
+		}
+
+		if reflect.DeepEqual(current.Field(i).Interface(), loaded.Field(i).Interface()) {
+			continue
+		}
+		if fieldType.Tag.Get("reload") == "true" {
+			current.Field(i).Set(loaded.Field(i))
+			*applied = append(*applied, name)
+		} else {
+			*rejected = append(*rejected, name)
+		}
+	}
+}
+
+// Watch reloads the config on SIGHUP and whenever the config file changes, until ctx is cancelled
+func (r *Runtime) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(fileCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logger.InfoLog("Received SIGHUP, reloading config")
+			r.reloadAndLog()
+		case <-ticker.C:
+			if r.fileChanged() {
+				logger.InfoLog("Config file %s changed, reloading config", r.path)
+				r.reloadAndLog()
+			}
+		}
+	}
+}
+
+func (r *Runtime) reloadAndLog() {
+	if err := r.Reload(); err != nil {
+		logger.ErrorLog("Failed to reload config, keeping the current one: %v", err)
+	}
+}
+
+func (r *Runtime) fileChanged() bool {
+	if r.path == "" {
+		return false
+	}
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info.ModTime().Equal(r.modTime) {
+		return false
+	}
+	r.modTime = info.ModTime()
+	return true
+}
+
+// RedactedMap returns the effective config with secrets hidden, shaped like the config file
+func (r *Runtime) RedactedMap() (map[string]any, error) {
+	var out map[string]any
+	if err := yaml.Unmarshal([]byte(r.Current().Redacted()), &out); err != nil {
+		return nil, fmt.Errorf("failed to convert config: %w", err)
+	}
+	return out, nil
+}
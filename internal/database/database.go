@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"errors"
+	"strconv"
 	"time"
 
 	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
@@ -17,32 +19,276 @@ var (
 // like mock database for testing
 type Repository interface {
 	// Config operations
-	Setup() error
-	Ping() error
+	Setup(ctx context.Context) error
+	Ping(ctx context.Context) error
+	CheckSchema(ctx context.Context) error
 	Close() error
 
 	// Ad operations
-	CreateAd(ad *models.Ad) error
-	GetAd(id string) (*models.Ad, error)
-	ListAds(opts ListAdOptions) (*[]models.Ad, error)
-	CountAds(opts ListAdOptions) (int, error)
+	CreateAd(ctx context.Context, ad *models.Ad) error
+	GetAd(ctx context.Context, id string) (*models.Ad, error)
+	UpdateAd(ctx context.Context, id string, update models.AdUpdate) (*models.Ad, error)
+	ListAds(ctx context.Context, opts ListAdOptions) (*[]models.ListedAd, error)
+	CountAds(ctx context.Context, opts ListAdOptions) (int, error)
 
 	// Click operations
-	LogClick(click *models.Click) error
-	ArchiveOldClicks() error
+	LogClick(ctx context.Context, click *models.Click) error
+	ListClicks(ctx context.Context, opts ListClickOptions) (*[]models.ListedClick, error)
+	CountClicks(ctx context.Context, opts ListClickOptions) (int, error)
+	ExportClicks(ctx context.Context, filter ClickFilter, fn func(click models.ListedClick) error) error
+	ArchiveOldClicks(ctx context.Context, opts ArchiveOptions) (int64, error)
+	PurgeArchivedClicks(ctx context.Context, opts ArchiveOptions) (int64, error)
 
 	// Analytics operations
-	GetAdAnalytics(adID string, rangeDate time.Time) (*models.AdAnalyticsData, error)
-	GetAdsAnalytics(rangeDate time.Time) (*models.AnalyticsData, error)
+	GetAdAnalytics(ctx context.Context, adID string, rangeDate time.Time) (*models.AdAnalyticsData, error)
+	GetAdsAnalytics(ctx context.Context, rangeDate time.Time) (*models.AnalyticsData, error)
+	RollupMonthlyAnalytics(ctx context.Context, from time.Time) (int64, error)
+
+	// Report operations
+	CreateReportSpec(ctx context.Context, spec *models.ReportSpec) error
+	GetReportSpec(ctx context.Context, id string) (*models.ReportSpec, error)
+	ListReportSpecs(ctx context.Context) (*[]models.ReportSpec, error)
+	DeleteReportSpec(ctx context.Context, id string) error
+	ListDueReportSpecs(ctx context.Context, now time.Time) (*[]models.ReportSpec, error)
+	SetReportSpecRun(ctx context.Context, id string, lastRun, nextRun time.Time) error
+	GetReportRows(ctx context.Context, query ReportQuery) (*[]models.ReportRow, error)
+	CreateReport(ctx context.Context, report *models.GeneratedReport) error
+	SetReportDelivery(ctx context.Context, id, status string, deliveryError *string) error
+	GetReport(ctx context.Context, id string) (*models.GeneratedReport, error)
+	ListReports(ctx context.Context, opts ListReportOptions) (*[]models.GeneratedReport, error)
+
+	// Webhook operations
+	CreateWebhook(ctx context.Context, webhook *models.Webhook) error
+	ListWebhooks(ctx context.Context) (*[]models.Webhook, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	CreateWebhookRule(ctx context.Context, rule *models.WebhookRule) error
+	ListWebhookRules(ctx context.Context, webhookID string) (*[]models.WebhookRule, error)
+	DeleteWebhookRule(ctx context.Context, webhookID, id string) error
+	TriggerWebhookRule(ctx context.Context, id string, delivery *models.WebhookDelivery) error
+	ResetWebhookRule(ctx context.Context, id string) error
+	ListDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) (*[]models.WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	ListWebhookDeliveries(ctx context.Context, opts ListWebhookDeliveryOptions) (*[]models.WebhookDelivery, error)
+
+	// Job operations
+	TryAdvisoryLock(ctx context.Context, key string) (release func(), acquired bool, err error)
+	CreateJobRun(ctx context.Context, run *models.JobRun) error
+	FinishJobRun(ctx context.Context, run *models.JobRun) error
+	ListJobRuns(ctx context.Context, name string, limit int) (*[]models.JobRun, error)
+	GetLatestJobRuns(ctx context.Context) (*[]models.JobRun, error)
+	GetLastSuccessfulJobRun(ctx context.Context, name string) (*models.JobRun, error)
+	GetJobState(ctx context.Context, name string) (*models.JobState, error)
+	SetJobPaused(ctx context.Context, name string, paused bool) error
+
+	// Leader election operations
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (*models.Lease, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+	GetLease(ctx context.Context, name string) (*models.Lease, error)
 }
 
 type ListAdOptions struct {
 	apihelpers.PaginationOptions
 	apihelpers.SortOrderOptions
+	// searches the name and description, ranking the ads by relevance
 	Search string
+	// one of SearchModes, fulltext by default
+	SearchMode string
+	// one of AdSorts, relevance when searching and created_at otherwise by default
+	Sort string
+	// ads created from CreatedFrom (inclusive) to CreatedTo (exclusive), zero for no bound
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// host of the target url, also matching its subdomains
+	TargetDomain string
+	// ads with one of these AdStatuses, all when empty
+	Statuses []string
+	// ads of this campaign, all when empty
+	Campaign string
+	// embed the aggregate stats of every ad
+	IncludeAnalytics bool
+}
+
+// AdStatuses are the statuses of ads, ads are active when created unless told otherwise
+var AdStatuses = []string{"active", "paused", "archived"}
+
+// MaxCampaignLength is the length of the campaign column of ads
+const MaxCampaignLength = 255
+
+// AdSorts are the sorts of ListAds, the stats sorts join aggregated_analytics and
+// relevance requires a search
+var AdSorts = []string{"created_at", "name", "total_clicks", "total_playback_time", "relevance"}
+
+// adSortTypes are the SQL types of the sort columns, cursors holding their values as text
+var adSortTypes = map[string]string{
+	"created_at":          "TIMESTAMPTZ",
+	"name":                "TEXT",
+	"total_clicks":        "INTEGER",
+	"total_playback_time": "INTEGER",
+	"relevance":           "FLOAT8",
 }
 
 func (o *ListAdOptions) Default() {
 	o.PaginationOptions.Default()
 	o.SortOrderOptions.Default()
+	if o.SearchMode == "" {
+		o.SearchMode = "fulltext"
+	}
+	if o.Sort == "" && o.Search != "" {
+		o.Sort = "relevance"
+	} else if o.Sort == "" {
+		o.Sort = "created_at"
+	}
+}
+
+// joinsAnalytics tells whether the list needs the aggregate stats of the ads
+func (o ListAdOptions) joinsAnalytics() bool {
+	return o.IncludeAnalytics || o.Sort == "total_clicks" || o.Sort == "total_playback_time"
+}
+
+// CursorSort identifies the sort of the list, cursors issued for another sort are rejected
+func (o ListAdOptions) CursorSort() string {
+	return o.Sort + " " + o.Order
+}
+
+// AdCursor is the cursor of the page following ad in a list sorted by opts
+func AdCursor(ad models.ListedAd, opts ListAdOptions) apihelpers.Cursor {
+	cursor := apihelpers.Cursor{Sort: opts.CursorSort(), ID: ad.ID}
+	switch opts.Sort {
+	case "name":
+		cursor.Value = ad.Name
+	case "total_clicks":
+		cursor.Value = strconv.Itoa(ad.Analytics.TotalClicks)
+	case "total_playback_time":
+		cursor.Value = strconv.Itoa(ad.Analytics.TotalPlaybackTime)
+	case "relevance":
+		cursor.Value = strconv.FormatFloat(ad.Search.Rank, 'g', -1, 64)
+	default:
+		cursor.Value = ad.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return cursor
+}
+
+// ClickFilter selects clicks from both clicks and archived_clicks
+type ClickFilter struct {
+	// clicks on every ad when empty
+	AdID string
+	// clicks from From (inclusive) to To (exclusive) by timestamp, zero for no bound
+	From time.Time
+	To   time.Time
+}
+
+// ListClickOptions lists clicks by timestamp, in cursor mode only
+type ListClickOptions struct {
+	apihelpers.PaginationOptions
+	apihelpers.SortOrderOptions
+	ClickFilter
+}
+
+func (o *ListClickOptions) Default() {
+	o.PaginationOptions.Default()
+	o.SortOrderOptions.Default()
+}
+
+// CursorSort identifies the sort of the list, cursors issued for another sort are rejected
+func (o ListClickOptions) CursorSort() string {
+	return "timestamp " + o.Order
+}
+
+// ClickCursor is the cursor of the page following click in a list sorted by opts
+func ClickCursor(click models.ListedClick, opts ListClickOptions) apihelpers.Cursor {
+	return apihelpers.Cursor{Sort: opts.CursorSort(), Value: click.Timestamp.Format(time.RFC3339Nano), ID: click.ID}
+}
+
+// ReportQuery aggregates the clicks of a report, archived ones included
+type ReportQuery struct {
+	// ads covered, all ads when empty
+	AdIDs []string
+	// campaigns whose ads are covered, all campaigns when empty
+	Campaigns []string
+	// clicks from From (inclusive) to To (exclusive) by timestamp
+	From time.Time
+	To   time.Time
+	// group the clicks by ad
+	ByAd bool
+	// group the clicks by day, week or month (UTC), no time grouping when empty
+	Interval string
+}
+
+// ListReportOptions lists reports most recent first, in cursor mode only
+type ListReportOptions struct {
+	apihelpers.PaginationOptions
+	// reports of this spec, every spec when empty
+	SpecID string
+}
+
+func (o *ListReportOptions) Default() {
+	o.PaginationOptions.Default()
+}
+
+// CursorSort identifies the sort of the list, cursors issued for another list are rejected
+func (o ListReportOptions) CursorSort() string {
+	return "created_at desc"
+}
+
+// ReportCursor is the cursor of the page following report in a list of reports
+func ReportCursor(report models.GeneratedReport) apihelpers.Cursor {
+	return apihelpers.Cursor{Sort: ListReportOptions{}.CursorSort(), Value: report.CreatedAt.Format(time.RFC3339Nano), ID: report.ID}
+}
+
+// ListWebhookDeliveryOptions lists the deliveries of a webhook most recent first, in cursor mode only
+type ListWebhookDeliveryOptions struct {
+	apihelpers.PaginationOptions
+	WebhookID string
+}
+
+func (o *ListWebhookDeliveryOptions) Default() {
+	o.PaginationOptions.Default()
+}
+
+// CursorSort identifies the sort of the list, cursors issued for another list are rejected
+func (o ListWebhookDeliveryOptions) CursorSort() string {
+	return "created_at desc"
+}
+
+// WebhookDeliveryCursor is the cursor of the page following delivery in a list of deliveries
+func WebhookDeliveryCursor(delivery models.WebhookDelivery) apihelpers.Cursor {
+	return apihelpers.Cursor{Sort: ListWebhookDeliveryOptions{}.CursorSort(), Value: delivery.CreatedAt.Format(time.RFC3339Nano), ID: delivery.ID}
+}
+
+// ArchiveOptions controls how old clicks are moved to (or purged from) the archived_clicks table
+type ArchiveOptions struct {
+	// clicks older than this are archived
+	Retention time.Duration
+	// max number of clicks moved per transaction
+	BatchSize int
+}
+
+func (o *ArchiveOptions) Default() {
+	if o.Retention <= 0 {
+		o.Retention = 30 * 24 * time.Hour
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 1000
+	}
+}
+
+// PoolOptions configures the connection pool of the primary and of every read replica
+type PoolOptions struct {
+	MaxOpenConns int
+	// connections kept open while idle, 0 closes them once released, 5 (at most MaxOpenConns) when nil
+	MaxIdleConns    *int
+	ConnMaxLifetime time.Duration
+}
+
+func (o *PoolOptions) Default() {
+	if o.MaxOpenConns <= 0 {
+		o.MaxOpenConns = 25
+	}
+	if o.MaxIdleConns == nil {
+		idle := min(5, o.MaxOpenConns)
+		o.MaxIdleConns = &idle
+	}
+	if o.ConnMaxLifetime <= 0 {
+		o.ConnMaxLifetime = 5 * time.Minute
+	}
 }
@@ -0,0 +1,464 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/JalajGoswami/video-ad-metrics/internal/monitoring"
+)
+
+// InstrumentedRepository wraps another Repository, recording the latency and errors of every
+// method and logging calls slower than the slow query threshold. Parameters of slow calls are
+// logged redacted: numbers, booleans and times are kept, strings are hidden. Spans are left to
+// the wrapped Repository, PostgresDB starts one per method.
+type InstrumentedRepository struct {
+	next               Repository
+	slowQueryThreshold atomic.Int64
+}
+
+// NewInstrumentedRepository creates a new InstrumentedRepository around next,
+// a zero slowQueryThreshold disables the slow query log
+func NewInstrumentedRepository(next Repository, slowQueryThreshold time.Duration) *InstrumentedRepository {
+	r := &InstrumentedRepository{next: next}
+	r.SetSlowQueryThreshold(slowQueryThreshold)
+	return r
+}
+
+// SetSlowQueryThreshold changes the duration above which calls are logged, it is safe to call while serving queries
+func (r *InstrumentedRepository) SetSlowQueryThreshold(threshold time.Duration) {
+	r.slowQueryThreshold.Store(int64(threshold))
+}
+
+// observe runs call as the method with the given key/value params, recording its metrics and slow log
+func (r *InstrumentedRepository) observe(ctx context.Context, method string, call func(ctx context.Context) error, params ...any) error {
+	start := time.Now()
+	err := call(ctx)
+	duration := time.Since(start)
+
+	monitoring.ObserveDatabaseQuery(method, duration)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		monitoring.IncrementDatabaseQueryErrors(method)
+	}
+
+	if threshold := time.Duration(r.slowQueryThreshold.Load()); threshold > 0 && duration >= threshold {
+		attrs := []slog.Attr{
+			slog.String("method", method),
+			slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+		}
+		for i := 0; i+1 < len(params); i += 2 {
+			attrs = append(attrs, slog.Any("param."+fmt.Sprint(params[i]), redactParam(reflect.ValueOf(params[i+1]))))
+		}
+		logger.ContextLog(ctx, logger.LevelInfo, "Slow database query", attrs...)
+	}
+	return err
+}
+
+// redactParam keeps the values which can't identify a user or an ad, so slow queries can be reproduced
+func redactParam(v reflect.Value) any {
+	if !v.IsValid() {
+		return nil
+	}
+	switch value := v.Interface().(type) {
+	case time.Time:
+		return value.Format(time.RFC3339)
+	case time.Duration:
+		return value.String()
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return redactParam(v.Elem())
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return v.Interface()
+	case reflect.String:
+		if v.Len() == 0 {
+			return ""
+		}
+		return fmt.Sprintf("[REDACTED %d chars]", v.Len())
+	case reflect.Struct:
+		fields := map[string]any{}
+		for i := range v.NumField() {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if field.Anonymous {
+				if embedded, ok := redactParam(v.Field(i)).(map[string]any); ok {
+					for name, value := range embedded {
+						fields[name] = value
+					}
+					continue
+				}
+			}
+			fields[field.Name] = redactParam(v.Field(i))
+		}
+		return fields
+	}
+	return "[REDACTED]"
+}
+
+func (r *InstrumentedRepository) Setup(ctx context.Context) error {
+	return r.observe(ctx, "Setup", r.next.Setup)
+}
+
+func (r *InstrumentedRepository) Ping(ctx context.Context) error {
+	return r.observe(ctx, "Ping", r.next.Ping)
+}
+
+func (r *InstrumentedRepository) CheckSchema(ctx context.Context) error {
+	return r.observe(ctx, "CheckSchema", r.next.CheckSchema)
+}
+
+func (r *InstrumentedRepository) Close() error {
+	return r.next.Close()
+}
+
+func (r *InstrumentedRepository) CreateAd(ctx context.Context, ad *models.Ad) error {
+	return r.observe(ctx, "CreateAd", func(ctx context.Context) error {
+		return r.next.CreateAd(ctx, ad)
+	}, "ad", ad)
+}
+
+func (r *InstrumentedRepository) GetAd(ctx context.Context, id string) (ad *models.Ad, err error) {
+	err = r.observe(ctx, "GetAd", func(ctx context.Context) error {
+		ad, err = r.next.GetAd(ctx, id)
+		return err
+	}, "id", id)
+	return ad, err
+}
+
+func (r *InstrumentedRepository) UpdateAd(ctx context.Context, id string, update models.AdUpdate) (ad *models.Ad, err error) {
+	err = r.observe(ctx, "UpdateAd", func(ctx context.Context) error {
+		ad, err = r.next.UpdateAd(ctx, id, update)
+		return err
+	}, "id", id, "update", update)
+	return ad, err
+}
+
+func (r *InstrumentedRepository) ListAds(ctx context.Context, opts ListAdOptions) (ads *[]models.ListedAd, err error) {
+	err = r.observe(ctx, "ListAds", func(ctx context.Context) error {
+		ads, err = r.next.ListAds(ctx, opts)
+		return err
+	}, "opts", opts)
+	return ads, err
+}
+
+func (r *InstrumentedRepository) CountAds(ctx context.Context, opts ListAdOptions) (count int, err error) {
+	err = r.observe(ctx, "CountAds", func(ctx context.Context) error {
+		count, err = r.next.CountAds(ctx, opts)
+		return err
+	}, "opts", opts)
+	return count, err
+}
+
+func (r *InstrumentedRepository) LogClick(ctx context.Context, click *models.Click) error {
+	return r.observe(ctx, "LogClick", func(ctx context.Context) error {
+		return r.next.LogClick(ctx, click)
+	}, "click", click)
+}
+
+func (r *InstrumentedRepository) ListClicks(ctx context.Context, opts ListClickOptions) (clicks *[]models.ListedClick, err error) {
+	err = r.observe(ctx, "ListClicks", func(ctx context.Context) error {
+		clicks, err = r.next.ListClicks(ctx, opts)
+		return err
+	}, "opts", opts)
+	return clicks, err
+}
+
+func (r *InstrumentedRepository) CountClicks(ctx context.Context, opts ListClickOptions) (count int, err error) {
+	err = r.observe(ctx, "CountClicks", func(ctx context.Context) error {
+		count, err = r.next.CountClicks(ctx, opts)
+		return err
+	}, "opts", opts)
+	return count, err
+}
+
+// ExportClicks is observed as a whole, its duration includes the time fn takes to write the export
+func (r *InstrumentedRepository) ExportClicks(ctx context.Context, filter ClickFilter, fn func(click models.ListedClick) error) error {
+	return r.observe(ctx, "ExportClicks", func(ctx context.Context) error {
+		return r.next.ExportClicks(ctx, filter, fn)
+	}, "filter", filter)
+}
+
+func (r *InstrumentedRepository) ArchiveOldClicks(ctx context.Context, opts ArchiveOptions) (archived int64, err error) {
+	err = r.observe(ctx, "ArchiveOldClicks", func(ctx context.Context) error {
+		archived, err = r.next.ArchiveOldClicks(ctx, opts)
+		return err
+	}, "opts", opts)
+	return archived, err
+}
+
+func (r *InstrumentedRepository) PurgeArchivedClicks(ctx context.Context, opts ArchiveOptions) (purged int64, err error) {
+	err = r.observe(ctx, "PurgeArchivedClicks", func(ctx context.Context) error {
+		purged, err = r.next.PurgeArchivedClicks(ctx, opts)
+		return err
+	}, "opts", opts)
+	return purged, err
+}
+
+func (r *InstrumentedRepository) GetAdAnalytics(ctx context.Context, adID string, rangeDate time.Time) (analytics *models.AdAnalyticsData, err error) {
+	err = r.observe(ctx, "GetAdAnalytics", func(ctx context.Context) error {
+		analytics, err = r.next.GetAdAnalytics(ctx, adID, rangeDate)
+		return err
+	}, "ad_id", adID, "range_date", rangeDate)
+	return analytics, err
+}
+
+func (r *InstrumentedRepository) GetAdsAnalytics(ctx context.Context, rangeDate time.Time) (analytics *models.AnalyticsData, err error) {
+	err = r.observe(ctx, "GetAdsAnalytics", func(ctx context.Context) error {
+		analytics, err = r.next.GetAdsAnalytics(ctx, rangeDate)
+		return err
+	}, "range_date", rangeDate)
+	return analytics, err
+}
+
+func (r *InstrumentedRepository) RollupMonthlyAnalytics(ctx context.Context, from time.Time) (rows int64, err error) {
+	err = r.observe(ctx, "RollupMonthlyAnalytics", func(ctx context.Context) error {
+		rows, err = r.next.RollupMonthlyAnalytics(ctx, from)
+		return err
+	}, "from", from)
+	return rows, err
+}
+
+func (r *InstrumentedRepository) TryAdvisoryLock(ctx context.Context, key string) (release func(), acquired bool, err error) {
+	// only taking the lock is measured, it is held for as long as the caller needs
+	err = r.observe(ctx, "TryAdvisoryLock", func(ctx context.Context) error {
+		release, acquired, err = r.next.TryAdvisoryLock(ctx, key)
+		return err
+	}, "key", key)
+	return release, acquired, err
+}
+
+func (r *InstrumentedRepository) CreateJobRun(ctx context.Context, run *models.JobRun) error {
+	return r.observe(ctx, "CreateJobRun", func(ctx context.Context) error {
+		return r.next.CreateJobRun(ctx, run)
+	}, "run", run)
+}
+
+func (r *InstrumentedRepository) FinishJobRun(ctx context.Context, run *models.JobRun) error {
+	return r.observe(ctx, "FinishJobRun", func(ctx context.Context) error {
+		return r.next.FinishJobRun(ctx, run)
+	}, "run", run)
+}
+
+func (r *InstrumentedRepository) ListJobRuns(ctx context.Context, name string, limit int) (runs *[]models.JobRun, err error) {
+	err = r.observe(ctx, "ListJobRuns", func(ctx context.Context) error {
+		runs, err = r.next.ListJobRuns(ctx, name, limit)
+		return err
+	}, "name", name, "limit", limit)
+	return runs, err
+}
+
+func (r *InstrumentedRepository) GetLatestJobRuns(ctx context.Context) (runs *[]models.JobRun, err error) {
+	err = r.observe(ctx, "GetLatestJobRuns", func(ctx context.Context) error {
+		runs, err = r.next.GetLatestJobRuns(ctx)
+		return err
+	})
+	return runs, err
+}
+
+func (r *InstrumentedRepository) GetLastSuccessfulJobRun(ctx context.Context, name string) (run *models.JobRun, err error) {
+	err = r.observe(ctx, "GetLastSuccessfulJobRun", func(ctx context.Context) error {
+		run, err = r.next.GetLastSuccessfulJobRun(ctx, name)
+		return err
+	}, "name", name)
+	return run, err
+}
+
+func (r *InstrumentedRepository) GetJobState(ctx context.Context, name string) (state *models.JobState, err error) {
+	err = r.observe(ctx, "GetJobState", func(ctx context.Context) error {
+		state, err = r.next.GetJobState(ctx, name)
+		return err
+	}, "name", name)
+	return state, err
+}
+
+func (r *InstrumentedRepository) SetJobPaused(ctx context.Context, name string, paused bool) error {
+	return r.observe(ctx, "SetJobPaused", func(ctx context.Context) error {
+		return r.next.SetJobPaused(ctx, name, paused)
+	}, "name", name, "paused", paused)
+}
+
+func (r *InstrumentedRepository) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (lease *models.Lease, err error) {
+	err = r.observe(ctx, "AcquireLease", func(ctx context.Context) error {
+		lease, err = r.next.AcquireLease(ctx, name, holder, ttl)
+		return err
+	}, "name", name, "holder", holder, "ttl", ttl)
+	return lease, err
+}
+
+func (r *InstrumentedRepository) ReleaseLease(ctx context.Context, name, holder string) error {
+	return r.observe(ctx, "ReleaseLease", func(ctx context.Context) error {
+		return r.next.ReleaseLease(ctx, name, holder)
+	}, "name", name, "holder", holder)
+}
+
+func (r *InstrumentedRepository) GetLease(ctx context.Context, name string) (lease *models.Lease, err error) {
+	err = r.observe(ctx, "GetLease", func(ctx context.Context) error {
+		lease, err = r.next.GetLease(ctx, name)
+		return err
+	}, "name", name)
+	return lease, err
+}
+
+func (r *InstrumentedRepository) CreateReportSpec(ctx context.Context, spec *models.ReportSpec) error {
+	return r.observe(ctx, "CreateReportSpec", func(ctx context.Context) error {
+		return r.next.CreateReportSpec(ctx, spec)
+	}, "spec", spec)
+}
+
+func (r *InstrumentedRepository) GetReportSpec(ctx context.Context, id string) (spec *models.ReportSpec, err error) {
+	err = r.observe(ctx, "GetReportSpec", func(ctx context.Context) error {
+		spec, err = r.next.GetReportSpec(ctx, id)
+		return err
+	}, "id", id)
+	return spec, err
+}
+
+func (r *InstrumentedRepository) ListReportSpecs(ctx context.Context) (specs *[]models.ReportSpec, err error) {
+	err = r.observe(ctx, "ListReportSpecs", func(ctx context.Context) error {
+		specs, err = r.next.ListReportSpecs(ctx)
+		return err
+	})
+	return specs, err
+}
+
+func (r *InstrumentedRepository) DeleteReportSpec(ctx context.Context, id string) error {
+	return r.observe(ctx, "DeleteReportSpec", func(ctx context.Context) error {
+		return r.next.DeleteReportSpec(ctx, id)
+	}, "id", id)
+}
+
+func (r *InstrumentedRepository) ListDueReportSpecs(ctx context.Context, now time.Time) (specs *[]models.ReportSpec, err error) {
+	err = r.observe(ctx, "ListDueReportSpecs", func(ctx context.Context) error {
+		specs, err = r.next.ListDueReportSpecs(ctx, now)
+		return err
+	}, "now", now)
+	return specs, err
+}
+
+func (r *InstrumentedRepository) SetReportSpecRun(ctx context.Context, id string, lastRun, nextRun time.Time) error {
+	return r.observe(ctx, "SetReportSpecRun", func(ctx context.Context) error {
+		return r.next.SetReportSpecRun(ctx, id, lastRun, nextRun)
+	}, "id", id, "last_run", lastRun, "next_run", nextRun)
+}
+
+func (r *InstrumentedRepository) GetReportRows(ctx context.Context, query ReportQuery) (rows *[]models.ReportRow, err error) {
+	err = r.observe(ctx, "GetReportRows", func(ctx context.Context) error {
+		rows, err = r.next.GetReportRows(ctx, query)
+		return err
+	}, "query", query)
+	return rows, err
+}
+
+func (r *InstrumentedRepository) CreateReport(ctx context.Context, report *models.GeneratedReport) error {
+	return r.observe(ctx, "CreateReport", func(ctx context.Context) error {
+		return r.next.CreateReport(ctx, report)
+	}, "spec_id", report.SpecID, "filename", report.Filename, "size", report.Size)
+}
+
+func (r *InstrumentedRepository) SetReportDelivery(ctx context.Context, id, status string, deliveryError *string) error {
+	return r.observe(ctx, "SetReportDelivery", func(ctx context.Context) error {
+		return r.next.SetReportDelivery(ctx, id, status, deliveryError)
+	}, "id", id, "status", status)
+}
+
+func (r *InstrumentedRepository) GetReport(ctx context.Context, id string) (report *models.GeneratedReport, err error) {
+	err = r.observe(ctx, "GetReport", func(ctx context.Context) error {
+		report, err = r.next.GetReport(ctx, id)
+		return err
+	}, "id", id)
+	return report, err
+}
+
+func (r *InstrumentedRepository) ListReports(ctx context.Context, opts ListReportOptions) (reports *[]models.GeneratedReport, err error) {
+	err = r.observe(ctx, "ListReports", func(ctx context.Context) error {
+		reports, err = r.next.ListReports(ctx, opts)
+		return err
+	}, "opts", opts)
+	return reports, err
+}
+
+func (r *InstrumentedRepository) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	return r.observe(ctx, "CreateWebhook", func(ctx context.Context) error {
+		return r.next.CreateWebhook(ctx, webhook)
+	}, "url", webhook.URL)
+}
+
+func (r *InstrumentedRepository) ListWebhooks(ctx context.Context) (webhooks *[]models.Webhook, err error) {
+	err = r.observe(ctx, "ListWebhooks", func(ctx context.Context) error {
+		webhooks, err = r.next.ListWebhooks(ctx)
+		return err
+	})
+	return webhooks, err
+}
+
+func (r *InstrumentedRepository) DeleteWebhook(ctx context.Context, id string) error {
+	return r.observe(ctx, "DeleteWebhook", func(ctx context.Context) error {
+		return r.next.DeleteWebhook(ctx, id)
+	}, "id", id)
+}
+
+func (r *InstrumentedRepository) CreateWebhookRule(ctx context.Context, rule *models.WebhookRule) error {
+	return r.observe(ctx, "CreateWebhookRule", func(ctx context.Context) error {
+		return r.next.CreateWebhookRule(ctx, rule)
+	}, "rule", rule)
+}
+
+func (r *InstrumentedRepository) ListWebhookRules(ctx context.Context, webhookID string) (rules *[]models.WebhookRule, err error) {
+	err = r.observe(ctx, "ListWebhookRules", func(ctx context.Context) error {
+		rules, err = r.next.ListWebhookRules(ctx, webhookID)
+		return err
+	}, "webhook_id", webhookID)
+	return rules, err
+}
+
+func (r *InstrumentedRepository) DeleteWebhookRule(ctx context.Context, webhookID, id string) error {
+	return r.observe(ctx, "DeleteWebhookRule", func(ctx context.Context) error {
+		return r.next.DeleteWebhookRule(ctx, webhookID, id)
+	}, "webhook_id", webhookID, "id", id)
+}
+
+func (r *InstrumentedRepository) TriggerWebhookRule(ctx context.Context, id string, delivery *models.WebhookDelivery) error {
+	return r.observe(ctx, "TriggerWebhookRule", func(ctx context.Context) error {
+		return r.next.TriggerWebhookRule(ctx, id, delivery)
+	}, "id", id, "delivery_id", delivery.ID)
+}
+
+func (r *InstrumentedRepository) ResetWebhookRule(ctx context.Context, id string) error {
+	return r.observe(ctx, "ResetWebhookRule", func(ctx context.Context) error {
+		return r.next.ResetWebhookRule(ctx, id)
+	}, "id", id)
+}
+
+func (r *InstrumentedRepository) ListDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) (deliveries *[]models.WebhookDelivery, err error) {
+	err = r.observe(ctx, "ListDueWebhookDeliveries", func(ctx context.Context) error {
+		deliveries, err = r.next.ListDueWebhookDeliveries(ctx, now, limit)
+		return err
+	}, "now", now, "limit", limit)
+	return deliveries, err
+}
+
+func (r *InstrumentedRepository) UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	return r.observe(ctx, "UpdateWebhookDelivery", func(ctx context.Context) error {
+		return r.next.UpdateWebhookDelivery(ctx, delivery)
+	}, "id", delivery.ID, "status", delivery.Status, "attempts", delivery.Attempts)
+}
+
+func (r *InstrumentedRepository) ListWebhookDeliveries(ctx context.Context, opts ListWebhookDeliveryOptions) (deliveries *[]models.WebhookDelivery, err error) {
+	err = r.observe(ctx, "ListWebhookDeliveries", func(ctx context.Context) error {
+		deliveries, err = r.next.ListWebhookDeliveries(ctx, opts)
+		return err
+	}, "opts", opts)
+	return deliveries, err
+}
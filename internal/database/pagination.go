@@ -0,0 +1,38 @@
+package database
+
+import (
+	"fmt"
+
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
+)
+
+// keysetCondition selects the rows after the cursor's row, in the order of column then id.
+// cast is the SQL type of column, the cursor holding its value as text.
+func keysetCondition(column, cast, order string, after apihelpers.Cursor, args *[]any) string {
+	comparison := "<"
+	if order == "asc" {
+		comparison = ">"
+	}
+	*args = append(*args, after.Value, after.ID)
+	return fmt.Sprintf(`(%s, id) %s ($%d::%s, $%d::UUID)`, column, comparison, len(*args)-1, cast, len(*args))
+}
+
+// keysetOrder sorts by column then id, so rows sharing a value have a stable order across pages
+func keysetOrder(column, order string) string {
+	direction := "DESC"
+	if order == "asc" {
+		direction = "ASC"
+	}
+	return fmt.Sprintf(` ORDER BY %[1]s %[2]s, id %[2]s`, column, direction)
+}
+
+// keysetLimit limits the rows to a page, in cursor mode it fetches one more row telling
+// whether there is a next page (see apihelpers.NextCursor)
+func keysetLimit(opts apihelpers.PaginationOptions, args *[]any) string {
+	if opts.Keyset {
+		*args = append(*args, opts.Limit+1)
+		return fmt.Sprintf(` LIMIT $%d`, len(*args))
+	}
+	*args = append(*args, opts.Limit, opts.Offset)
+	return fmt.Sprintf(` LIMIT $%d OFFSET $%d`, len(*args)-1, len(*args))
+}
@@ -1,9 +1,12 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/JalajGoswami/video-ad-metrics/internal/models"
@@ -15,11 +18,17 @@ import (
 
 // PostgresDB implements the Repository interface using PostgreSQL
 type PostgresDB struct {
+	// primary receives all writes and reads which must see them
 	db *sqlx.DB
+	// replicas serve analytics and listing reads, falling back to the primary when none is healthy
+	replicas    []*replica
+	nextReplica atomic.Uint64
+	done        chan struct{}
 }
 
-// NewPostgresDB creates a new PostgresDB repository
-func NewPostgresDB(connString string) (*PostgresDB, error) {
+// NewPostgresDB creates a new PostgresDB repository. Read-only analytics and listing queries
+// are spread over the optional read replicas, everything else goes to the primary.
+func NewPostgresDB(connString string, pool PoolOptions, replicaConnStrings ...string) (*PostgresDB, error) {
 	db, err := sqlx.Connect("postgres", connString)
 	if err != nil {
 		pqErr, ok := err.(*pq.Error)
@@ -41,23 +50,67 @@ func NewPostgresDB(connString string) (*PostgresDB, error) {
 	}
 
 	// Configure connection pool
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	pool.Default()
+	configurePool(db, pool)
+
+	p := &PostgresDB{db: db, done: make(chan struct{})}
+	monitoring.RegisterDatabasePool("primary", db.Stats)
+	for i, replicaConnString := range replicaConnStrings {
+		r, err := newReplica(fmt.Sprintf("replica-%d", i+1), replicaConnString, pool)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		monitoring.RegisterDatabasePool(r.name, r.db.Stats)
+		p.replicas = append(p.replicas, r)
+	}
+	// find out which replicas are usable before serving any reads
+	p.checkReplicas()
 
 	// Track connections for monitoring
-	monitoring.SetDatabaseConnections(db.Stats().OpenConnections)
+	p.recordPoolStats()
 
-	// Start a goroutine to periodically update connection metrics
+	// Start a goroutine to periodically update connection metrics and replica health
 	go func() {
-		ticker := time.NewTicker(15 * time.Second)
-		defer ticker.Stop()
-		for range ticker.C {
-			monitoring.SetDatabaseConnections(db.Stats().OpenConnections)
+		statsTicker := time.NewTicker(15 * time.Second)
+		defer statsTicker.Stop()
+		healthTicker := time.NewTicker(replicaHealthCheckInterval)
+		defer healthTicker.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-statsTicker.C:
+				p.recordPoolStats()
+			case <-healthTicker.C:
+				p.checkReplicas()
+			}
 		}
 	}()
 
-	return &PostgresDB{db: db}, nil
+	return p, nil
+}
+
+func configurePool(db *sqlx.DB, pool PoolOptions) {
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(*pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+}
+
+// SetPoolOptions resizes the connection pools of the primary and replicas while serving queries
+func (p *PostgresDB) SetPoolOptions(pool PoolOptions) {
+	pool.Default()
+	configurePool(p.db, pool)
+	for _, r := range p.replicas {
+		configurePool(r.db, pool)
+	}
+}
+
+func (p *PostgresDB) recordPoolStats() {
+	monitoring.SetDatabasePoolStats("primary", p.db.Stats())
+	for _, r := range p.replicas {
+		monitoring.SetDatabasePoolStats(r.name, r.db.Stats())
+	}
 }
 
 func createDatabase(connString string) error {
@@ -83,18 +136,63 @@ func createDatabase(connString string) error {
 	return nil
 }
 
-func (p *PostgresDB) Ping() error {
-	return p.db.Ping()
+func (p *PostgresDB) Ping(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "Ping")
+	defer func() { endSpan(span, err) }()
+
+	return p.db.PingContext(ctx)
 }
 
 func (p *PostgresDB) Close() error {
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	for _, r := range p.replicas {
+		r.db.Close()
+	}
 	return p.db.Close()
 }
 
+// schemaTables are the tables created by Setup
+var schemaTables = []string{
+	"ads", "clicks", "archived_clicks", "aggregated_analytics", "monthly_analytics",
+	"jobs", "job_states", "leader_leases", "report_specs", "reports",
+	"webhooks", "webhook_rules", "webhook_deliveries",
+}
+
+// CheckSchema reports the tables created by Setup which are missing
+func (p *PostgresDB) CheckSchema(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "CheckSchema")
+	defer func() { endSpan(span, err) }()
+
+	missing := []string{}
+	err = p.db.SelectContext(ctx, &missing, `
+		SELECT name FROM unnest($1::TEXT[]) AS name
+		WHERE to_regclass(name) IS NULL
+	`, pq.Array(schemaTables))
+	if err != nil {
+		return fmt.Errorf("failed to check schema: %w", err)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// PoolStats returns the connection stats of the primary pool
+func (p *PostgresDB) PoolStats() sql.DBStats {
+	return p.db.Stats()
+}
+
 // Setup creates the necessary database tables if they don't exist
-func (p *PostgresDB) Setup() error {
+func (p *PostgresDB) Setup(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "Setup")
+	defer func() { endSpan(span, err) }()
+
 	// Create ads table
-	_, err := p.db.Exec(`
+	_, err = p.db.ExecContext(ctx, `
 		CREATE TABLE IF NOT EXISTS ads (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			name VARCHAR(255) NOT NULL,
@@ -109,7 +207,7 @@ func (p *PostgresDB) Setup() error {
 	}
 
 	// Create clicks table
-	_, err = p.db.Exec(`
+	_, err = p.db.ExecContext(ctx, `
 		CREATE TABLE IF NOT EXISTS clicks (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			ad_id UUID NOT NULL REFERENCES ads(id) ON DELETE CASCADE,
@@ -124,7 +222,7 @@ func (p *PostgresDB) Setup() error {
 	}
 
 	// Create archived_clicks table
-	_, err = p.db.Exec(`
+	_, err = p.db.ExecContext(ctx, `
 		CREATE TABLE IF NOT EXISTS archived_clicks (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			ad_id UUID NOT NULL REFERENCES ads(id) ON DELETE CASCADE,
@@ -139,7 +237,7 @@ func (p *PostgresDB) Setup() error {
 	}
 
 	// Create aggregated_analytics table
-	_, err = p.db.Exec(`
+	_, err = p.db.ExecContext(ctx, `
 		CREATE TABLE IF NOT EXISTS aggregated_analytics (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			ad_id UUID NOT NULL REFERENCES ads(id) ON DELETE CASCADE,
@@ -154,7 +252,7 @@ func (p *PostgresDB) Setup() error {
 	}
 
 	// Create monthly_analytics table
-	_, err = p.db.Exec(`
+	_, err = p.db.ExecContext(ctx, `
 		CREATE TABLE IF NOT EXISTS monthly_analytics (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			ad_id UUID NOT NULL REFERENCES ads(id) ON DELETE CASCADE,
@@ -170,67 +268,378 @@ func (p *PostgresDB) Setup() error {
 		return fmt.Errorf("failed to create monthly_analytics table: %w", err)
 	}
 
+	// Add the status and campaign of ads, to tables created before they existed
+	_, err = p.db.ExecContext(ctx, `
+		ALTER TABLE ads
+			ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'active'
+				CHECK (status IN ('active', 'paused', 'archived')),
+			ADD COLUMN IF NOT EXISTS campaign VARCHAR(255) NOT NULL DEFAULT ''
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add status and campaign to ads: %w", err)
+	}
+
+	// Create an index on campaign in the ads table, used to filter ads and reports by campaign
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS ads_campaign_idx ON ads (campaign) WHERE campaign <> ''`)
+	if err != nil {
+		return fmt.Errorf("failed to create campaign index on ads: %w", err)
+	}
+
+	// Create an index on created_at and id in the ads table, used for keyset pagination
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS ads_created_at_id_idx ON ads (created_at, id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create created_at index on ads: %w", err)
+	}
+
+	// Create an index on name and id in the ads table, used to list ads sorted by name
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS ads_name_id_idx ON ads (name, id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create name index on ads: %w", err)
+	}
+
+	// Create a full text search index over the name and description of ads (see adDocument)
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS ads_search_idx ON ads USING GIN (`+adDocument+`)`)
+	if err != nil {
+		return fmt.Errorf("failed to create search index on ads: %w", err)
+	}
+
+	// Create a trigram index over the name and description of ads (see adText), used by
+	// similarity and substring searches
+	_, err = p.db.ExecContext(ctx, `CREATE EXTENSION IF NOT EXISTS pg_trgm`)
+	if err != nil {
+		return fmt.Errorf("failed to create pg_trgm extension: %w", err)
+	}
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS ads_search_trgm_idx ON ads USING GIN (`+adText+` gin_trgm_ops)`)
+	if err != nil {
+		return fmt.Errorf("failed to create trigram index on ads: %w", err)
+	}
+
 	// Create an index on ad_id in the clicks table
-	_, err = p.db.Exec(`CREATE INDEX IF NOT EXISTS clicks_ad_id_idx ON clicks (ad_id)`)
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS clicks_ad_id_idx ON clicks (ad_id)`)
 	if err != nil {
 		return fmt.Errorf("failed to create index on clicks: %w", err)
 	}
 
-	return nil
-}
+	// Create an index on timestamp in the clicks table, used to pick archiving batches
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS clicks_timestamp_idx ON clicks (timestamp)`)
+	if err != nil {
+		return fmt.Errorf("failed to create timestamp index on clicks: %w", err)
+	}
 
-// ArchiveOldClicks moves clicks older than one month to the archived_clicks table
-func (p *PostgresDB) ArchiveOldClicks() error {
-	// Begin transaction
-	tx, err := p.db.Beginx()
+	// Create an index on timestamp in the archived_clicks table, used to pick purge batches
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS archived_clicks_timestamp_idx ON archived_clicks (timestamp)`)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return fmt.Errorf("failed to create timestamp index on archived_clicks: %w", err)
 	}
-	defer tx.Rollback()
 
-	oneMonthAgo := time.Now().AddDate(0, 0, -30)
+	// Create indexes on ad_id, timestamp and id in the clicks and archived_clicks tables, used to list the clicks of an ad
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS clicks_ad_id_timestamp_idx ON clicks (ad_id, timestamp, id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create ad_id and timestamp index on clicks: %w", err)
+	}
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS archived_clicks_ad_id_timestamp_idx ON archived_clicks (ad_id, timestamp, id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create ad_id and timestamp index on archived_clicks: %w", err)
+	}
 
-	// Insert old clicks into archived_clicks table
-	_, err = tx.Exec(`
-		INSERT INTO archived_clicks
-		SELECT * FROM clicks
-		WHERE timestamp < $1
-	`, oneMonthAgo)
+	// Create jobs table recording every background job run
+	_, err = p.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS jobs (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			name VARCHAR(100) NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			triggered_by VARCHAR(20) NOT NULL,
+			instance VARCHAR(255) NOT NULL,
+			error TEXT,
+			started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			finished_at TIMESTAMP WITH TIME ZONE
+		)
+	`)
 	if err != nil {
-		return fmt.Errorf("failed to insert into archived_clicks: %w", err)
+		return fmt.Errorf("failed to create jobs table: %w", err)
 	}
 
-	// Delete the archived clicks from the original table
-	_, err = tx.Exec(`
-		DELETE FROM clicks
-		WHERE timestamp < $1
-	`, oneMonthAgo)
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS jobs_name_started_at_idx ON jobs (name, started_at DESC)`)
 	if err != nil {
-		return fmt.Errorf("failed to delete from clicks: %w", err)
+		return fmt.Errorf("failed to create index on jobs: %w", err)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	// Create job_states table holding state shared by all replicas (e.g. paused jobs)
+	_, err = p.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS job_states (
+			name VARCHAR(100) PRIMARY KEY,
+			paused BOOLEAN NOT NULL DEFAULT FALSE,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create job_states table: %w", err)
+	}
+
+	// Create leader_leases table used for leader election between replicas
+	_, err = p.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS leader_leases (
+			name VARCHAR(100) PRIMARY KEY,
+			holder VARCHAR(255) NOT NULL,
+			acquired_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			renewed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create leader_leases table: %w", err)
+	}
+
+	// Create report_specs table holding the reports generated on a schedule
+	_, err = p.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS report_specs (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			name VARCHAR(255) NOT NULL,
+			ad_ids UUID[] NOT NULL DEFAULT '{}',
+			metrics TEXT[] NOT NULL,
+			period VARCHAR(20) NOT NULL,
+			group_by TEXT[] NOT NULL DEFAULT '{}',
+			format VARCHAR(10) NOT NULL,
+			schedule VARCHAR(100) NOT NULL,
+			delivery VARCHAR(20) NOT NULL,
+			webhook_url TEXT,
+			next_run_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			last_run_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create report_specs table: %w", err)
+	}
+
+	// Add the campaigns of report specs, to tables created before they existed
+	_, err = p.db.ExecContext(ctx, `ALTER TABLE report_specs ADD COLUMN IF NOT EXISTS campaigns TEXT[] NOT NULL DEFAULT '{}'`)
+	if err != nil {
+		return fmt.Errorf("failed to add campaigns to report_specs: %w", err)
+	}
+
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS report_specs_next_run_at_idx ON report_specs (next_run_at)`)
+	if err != nil {
+		return fmt.Errorf("failed to create index on report_specs: %w", err)
+	}
+
+	// Create reports table holding the generated report files
+	_, err = p.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS reports (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			spec_id UUID NOT NULL REFERENCES report_specs(id) ON DELETE CASCADE,
+			filename TEXT NOT NULL,
+			content_type VARCHAR(100) NOT NULL,
+			size INTEGER NOT NULL,
+			content BYTEA NOT NULL,
+			period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+			period_end TIMESTAMP WITH TIME ZONE NOT NULL,
+			delivery_status VARCHAR(20) NOT NULL,
+			delivery_error TEXT,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create reports table: %w", err)
+	}
+
+	// Create indexes on created_at and id in the reports table, used to list reports
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS reports_created_at_id_idx ON reports (created_at, id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create created_at index on reports: %w", err)
+	}
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS reports_spec_id_created_at_id_idx ON reports (spec_id, created_at, id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create spec_id index on reports: %w", err)
+	}
+
+	// Create webhooks table holding the endpoints notified by webhook rules
+	_, err = p.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			url TEXT NOT NULL,
+			description TEXT,
+			secret TEXT NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create webhooks table: %w", err)
+	}
+
+	// Create webhook_rules table holding the analytics thresholds watched for each webhook
+	_, err = p.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS webhook_rules (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+			ad_id UUID NOT NULL REFERENCES ads(id) ON DELETE CASCADE,
+			metric VARCHAR(50) NOT NULL,
+			operator VARCHAR(10) NOT NULL,
+			threshold DOUBLE PRECISION NOT NULL,
+			period VARCHAR(20) NOT NULL,
+			triggered BOOLEAN NOT NULL DEFAULT FALSE,
+			last_triggered_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook_rules table: %w", err)
+	}
+
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS webhook_rules_webhook_id_idx ON webhook_rules (webhook_id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create index on webhook_rules: %w", err)
+	}
+
+	// Create webhook_deliveries table logging every notification with the outcome of its last attempt
+	_, err = p.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+			rule_id UUID REFERENCES webhook_rules(id) ON DELETE SET NULL,
+			event VARCHAR(50) NOT NULL,
+			payload JSONB NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMP WITH TIME ZONE,
+			response_status INTEGER,
+			error TEXT,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			delivered_at TIMESTAMP WITH TIME ZONE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook_deliveries table: %w", err)
+	}
+
+	// Create an index on the pending deliveries by next attempt, used to send the due ones
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending'`)
+	if err != nil {
+		return fmt.Errorf("failed to create pending index on webhook_deliveries: %w", err)
+	}
+	_, err = p.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_created_at_id_idx ON webhook_deliveries (webhook_id, created_at, id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook_id index on webhook_deliveries: %w", err)
 	}
 
 	return nil
 }
 
+// ArchiveOldClicks moves clicks older than the retention window to the archived_clicks table.
+// Clicks are moved in bounded batches, each committed on its own, so the job can be
+// interrupted at any point and picks up where it stopped on the next run.
+// It returns the number of clicks archived, even when it stops early.
+func (p *PostgresDB) ArchiveOldClicks(ctx context.Context, opts ArchiveOptions) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "ArchiveOldClicks")
+	defer func() { endSpan(span, err) }()
+
+	opts.Default()
+	cutoff := time.Now().Add(-opts.Retention)
+
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		moved, err := p.archiveClicksBatch(ctx, cutoff, opts.BatchSize)
+		if err != nil {
+			return total, err
+		}
+		total += moved
+		monitoring.AddArchivedClicks(moved)
+
+		if moved < int64(opts.BatchSize) {
+			return total, nil
+		}
+	}
+}
+
+// archiveClicksBatch moves up to batchSize clicks older than cutoff in a single statement,
+// which makes every batch atomic without holding a transaction open across batches
+func (p *PostgresDB) archiveClicksBatch(ctx context.Context, cutoff time.Time, batchSize int) (int64, error) {
+	var moved int64
+	err := p.db.GetContext(ctx, &moved, `
+		WITH batch AS (
+			SELECT id FROM clicks
+			WHERE timestamp < $1
+			ORDER BY timestamp
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		), deleted AS (
+			DELETE FROM clicks c
+			USING batch
+			WHERE c.id = batch.id
+			RETURNING c.id, c.ad_id, c.timestamp, c.ip_address, c.playback_time, c.created_at
+		), inserted AS (
+			INSERT INTO archived_clicks (id, ad_id, timestamp, ip_address, playback_time, created_at)
+			SELECT id, ad_id, timestamp, ip_address, playback_time, created_at FROM deleted
+			ON CONFLICT (id) DO NOTHING
+		)
+		SELECT COUNT(*) FROM deleted
+	`, cutoff, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive clicks batch: %w", err)
+	}
+	return moved, nil
+}
+
+// PurgeArchivedClicks permanently deletes archived clicks older than the retention window,
+// in bounded batches like ArchiveOldClicks. It returns the number of clicks deleted.
+func (p *PostgresDB) PurgeArchivedClicks(ctx context.Context, opts ArchiveOptions) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "PurgeArchivedClicks")
+	defer func() { endSpan(span, err) }()
+
+	opts.Default()
+	cutoff := time.Now().Add(-opts.Retention)
+
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		result, err := p.db.ExecContext(ctx, `
+			DELETE FROM archived_clicks
+			WHERE id IN (
+				SELECT id FROM archived_clicks
+				WHERE timestamp < $1
+				LIMIT $2
+			)
+		`, cutoff, opts.BatchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to purge archived clicks: %w", err)
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("failed to get purged clicks count: %w", err)
+		}
+		total += deleted
+
+		if deleted < int64(opts.BatchSize) {
+			return total, nil
+		}
+	}
+}
+
 // CreateAd stores a new ad
-func (p *PostgresDB) CreateAd(ad *models.Ad) error {
-	_, err := p.db.NamedExec(`
-		INSERT INTO ads (id, name, description, image_url, target_url, created_at)
-		VALUES (:id, :name, :description, :image_url, :target_url, :created_at)
-	`, ad)
+func (p *PostgresDB) CreateAd(ctx context.Context, ad *models.Ad) (err error) {
+	ctx, span := startSpan(ctx, "CreateAd")
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.db.NamedExecContext(ctx, annotate(ctx, `
+		INSERT INTO ads (id, name, description, image_url, target_url, status, campaign, created_at)
+		VALUES (:id, :name, :description, :image_url, :target_url, :status, :campaign, :created_at)
+	`), ad)
 	if err != nil {
 		return fmt.Errorf("failed to insert ad: %w", err)
 	}
 
 	// Create initial aggregated analytics entry for this ad
-	_, err = p.db.Exec(`
+	_, err = p.db.ExecContext(ctx, annotate(ctx, `
 		INSERT INTO aggregated_analytics (id, ad_id, total_clicks, total_playback_time)
 		VALUES ($1, $2, 0, 0)
-	`, uuid.New().String(), ad.ID)
+	`), uuid.New().String(), ad.ID)
 	if err != nil {
 		return fmt.Errorf("failed to insert initial analytics: %w", err)
 	}
@@ -239,9 +648,12 @@ func (p *PostgresDB) CreateAd(ad *models.Ad) error {
 }
 
 // GetAd retrieves an ad by ID
-func (p *PostgresDB) GetAd(id string) (*models.Ad, error) {
+func (p *PostgresDB) GetAd(ctx context.Context, id string) (_ *models.Ad, err error) {
+	ctx, span := startSpan(ctx, "GetAd")
+	defer func() { endSpan(span, err) }()
+
 	var ad models.Ad
-	err := p.db.Get(&ad, `SELECT * FROM ads WHERE id = $1`, id)
+	err = p.db.GetContext(ctx, &ad, annotate(ctx, `SELECT * FROM ads WHERE id = $1`), id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
@@ -251,41 +663,117 @@ func (p *PostgresDB) GetAd(id string) (*models.Ad, error) {
 	return &ad, nil
 }
 
-// ListAds returns all ads
-func (p *PostgresDB) ListAds(opts ListAdOptions) (*[]models.Ad, error) {
-	ads := []models.Ad{}
-	query := `SELECT * FROM ads`
+// UpdateAd changes the fields of an ad set in update and returns the updated ad
+func (p *PostgresDB) UpdateAd(ctx context.Context, id string, update models.AdUpdate) (_ *models.Ad, err error) {
+	ctx, span := startSpan(ctx, "UpdateAd")
+	defer func() { endSpan(span, err) }()
+
+	var ad models.Ad
+	err = p.db.GetContext(ctx, &ad, annotate(ctx, `
+		UPDATE ads SET status = COALESCE($2, status), campaign = COALESCE($3, campaign)
+		WHERE id = $1
+		RETURNING *
+	`), id, update.Status, update.Campaign)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to update ad: %w", err)
+	}
+	return &ad, nil
+}
+
+// targetHost is the lower case host of the target url of an ad
+const targetHost = `lower(substring(target_url FROM '^[^:/?#]+://(?:[^/?#@]*@)?([^/?#:]+)'))`
+
+// adFilters returns the conditions selecting the ads listed with opts, appending their parameters to args
+func adFilters(opts ListAdOptions, args *[]any) []string {
+	var conditions []string
 	if opts.Search != "" {
-		query += ` WHERE name ILIKE '%' || :search || '%'`
+		conditions = append(conditions, searchCondition(opts, args))
 	}
-	if opts.Order == "asc" {
-		query += ` ORDER BY "created_at" ASC`
-	} else {
-		query += ` ORDER BY "created_at" DESC`
+	if !opts.CreatedFrom.IsZero() {
+		*args = append(*args, opts.CreatedFrom)
+		conditions = append(conditions, fmt.Sprintf(`created_at >= $%d`, len(*args)))
 	}
-	query += ` LIMIT :limit OFFSET :offset;`
-	stmt, err := p.db.PrepareNamed(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to prepare statement: %w", err)
+	if !opts.CreatedTo.IsZero() {
+		*args = append(*args, opts.CreatedTo)
+		conditions = append(conditions, fmt.Sprintf(`created_at < $%d`, len(*args)))
+	}
+	if opts.TargetDomain != "" {
+		*args = append(*args, opts.TargetDomain)
+		conditions = append(conditions, fmt.Sprintf(`(%[1]s = $%[2]d OR right(%[1]s, length($%[2]d) + 1) = '.' || $%[2]d)`, targetHost, len(*args)))
+	}
+	if len(opts.Statuses) > 0 {
+		*args = append(*args, pq.StringArray(opts.Statuses))
+		conditions = append(conditions, fmt.Sprintf(`status = ANY($%d)`, len(*args)))
+	}
+	if opts.Campaign != "" {
+		*args = append(*args, opts.Campaign)
+		conditions = append(conditions, fmt.Sprintf(`campaign = $%d`, len(*args)))
+	}
+	return conditions
+}
+
+// ListAds returns all ads
+func (p *PostgresDB) ListAds(ctx context.Context, opts ListAdOptions) (_ *[]models.ListedAd, err error) {
+	ctx, span := startSpan(ctx, "ListAds")
+	defer func() { endSpan(span, err) }()
+
+	ads := []models.ListedAd{}
+	var args []any
+	// columns named the way sqlx fills models.ListedAd
+	columns := []string{`id`, `name`, `description`, `image_url`, `target_url`, `status`, `campaign`, `created_at`}
+	source := `ads`
+	if opts.joinsAnalytics() || opts.Search != "" {
+		// ads with the computed sort columns, so that they can be filtered and sorted on by name
+		derived := []string{`ads.*`}
+		join := ``
+		if opts.joinsAnalytics() {
+			derived = append(derived, `COALESCE(a.total_clicks, 0) AS total_clicks`, `COALESCE(a.total_playback_time, 0) AS total_playback_time`)
+			join = ` LEFT JOIN aggregated_analytics a ON a.ad_id = ads.id`
+			columns = append(columns, `total_clicks AS "analytics.total_clicks"`, `total_playback_time AS "analytics.total_playback_time"`)
+		}
+		if opts.Search != "" {
+			derived = append(derived, searchRank(opts, &args)+` AS relevance`)
+			columns = append(columns, `relevance AS "search.rank"`, searchSnippet(opts, &args)+` AS "search.snippet"`)
+		}
+		source = `(SELECT ` + strings.Join(derived, `, `) + ` FROM ads` + join + `) ads`
+	}
+	conditions := adFilters(opts, &args)
+	if opts.After != nil {
+		conditions = append(conditions, keysetCondition(opts.Sort, adSortTypes[opts.Sort], opts.Order, *opts.After, &args))
+	}
+
+	query := `SELECT ` + strings.Join(columns, `, `) + ` FROM ` + source
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
 	}
-	err = stmt.Select(&ads, opts)
+	query += keysetOrder(opts.Sort, opts.Order) + keysetLimit(opts.PaginationOptions, &args)
+	err = p.reader().SelectContext(ctx, &ads, annotate(ctx, query), args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list ads: %w", err)
 	}
+	for _, ad := range ads {
+		if ad.Search != nil {
+			ad.Search.Snippet = highlight(ad.Search.Snippet)
+		}
+	}
 	return &ads, nil
 }
 
 // used for pagination
-func (p *PostgresDB) CountAds(opts ListAdOptions) (int, error) {
+func (p *PostgresDB) CountAds(ctx context.Context, opts ListAdOptions) (_ int, err error) {
+	ctx, span := startSpan(ctx, "CountAds")
+	defer func() { endSpan(span, err) }()
+
 	var count int
-	var err error
+	var args []any
 	query := `SELECT COUNT(*) FROM ads`
-	if opts.Search != "" {
-		query += ` WHERE name ILIKE '%' || $1 || '%'`
-		err = p.db.Get(&count, query, opts.Search)
-	} else {
-		err = p.db.Get(&count, query)
+	if conditions := adFilters(opts, &args); len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
 	}
+	err = p.reader().GetContext(ctx, &count, annotate(ctx, query), args...)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count ads: %w", err)
 	}
@@ -293,15 +781,18 @@ func (p *PostgresDB) CountAds(opts ListAdOptions) (int, error) {
 }
 
 // LogClick records a click and updates analytics
-func (p *PostgresDB) LogClick(click *models.Click) error {
-	tx, err := p.db.Beginx()
+func (p *PostgresDB) LogClick(ctx context.Context, click *models.Click) (err error) {
+	ctx, span := startSpan(ctx, "LogClick")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := p.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
 	var exists bool
-	err = tx.Get(&exists, `SELECT EXISTS(SELECT 1 FROM ads WHERE id = $1)`, click.AdID)
+	err = tx.GetContext(ctx, &exists, annotate(ctx, `SELECT EXISTS(SELECT 1 FROM ads WHERE id = $1)`), click.AdID)
 	if err != nil {
 		return fmt.Errorf("failed to check if ad exists: %w", err)
 	}
@@ -310,22 +801,22 @@ func (p *PostgresDB) LogClick(click *models.Click) error {
 		return ErrNotFound
 	}
 
-	_, err = tx.NamedExec(`
+	_, err = tx.NamedExecContext(ctx, annotate(ctx, `
 		INSERT INTO clicks (id, ad_id, timestamp, ip_address, playback_time, created_at)
 		VALUES (:id, :ad_id, :timestamp, :ip_address, :playback_time, :created_at)
-	`, click)
+	`), click)
 	if err != nil {
 		return fmt.Errorf("failed to insert click: %w", err)
 	}
 
 	// Update aggregated analytics
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, annotate(ctx, `
 		UPDATE aggregated_analytics
 		SET total_clicks = total_clicks + 1,
 			total_playback_time = total_playback_time + $1,
 			updated_at = NOW()
 		WHERE ad_id = $2
-	`, click.PlaybackTime, click.AdID)
+	`), click.PlaybackTime, click.AdID)
 	if err != nil {
 		return fmt.Errorf("failed to update aggregated analytics: %w", err)
 	}
@@ -335,17 +826,19 @@ func (p *PostgresDB) LogClick(click *models.Click) error {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	// Track the click in metrics
-	monitoring.IncrementClicksLogged()
-
 	return nil
 }
 
 // GetAdAnalytics retrieves analytics for a specific ad
-func (p *PostgresDB) GetAdAnalytics(adID string, rangeDate time.Time) (*models.AdAnalyticsData, error) {
+func (p *PostgresDB) GetAdAnalytics(ctx context.Context, adID string, rangeDate time.Time) (_ *models.AdAnalyticsData, err error) {
+	ctx, span := startSpan(ctx, "GetAdAnalytics")
+	defer func() { endSpan(span, err) }()
+
+	db := p.reader()
+
 	// Check if ad exists
 	var exists bool
-	err := p.db.Get(&exists, `SELECT EXISTS(SELECT 1 FROM ads WHERE id = $1)`, adID)
+	err = db.GetContext(ctx, &exists, annotate(ctx, `SELECT EXISTS(SELECT 1 FROM ads WHERE id = $1)`), adID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check if ad exists: %w", err)
 	}
@@ -354,13 +847,13 @@ func (p *PostgresDB) GetAdAnalytics(adID string, rangeDate time.Time) (*models.A
 	}
 
 	var result models.AdAnalyticsData
-	err = p.db.Get(&result, `SELECT ad_id, total_clicks, total_playback_time FROM aggregated_analytics WHERE ad_id = $1`, adID)
+	err = db.GetContext(ctx, &result, annotate(ctx, `SELECT ad_id, total_clicks, total_playback_time FROM aggregated_analytics WHERE ad_id = $1`), adID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get aggregated analytics: %w", err)
 	}
 
 	// Query combines current and archived clicks
-	err = p.db.Get(&result, `
+	err = db.GetContext(ctx, &result, annotate(ctx, `
 		SELECT 
 			COUNT(*) as total_clicks_in_range,
 			COALESCE(SUM(playback_time), 0) as total_playback_time_in_range
@@ -368,7 +861,7 @@ func (p *PostgresDB) GetAdAnalytics(adID string, rangeDate time.Time) (*models.A
 			SELECT playback_time FROM clicks
 			WHERE ad_id = $1 AND timestamp >= $2
 		)
-	`, adID, rangeDate)
+	`), adID, rangeDate)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get range analytics: %w", err)
@@ -377,20 +870,59 @@ func (p *PostgresDB) GetAdAnalytics(adID string, rangeDate time.Time) (*models.A
 	return &result, nil
 }
 
+// RollupMonthlyAnalytics recomputes monthly_analytics for every month starting at from,
+// combining current and archived clicks. It returns the number of rows upserted.
+func (p *PostgresDB) RollupMonthlyAnalytics(ctx context.Context, from time.Time) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "RollupMonthlyAnalytics")
+	defer func() { endSpan(span, err) }()
+
+	result, err := p.db.ExecContext(ctx, `
+		INSERT INTO monthly_analytics (ad_id, month, year, total_clicks, total_playback_time)
+		SELECT
+			ad_id,
+			EXTRACT(MONTH FROM timestamp AT TIME ZONE 'UTC')::INTEGER AS month,
+			EXTRACT(YEAR FROM timestamp AT TIME ZONE 'UTC')::INTEGER AS year,
+			COUNT(*) AS total_clicks,
+			COALESCE(SUM(playback_time), 0) AS total_playback_time
+		FROM (
+			SELECT ad_id, timestamp, playback_time FROM clicks WHERE timestamp >= $1
+			UNION ALL
+			SELECT ad_id, timestamp, playback_time FROM archived_clicks WHERE timestamp >= $1
+		) AS all_clicks
+		GROUP BY ad_id, month, year
+		ON CONFLICT (ad_id, month, year) DO UPDATE
+		SET total_clicks = EXCLUDED.total_clicks,
+			total_playback_time = EXCLUDED.total_playback_time
+	`, from)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rollup monthly analytics: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rolled up rows count: %w", err)
+	}
+	return rows, nil
+}
+
 // GetAdsAnalytics retrieves aggregate analytics for all ads
-func (p *PostgresDB) GetAdsAnalytics(rangeDate time.Time) (*models.AnalyticsData, error) {
+func (p *PostgresDB) GetAdsAnalytics(ctx context.Context, rangeDate time.Time) (_ *models.AnalyticsData, err error) {
+	ctx, span := startSpan(ctx, "GetAdsAnalytics")
+	defer func() { endSpan(span, err) }()
+
+	db := p.reader()
+
 	var result struct {
 		models.AnalyticsData
 		AdCount int `db:"ad_count"`
 	}
 
-	err := p.db.Get(&result, `
+	err = db.GetContext(ctx, &result, annotate(ctx, `
 		SELECT 
 			SUM(total_clicks) AS total_clicks,
 			SUM(total_playback_time) AS total_playback_time,
 			COUNT(*) AS ad_count
 		FROM aggregated_analytics
-	`)
+	`))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get aggregated analytics: %w", err)
 	}
@@ -399,14 +931,14 @@ func (p *PostgresDB) GetAdsAnalytics(rangeDate time.Time) (*models.AnalyticsData
 		result.AverageClicksPerAd = float64(result.TotalClicks) / float64(result.AdCount)
 	}
 
-	err = p.db.Get(&result, `
+	err = db.GetContext(ctx, &result, annotate(ctx, `
 		SELECT 
 			COUNT(*) AS total_clicks_in_range,
 			COALESCE(SUM(playback_time), 0) AS total_playback_time_in_range,
 			COUNT(DISTINCT ad_id) AS ad_count
 		FROM clicks
 		WHERE timestamp >= $1
-	`, rangeDate)
+	`), rangeDate)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get today's analytics: %w", err)
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+)
+
+// clickSource is the clicks selected by filter from both clicks and archived_clicks. The
+// conditions are repeated in both branches so that each uses its (ad_id, timestamp, id) index.
+// Archiving moves a click without changing its id or timestamp, so cursors stay valid across it.
+func clickSource(filter ClickFilter, args *[]any) string {
+	var conditions []string
+	if filter.AdID != "" {
+		*args = append(*args, filter.AdID)
+		conditions = append(conditions, fmt.Sprintf(`ad_id = $%d`, len(*args)))
+	}
+	if !filter.From.IsZero() {
+		*args = append(*args, filter.From)
+		conditions = append(conditions, fmt.Sprintf(`timestamp >= $%d`, len(*args)))
+	}
+	if !filter.To.IsZero() {
+		*args = append(*args, filter.To)
+		conditions = append(conditions, fmt.Sprintf(`timestamp < $%d`, len(*args)))
+	}
+	where := ``
+	if len(conditions) > 0 {
+		where = ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	return `(
+		SELECT id, ad_id, timestamp, ip_address, playback_time, created_at, FALSE AS archived FROM clicks` + where + `
+		UNION ALL
+		SELECT id, ad_id, timestamp, ip_address, playback_time, created_at, TRUE AS archived FROM archived_clicks` + where + `
+	) clicks`
+}
+
+// ListClicks returns a page of the clicks of an ad, archived ones included
+func (p *PostgresDB) ListClicks(ctx context.Context, opts ListClickOptions) (_ *[]models.ListedClick, err error) {
+	ctx, span := startSpan(ctx, "ListClicks")
+	defer func() { endSpan(span, err) }()
+
+	clicks := []models.ListedClick{}
+	var args []any
+	query := `SELECT * FROM ` + clickSource(opts.ClickFilter, &args)
+	if opts.After != nil {
+		query += ` WHERE ` + keysetCondition("timestamp", "TIMESTAMPTZ", opts.Order, *opts.After, &args)
+	}
+	query += keysetOrder("timestamp", opts.Order) + keysetLimit(opts.PaginationOptions, &args)
+	err = p.reader().SelectContext(ctx, &clicks, annotate(ctx, query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clicks: %w", err)
+	}
+	return &clicks, nil
+}
+
+// CountClicks counts the clicks listed with opts, archived ones included
+func (p *PostgresDB) CountClicks(ctx context.Context, opts ListClickOptions) (_ int, err error) {
+	ctx, span := startSpan(ctx, "CountClicks")
+	defer func() { endSpan(span, err) }()
+
+	var count int
+	var args []any
+	err = p.reader().GetContext(ctx, &count, annotate(ctx, `SELECT COUNT(*) FROM `+clickSource(opts.ClickFilter, &args)), args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count clicks: %w", err)
+	}
+	return count, nil
+}
+
+// ExportClicks calls fn with every click selected by filter, archived ones included, by timestamp.
+// The clicks are streamed from the database as fn consumes them, fn's errors stop the export.
+func (p *PostgresDB) ExportClicks(ctx context.Context, filter ClickFilter, fn func(click models.ListedClick) error) (err error) {
+	ctx, span := startSpan(ctx, "ExportClicks")
+	defer func() { endSpan(span, err) }()
+
+	var args []any
+	query := `SELECT * FROM ` + clickSource(filter, &args) + ` ORDER BY timestamp, id`
+	rows, err := p.reader().QueryxContext(ctx, annotate(ctx, query), args...)
+	if err != nil {
+		return fmt.Errorf("failed to export clicks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var click models.ListedClick
+		if err = rows.StructScan(&click); err != nil {
+			return fmt.Errorf("failed to scan click: %w", err)
+		}
+		if err = fn(click); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to export clicks: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+)
+
+// advisoryLockNamespace is the first key of every advisory lock taken by this service,
+// so our locks can't collide with locks taken by other applications on the same database
+const advisoryLockNamespace = 0x5644414d // "VDAM"
+
+// TryAdvisoryLock tries to take a session level advisory lock for key without waiting.
+// The lock is held on a dedicated connection until release is called, so if this
+// process dies the connection drops and Postgres frees the lock for another replica.
+// That connection counts against MaxOpenConns until then.
+func (p *PostgresDB) TryAdvisoryLock(ctx context.Context, key string) (_ func(), _ bool, err error) {
+	ctx, span := startSpan(ctx, "TryAdvisoryLock")
+	defer func() { endSpan(span, err) }()
+
+	conn, err := p.db.Connx(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get connection for advisory lock: %w", err)
+	}
+
+	var acquired bool
+	err = conn.GetContext(ctx, &acquired, `SELECT pg_try_advisory_lock($1, hashtext($2))`, advisoryLockNamespace, key)
+	if err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("failed to take advisory lock: %w", err)
+	}
+	if !acquired {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	release := func() {
+		// the lock is released with the connection anyway, unlocking explicitly
+		// just lets the connection go back to the pool
+		_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, hashtext($2))`, advisoryLockNamespace, key)
+		if err != nil {
+			logger.ErrorLog("Failed to release advisory lock %q: %v", key, err)
+			// discard the connection so a lock we failed to release dies with it
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}
+	return release, true, nil
+}
+
+// CreateJobRun records the start of a job run
+func (p *PostgresDB) CreateJobRun(ctx context.Context, run *models.JobRun) (err error) {
+	ctx, span := startSpan(ctx, "CreateJobRun")
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.db.NamedExecContext(ctx, `
+		INSERT INTO jobs (id, name, status, triggered_by, instance, started_at)
+		VALUES (:id, :name, :status, :triggered_by, :instance, :started_at)
+	`, run)
+	if err != nil {
+		return fmt.Errorf("failed to insert job run: %w", err)
+	}
+	return nil
+}
+
+// FinishJobRun records the outcome of a job run
+func (p *PostgresDB) FinishJobRun(ctx context.Context, run *models.JobRun) (err error) {
+	ctx, span := startSpan(ctx, "FinishJobRun")
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.db.NamedExecContext(ctx, `
+		UPDATE jobs
+		SET status = :status, error = :error, finished_at = :finished_at
+		WHERE id = :id
+	`, run)
+	if err != nil {
+		return fmt.Errorf("failed to update job run: %w", err)
+	}
+	return nil
+}
+
+// ListJobRuns returns the most recent runs of a job
+func (p *PostgresDB) ListJobRuns(ctx context.Context, name string, limit int) (_ *[]models.JobRun, err error) {
+	ctx, span := startSpan(ctx, "ListJobRuns")
+	defer func() { endSpan(span, err) }()
+
+	runs := []models.JobRun{}
+	err = p.db.SelectContext(ctx, &runs, `
+		SELECT * FROM jobs
+		WHERE name = $1
+		ORDER BY started_at DESC
+		LIMIT $2
+	`, name, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list job runs: %w", err)
+	}
+	return &runs, nil
+}
+
+// GetLatestJobRuns returns the most recent run of every job
+func (p *PostgresDB) GetLatestJobRuns(ctx context.Context) (_ *[]models.JobRun, err error) {
+	ctx, span := startSpan(ctx, "GetLatestJobRuns")
+	defer func() { endSpan(span, err) }()
+
+	runs := []models.JobRun{}
+	err = p.db.SelectContext(ctx, &runs, `
+		SELECT DISTINCT ON (name) * FROM jobs
+		ORDER BY name, started_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest job runs: %w", err)
+	}
+	return &runs, nil
+}
+
+// GetLastSuccessfulJobRun retrieves the most recent successful run of a job on any replica
+func (p *PostgresDB) GetLastSuccessfulJobRun(ctx context.Context, name string) (_ *models.JobRun, err error) {
+	ctx, span := startSpan(ctx, "GetLastSuccessfulJobRun")
+	defer func() { endSpan(span, err) }()
+
+	var run models.JobRun
+	err = p.db.GetContext(ctx, &run, `
+		SELECT * FROM jobs
+		WHERE name = $1 AND status = 'succeeded'
+		ORDER BY started_at DESC
+		LIMIT 1
+	`, name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get last successful job run: %w", err)
+	}
+	return &run, nil
+}
+
+// GetJobState retrieves the shared state of a job
+func (p *PostgresDB) GetJobState(ctx context.Context, name string) (_ *models.JobState, err error) {
+	ctx, span := startSpan(ctx, "GetJobState")
+	defer func() { endSpan(span, err) }()
+
+	var state models.JobState
+	err = p.db.GetContext(ctx, &state, `SELECT * FROM job_states WHERE name = $1`, name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get job state: %w", err)
+	}
+	return &state, nil
+}
+
+// SetJobPaused pauses or resumes a job on all replicas
+func (p *PostgresDB) SetJobPaused(ctx context.Context, name string, paused bool) (err error) {
+	ctx, span := startSpan(ctx, "SetJobPaused")
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.db.ExecContext(ctx, `
+		INSERT INTO job_states (name, paused, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (name) DO UPDATE
+		SET paused = EXCLUDED.paused, updated_at = EXCLUDED.updated_at
+	`, name, paused)
+	if err != nil {
+		return fmt.Errorf("failed to set job state: %w", err)
+	}
+	return nil
+}
+
+// AcquireLease takes or renews the named lease for holder if it is free, expired or already
+// held by holder, and returns the current lease whoever holds it. Expiry uses the database
+// clock so clock skew between replicas doesn't matter.
+func (p *PostgresDB) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (_ *models.Lease, err error) {
+	ctx, span := startSpan(ctx, "AcquireLease")
+	defer func() { endSpan(span, err) }()
+
+	var lease models.Lease
+	err = p.db.GetContext(ctx, &lease, `
+		INSERT INTO leader_leases (name, holder, acquired_at, renewed_at, expires_at)
+		VALUES ($1, $2, NOW(), NOW(), NOW() + $3::DOUBLE PRECISION * INTERVAL '1 millisecond')
+		ON CONFLICT (name) DO UPDATE
+		SET holder = EXCLUDED.holder,
+			acquired_at = CASE
+				WHEN leader_leases.holder = EXCLUDED.holder THEN leader_leases.acquired_at
+				ELSE EXCLUDED.acquired_at
+			END,
+			renewed_at = EXCLUDED.renewed_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE leader_leases.holder = EXCLUDED.holder OR leader_leases.expires_at < NOW()
+		RETURNING *
+	`, name, holder, ttl.Milliseconds())
+	if err == sql.ErrNoRows {
+		// held by another replica
+		return p.GetLease(ctx, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	return &lease, nil
+}
+
+// ReleaseLease gives up the named lease if it is held by holder, so another replica can take over right away
+func (p *PostgresDB) ReleaseLease(ctx context.Context, name, holder string) (err error) {
+	ctx, span := startSpan(ctx, "ReleaseLease")
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.db.ExecContext(ctx, `DELETE FROM leader_leases WHERE name = $1 AND holder = $2`, name, holder)
+	if err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	return nil
+}
+
+// GetLease retrieves the named lease
+func (p *PostgresDB) GetLease(ctx context.Context, name string) (_ *models.Lease, err error) {
+	ctx, span := startSpan(ctx, "GetLease")
+	defer func() { endSpan(span, err) }()
+
+	var lease models.Lease
+	err = p.db.GetContext(ctx, &lease, `SELECT * FROM leader_leases WHERE name = $1`, name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get lease: %w", err)
+	}
+	return &lease, nil
+}
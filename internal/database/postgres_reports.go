@@ -0,0 +1,216 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/lib/pq"
+)
+
+// CreateReportSpec stores a new report spec
+func (p *PostgresDB) CreateReportSpec(ctx context.Context, spec *models.ReportSpec) (err error) {
+	ctx, span := startSpan(ctx, "CreateReportSpec")
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.db.NamedExecContext(ctx, annotate(ctx, `
+		INSERT INTO report_specs (id, name, ad_ids, campaigns, metrics, period, group_by, format, schedule, delivery, webhook_url, next_run_at, created_at)
+		VALUES (:id, :name, :ad_ids, :campaigns, :metrics, :period, :group_by, :format, :schedule, :delivery, :webhook_url, :next_run_at, :created_at)
+	`), spec)
+	if err != nil {
+		return fmt.Errorf("failed to insert report spec: %w", err)
+	}
+	return nil
+}
+
+// GetReportSpec retrieves a report spec by ID
+func (p *PostgresDB) GetReportSpec(ctx context.Context, id string) (_ *models.ReportSpec, err error) {
+	ctx, span := startSpan(ctx, "GetReportSpec")
+	defer func() { endSpan(span, err) }()
+
+	var spec models.ReportSpec
+	err = p.db.GetContext(ctx, &spec, annotate(ctx, `SELECT * FROM report_specs WHERE id = $1`), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get report spec: %w", err)
+	}
+	return &spec, nil
+}
+
+// ListReportSpecs returns every report spec by name
+func (p *PostgresDB) ListReportSpecs(ctx context.Context) (_ *[]models.ReportSpec, err error) {
+	ctx, span := startSpan(ctx, "ListReportSpecs")
+	defer func() { endSpan(span, err) }()
+
+	specs := []models.ReportSpec{}
+	err = p.db.SelectContext(ctx, &specs, annotate(ctx, `SELECT * FROM report_specs ORDER BY name, id`))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list report specs: %w", err)
+	}
+	return &specs, nil
+}
+
+// DeleteReportSpec deletes a report spec with its reports
+func (p *PostgresDB) DeleteReportSpec(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteReportSpec")
+	defer func() { endSpan(span, err) }()
+
+	result, err := p.db.ExecContext(ctx, annotate(ctx, `DELETE FROM report_specs WHERE id = $1`), id)
+	if err != nil {
+		return fmt.Errorf("failed to delete report spec: %w", err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListDueReportSpecs returns the report specs whose next run is at or before now
+func (p *PostgresDB) ListDueReportSpecs(ctx context.Context, now time.Time) (_ *[]models.ReportSpec, err error) {
+	ctx, span := startSpan(ctx, "ListDueReportSpecs")
+	defer func() { endSpan(span, err) }()
+
+	specs := []models.ReportSpec{}
+	err = p.db.SelectContext(ctx, &specs, annotate(ctx, `SELECT * FROM report_specs WHERE next_run_at <= $1 ORDER BY next_run_at`), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due report specs: %w", err)
+	}
+	return &specs, nil
+}
+
+// SetReportSpecRun records a run of a report spec and when the next one is due
+func (p *PostgresDB) SetReportSpecRun(ctx context.Context, id string, lastRun, nextRun time.Time) (err error) {
+	ctx, span := startSpan(ctx, "SetReportSpecRun")
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.db.ExecContext(ctx, annotate(ctx, `UPDATE report_specs SET last_run_at = $2, next_run_at = $3 WHERE id = $1`), id, lastRun, nextRun)
+	if err != nil {
+		return fmt.Errorf("failed to update report spec run: %w", err)
+	}
+	return nil
+}
+
+// GetReportRows aggregates the clicks of a report, archived ones included,
+// one row per group ordered by ad name then time
+func (p *PostgresDB) GetReportRows(ctx context.Context, query ReportQuery) (_ *[]models.ReportRow, err error) {
+	ctx, span := startSpan(ctx, "GetReportRows")
+	defer func() { endSpan(span, err) }()
+
+	var args []any
+	var columns, groups, conditions []string
+	source := clickSource(ClickFilter{From: query.From, To: query.To}, &args)
+	if query.ByAd || len(query.Campaigns) > 0 {
+		source += ` JOIN ads a ON a.id = clicks.ad_id`
+	}
+	if query.ByAd {
+		columns = append(columns, `a.name AS ad_name`, `clicks.ad_id`)
+	}
+	if query.Interval != "" {
+		args = append(args, query.Interval)
+		columns = append(columns, fmt.Sprintf(`date_trunc($%d, clicks.timestamp, 'UTC') AS bucket`, len(args)))
+	}
+	for i := range columns {
+		groups = append(groups, strconv.Itoa(i+1))
+	}
+	columns = append(columns, `COUNT(*) AS clicks`, `COALESCE(SUM(clicks.playback_time), 0) AS playback_time`)
+
+	if len(query.AdIDs) > 0 {
+		args = append(args, pq.Array(query.AdIDs))
+		conditions = append(conditions, fmt.Sprintf(`clicks.ad_id = ANY($%d::UUID[])`, len(args)))
+	}
+	if len(query.Campaigns) > 0 {
+		args = append(args, pq.Array(query.Campaigns))
+		conditions = append(conditions, fmt.Sprintf(`a.campaign = ANY($%d)`, len(args)))
+	}
+
+	sqlQuery := `SELECT ` + strings.Join(columns, `, `) + ` FROM ` + source
+	if len(conditions) > 0 {
+		sqlQuery += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	if len(groups) > 0 {
+		sqlQuery += ` GROUP BY ` + strings.Join(groups, `, `) + ` ORDER BY ` + strings.Join(groups, `, `)
+	}
+
+	rows := []models.ReportRow{}
+	err = p.reader().SelectContext(ctx, &rows, annotate(ctx, sqlQuery), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate report clicks: %w", err)
+	}
+	return &rows, nil
+}
+
+// CreateReport stores a generated report with its file
+func (p *PostgresDB) CreateReport(ctx context.Context, report *models.GeneratedReport) (err error) {
+	ctx, span := startSpan(ctx, "CreateReport")
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.db.NamedExecContext(ctx, annotate(ctx, `
+		INSERT INTO reports (id, spec_id, filename, content_type, size, content, period_start, period_end, delivery_status, delivery_error, created_at)
+		VALUES (:id, :spec_id, :filename, :content_type, :size, :content, :period_start, :period_end, :delivery_status, :delivery_error, :created_at)
+	`), report)
+	if err != nil {
+		return fmt.Errorf("failed to insert report: %w", err)
+	}
+	return nil
+}
+
+// SetReportDelivery records the outcome of delivering a report
+func (p *PostgresDB) SetReportDelivery(ctx context.Context, id, status string, deliveryError *string) (err error) {
+	ctx, span := startSpan(ctx, "SetReportDelivery")
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.db.ExecContext(ctx, annotate(ctx, `UPDATE reports SET delivery_status = $2, delivery_error = $3 WHERE id = $1`), id, status, deliveryError)
+	if err != nil {
+		return fmt.Errorf("failed to update report delivery: %w", err)
+	}
+	return nil
+}
+
+// GetReport retrieves a report by ID with its file
+func (p *PostgresDB) GetReport(ctx context.Context, id string) (_ *models.GeneratedReport, err error) {
+	ctx, span := startSpan(ctx, "GetReport")
+	defer func() { endSpan(span, err) }()
+
+	var report models.GeneratedReport
+	err = p.db.GetContext(ctx, &report, annotate(ctx, `SELECT * FROM reports WHERE id = $1`), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get report: %w", err)
+	}
+	return &report, nil
+}
+
+// ListReports returns a page of reports without their files, most recent first
+func (p *PostgresDB) ListReports(ctx context.Context, opts ListReportOptions) (_ *[]models.GeneratedReport, err error) {
+	ctx, span := startSpan(ctx, "ListReports")
+	defer func() { endSpan(span, err) }()
+
+	reports := []models.GeneratedReport{}
+	var args []any
+	var conditions []string
+	if opts.SpecID != "" {
+		args = append(args, opts.SpecID)
+		conditions = append(conditions, fmt.Sprintf(`spec_id = $%d`, len(args)))
+	}
+	if opts.After != nil {
+		conditions = append(conditions, keysetCondition("created_at", "TIMESTAMPTZ", "desc", *opts.After, &args))
+	}
+	query := `SELECT id, spec_id, filename, content_type, size, period_start, period_end, delivery_status, delivery_error, created_at FROM reports`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	query += keysetOrder("created_at", "desc") + keysetLimit(opts.PaginationOptions, &args)
+	err = p.db.SelectContext(ctx, &reports, annotate(ctx, query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reports: %w", err)
+	}
+	return &reports, nil
+}
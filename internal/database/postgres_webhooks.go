@@ -0,0 +1,212 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+)
+
+// CreateWebhook stores a new webhook
+func (p *PostgresDB) CreateWebhook(ctx context.Context, webhook *models.Webhook) (err error) {
+	ctx, span := startSpan(ctx, "CreateWebhook")
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.db.NamedExecContext(ctx, annotate(ctx, `
+		INSERT INTO webhooks (id, url, description, secret, created_at)
+		VALUES (:id, :url, :description, :secret, :created_at)
+	`), webhook)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook: %w", err)
+	}
+	return nil
+}
+
+// ListWebhooks returns every webhook with its secret, oldest first
+func (p *PostgresDB) ListWebhooks(ctx context.Context) (_ *[]models.Webhook, err error) {
+	ctx, span := startSpan(ctx, "ListWebhooks")
+	defer func() { endSpan(span, err) }()
+
+	webhooks := []models.Webhook{}
+	err = p.db.SelectContext(ctx, &webhooks, annotate(ctx, `SELECT * FROM webhooks ORDER BY created_at, id`))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	return &webhooks, nil
+}
+
+// DeleteWebhook deletes a webhook with its rules and deliveries
+func (p *PostgresDB) DeleteWebhook(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteWebhook")
+	defer func() { endSpan(span, err) }()
+
+	result, err := p.db.ExecContext(ctx, annotate(ctx, `DELETE FROM webhooks WHERE id = $1`), id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreateWebhookRule stores a new rule, ErrNotFound when its webhook doesn't exist
+func (p *PostgresDB) CreateWebhookRule(ctx context.Context, rule *models.WebhookRule) (err error) {
+	ctx, span := startSpan(ctx, "CreateWebhookRule")
+	defer func() { endSpan(span, err) }()
+
+	// the values of INSERT ... SELECT aren't typed by the columns, hence the casts
+	result, err := p.db.ExecContext(ctx, annotate(ctx, `
+		INSERT INTO webhook_rules (id, webhook_id, ad_id, metric, operator, threshold, period, created_at)
+		SELECT $1::UUID, id, $3::UUID, $4, $5, $6::DOUBLE PRECISION, $7, $8::TIMESTAMPTZ FROM webhooks WHERE id = $2
+	`), rule.ID, rule.WebhookID, rule.AdID, rule.Metric, rule.Operator, rule.Threshold, rule.Period, rule.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook rule: %w", err)
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListWebhookRules returns the rules of a webhook, or of every webhook when webhookID is empty, oldest first
+func (p *PostgresDB) ListWebhookRules(ctx context.Context, webhookID string) (_ *[]models.WebhookRule, err error) {
+	ctx, span := startSpan(ctx, "ListWebhookRules")
+	defer func() { endSpan(span, err) }()
+
+	rules := []models.WebhookRule{}
+	var args []any
+	query := `SELECT * FROM webhook_rules`
+	if webhookID != "" {
+		args = append(args, webhookID)
+		query += ` WHERE webhook_id = $1`
+	}
+	err = p.db.SelectContext(ctx, &rules, annotate(ctx, query+` ORDER BY created_at, id`), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook rules: %w", err)
+	}
+	return &rules, nil
+}
+
+// DeleteWebhookRule deletes a rule of a webhook, its deliveries are kept
+func (p *PostgresDB) DeleteWebhookRule(ctx context.Context, webhookID, id string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteWebhookRule")
+	defer func() { endSpan(span, err) }()
+
+	result, err := p.db.ExecContext(ctx, annotate(ctx, `DELETE FROM webhook_rules WHERE webhook_id = $1 AND id = $2`), webhookID, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook rule: %w", err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TriggerWebhookRule marks a rule triggered and queues its delivery in a single transaction,
+// so a rule is never notified twice for the same crossing
+func (p *PostgresDB) TriggerWebhookRule(ctx context.Context, id string, delivery *models.WebhookDelivery) (err error) {
+	ctx, span := startSpan(ctx, "TriggerWebhookRule")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, annotate(ctx, `
+		UPDATE webhook_rules SET triggered = TRUE, last_triggered_at = $2 WHERE id = $1 AND NOT triggered
+	`), id, delivery.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook rule: %w", err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		// deleted or triggered since it was read
+		return ErrNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, annotate(ctx, `
+		INSERT INTO webhook_deliveries (id, webhook_id, rule_id, event, payload, status, attempts, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`), delivery.ID, delivery.WebhookID, delivery.RuleID, delivery.Event, string(delivery.Payload),
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook delivery: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ResetWebhookRule re-arms a triggered rule once its condition is false again
+func (p *PostgresDB) ResetWebhookRule(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "ResetWebhookRule")
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.db.ExecContext(ctx, annotate(ctx, `UPDATE webhook_rules SET triggered = FALSE WHERE id = $1`), id)
+	if err != nil {
+		return fmt.Errorf("failed to reset webhook rule: %w", err)
+	}
+	return nil
+}
+
+// ListDueWebhookDeliveries returns up to limit pending deliveries whose next attempt is at or before now,
+// the longest waiting first
+func (p *PostgresDB) ListDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) (_ *[]models.WebhookDelivery, err error) {
+	ctx, span := startSpan(ctx, "ListDueWebhookDeliveries")
+	defer func() { endSpan(span, err) }()
+
+	deliveries := []models.WebhookDelivery{}
+	err = p.db.SelectContext(ctx, &deliveries, annotate(ctx, `
+		SELECT * FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= $1
+		ORDER BY next_attempt_at
+		LIMIT $2
+	`), now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due webhook deliveries: %w", err)
+	}
+	return &deliveries, nil
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt
+func (p *PostgresDB) UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) (err error) {
+	ctx, span := startSpan(ctx, "UpdateWebhookDelivery")
+	defer func() { endSpan(span, err) }()
+
+	_, err = p.db.NamedExecContext(ctx, annotate(ctx, `
+		UPDATE webhook_deliveries
+		SET status = :status, attempts = :attempts, next_attempt_at = :next_attempt_at,
+			response_status = :response_status, error = :error, delivered_at = :delivered_at
+		WHERE id = :id
+	`), delivery)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns a page of the deliveries of a webhook, most recent first
+func (p *PostgresDB) ListWebhookDeliveries(ctx context.Context, opts ListWebhookDeliveryOptions) (_ *[]models.WebhookDelivery, err error) {
+	ctx, span := startSpan(ctx, "ListWebhookDeliveries")
+	defer func() { endSpan(span, err) }()
+
+	deliveries := []models.WebhookDelivery{}
+	args := []any{opts.WebhookID}
+	conditions := []string{`webhook_id = $1`}
+	if opts.After != nil {
+		conditions = append(conditions, keysetCondition("created_at", "TIMESTAMPTZ", "desc", *opts.After, &args))
+	}
+	query := `SELECT * FROM webhook_deliveries WHERE ` + strings.Join(conditions, ` AND `) +
+		keysetOrder("created_at", "desc") + keysetLimit(opts.PaginationOptions, &args)
+	err = p.db.SelectContext(ctx, &deliveries, annotate(ctx, query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	return &deliveries, nil
+}
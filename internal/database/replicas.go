@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/monitoring"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	replicaHealthCheckInterval = 10 * time.Second
+	replicaHealthCheckTimeout  = 2 * time.Second
+)
+
+// replica is a read-only connection pool which is only used while it answers health checks
+type replica struct {
+	name    string
+	db      *sqlx.DB
+	healthy atomic.Bool
+}
+
+func newReplica(name, connString string, pool PoolOptions) (*replica, error) {
+	// don't connect yet, an unreachable replica must not stop the service from starting
+	db, err := sqlx.Open("postgres", connString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	configurePool(db, pool)
+	return &replica{name: name, db: db}, nil
+}
+
+// reader returns the pool to use for a read-only query, round robin over the healthy
+// replicas and the primary when there is none
+func (p *PostgresDB) reader() *sqlx.DB {
+	n := len(p.replicas)
+	start := p.nextReplica.Add(1)
+	for i := range n {
+		r := p.replicas[(start+uint64(i))%uint64(n)]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+	return p.db
+}
+
+// checkReplicas pings every replica and takes the unreachable ones out of rotation
+func (p *PostgresDB) checkReplicas() {
+	for _, r := range p.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), replicaHealthCheckTimeout)
+		err := r.db.PingContext(ctx)
+		cancel()
+
+		healthy := err == nil
+		monitoring.SetDatabaseReplicaHealthy(r.name, healthy)
+		if r.healthy.Swap(healthy) == healthy {
+			continue
+		}
+		if healthy {
+			logger.InfoLog("Database %s is healthy, routing reads to it", r.name)
+		} else {
+			logger.ErrorLog("Database %s failed health check, routing its reads to the primary: %v", r.name, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// SearchModes are the ways ListAds can search ads:
+//   - fulltext: Postgres full text search, matching every word of the search as a prefix of
+//     a word of the name or description (stemmed), ranked with matches in the name first
+//   - trigram: similarity search tolerating typos, also matching any substring, ranked by
+//     how close the closest words are
+var SearchModes = []string{"fulltext", "trigram"}
+
+// adDocument is the text search document of an ad, indexed by ads_search_idx
+const adDocument = `(setweight(to_tsvector('english', name), 'A') || setweight(to_tsvector('english', COALESCE(description, '')), 'B'))`
+
+// adText is the text of an ad searched by trigrams, indexed by ads_search_trgm_idx
+const adText = `(name || ' ' || COALESCE(description, ''))`
+
+// the matches in snippets are delimited by control characters, replaced by <mark> tags
+// once the snippet is escaped (see highlight)
+const (
+	startSel = "\x02"
+	stopSel  = "\x03"
+)
+
+var headlineOptions = fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=30, MinWords=10, MaxFragments=2", startSel, stopSel)
+
+// prefixQuery turns a search into a tsquery matching every word as a prefix, e.g. "vid ad" into
+// "vid:* & ad:*". Anything but letters and digits separates words, so a search can't inject
+// tsquery operators, and one without any word matches nothing.
+func prefixQuery(search string) string {
+	words := strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// searchCondition selects the ads matching the search of opts
+func searchCondition(opts ListAdOptions, args *[]any) string {
+	if opts.SearchMode == "trigram" {
+		*args = append(*args, opts.Search)
+		return fmt.Sprintf(`($%[1]d <%% %[2]s OR %[2]s ILIKE '%%' || $%[1]d || '%%')`, len(*args), adText)
+	}
+	*args = append(*args, prefixQuery(opts.Search))
+	return fmt.Sprintf(`%s @@ to_tsquery('english', $%d)`, adDocument, len(*args))
+}
+
+// searchRank ranks the ads matching the search of opts, higher is more relevant.
+// It is a FLOAT8 so that its value round trips through cursors exactly.
+func searchRank(opts ListAdOptions, args *[]any) string {
+	if opts.SearchMode == "trigram" {
+		*args = append(*args, opts.Search)
+		return fmt.Sprintf(`word_similarity($%d, %s)::FLOAT8`, len(*args), adText)
+	}
+	*args = append(*args, prefixQuery(opts.Search))
+	return fmt.Sprintf(`ts_rank(%s, to_tsquery('english', $%d))::FLOAT8`, adDocument, len(*args))
+}
+
+// searchSnippet is an excerpt of the description around the words of the search of opts.
+// Postgres evaluates it after the LIMIT, for the listed ads only.
+func searchSnippet(opts ListAdOptions, args *[]any) string {
+	*args = append(*args, prefixQuery(opts.Search), headlineOptions)
+	return fmt.Sprintf(`ts_headline('english', COALESCE(description, ''), to_tsquery('english', $%d), $%d)`, len(*args)-1, len(*args))
+}
+
+// highlight escapes a snippet for HTML, marking the matches with <mark> tags
+func highlight(snippet string) string {
+	return strings.NewReplacer(startSel, "<mark>", stopSel, "</mark>").Replace(html.EscapeString(snippet))
+}
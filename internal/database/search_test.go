@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// newTestDB connects to the database of DATABASE_URL and sets it up, skipping the test without one
+func newTestDB(t *testing.T) *PostgresDB {
+	t.Helper()
+	connString := os.Getenv("DATABASE_URL")
+	if connString == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	db, err := NewPostgresDB(connString, PoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Setup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSearchAds(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// a word no other ad contains, so that the searches only match the ads of the test.
+	// Its digits keep it and its prefixes from being stemmed.
+	word := "zq7" + strings.ReplaceAll(uuid.New().String(), "-", "")[:10] + "7"
+	inName := map[string]bool{}
+	var ids []string
+	for i, ad := range []models.Ad{
+		{Name: word + " summer", Description: "Summer sale video"},
+		{Name: "Winter " + word, Description: "Winter sale video"},
+		{Name: word, Description: "Video ad"},
+		{Name: "Spring sale", Description: "Our " + word + " video with 20% off"},
+		{Name: "Autumn sale", Description: "The " + word + " of the autumn"},
+	} {
+		ad.ID = uuid.New().String()
+		ad.ImageURL = "https://example.com/image.png"
+		ad.TargetURL = "https://example.com"
+		ad.Status = "active"
+		ad.CreatedAt = time.Now().Add(time.Duration(i) * time.Second)
+		if err := db.CreateAd(ctx, &ad); err != nil {
+			t.Fatal(err)
+		}
+		inName[ad.ID] = strings.Contains(ad.Name, word)
+		ids = append(ids, ad.ID)
+	}
+	t.Cleanup(func() {
+		if _, err := db.db.Exec(`DELETE FROM ads WHERE id = ANY($1)`, pq.StringArray(ids)); err != nil {
+			t.Errorf("deleting the ads of the test: %v", err)
+		}
+	})
+
+	searches := map[string]string{
+		"fulltext": word[:len(word)-2],  // a prefix of the word
+		"trigram":  word[:4] + word[5:], // the word with a typo
+	}
+	for _, mode := range SearchModes {
+		t.Run(mode, func(t *testing.T) {
+			opts := ListAdOptions{Search: searches[mode], SearchMode: mode}
+			opts.Limit = 2
+			opts.Keyset = true
+			opts.Default()
+			if opts.Sort != "relevance" {
+				t.Fatalf("searches are sorted by %s, want relevance", opts.Sort)
+			}
+
+			seen := map[string]bool{}
+			var ranks []float64
+			for page := 1; ; page++ {
+				ads, err := db.ListAds(ctx, opts)
+				if err != nil {
+					t.Fatalf("page %d: %v", page, err)
+				}
+				next := apihelpers.NextCursor(opts.PaginationOptions, ads, func(ad models.ListedAd) apihelpers.Cursor {
+					return AdCursor(ad, opts)
+				})
+				for _, ad := range *ads {
+					if seen[ad.ID] {
+						t.Errorf("page %d lists ad %s again", page, ad.ID)
+					}
+					seen[ad.ID] = true
+					if ad.Search == nil {
+						t.Fatalf("ad %s has no search rank", ad.ID)
+					}
+					if mode == "fulltext" && !inName[ad.ID] && !strings.Contains(ad.Search.Snippet, "<mark>"+word+"</mark>") {
+						t.Errorf("snippet %q doesn't mark the search", ad.Search.Snippet)
+					}
+					ranks = append(ranks, ad.Search.Rank)
+				}
+				if next == "" {
+					break
+				}
+				cursor, err := apihelpers.DecodeCursor(next)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opts.After = &cursor
+			}
+
+			if len(seen) != len(ids) {
+				t.Errorf("listed %d ads, want the %d ads of the test", len(seen), len(ids))
+			}
+			for i := 1; i < len(ranks); i++ {
+				if ranks[i] > ranks[i-1] {
+					t.Errorf("ranks %v are not in decreasing order", ranks)
+					break
+				}
+			}
+			count, err := db.CountAds(ctx, ListAdOptions{Search: searches[mode], SearchMode: mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != len(ids) {
+				t.Errorf("counted %d ads, want %d", count, len(ids))
+			}
+		})
+	}
+
+	// matches in the name rank higher
+	opts := ListAdOptions{Search: word}
+	opts.Default()
+	ads, err := db.ListAds(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ad := range *ads {
+		if want := i < 3; inName[ad.ID] != want {
+			t.Errorf("ad %d of the full text search is %q, want the ads named after the search first", i, ad.Name)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/JalajGoswami/video-ad-metrics/internal/database")
+
+// startSpan starts a client span for a repository operation, child of the span in ctx (if any)
+func startSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "postgres "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemNamePostgreSQL, semconv.DBOperationName(operation)),
+	)
+}
+
+// endSpan ends span, marking it failed on unexpected errors (a missing record is an expected outcome)
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// annotate appends a sqlcommenter style comment with the request ID and traceparent of ctx to
+// query, so a query seen in pg_stat_activity or the Postgres logs leads back to its request
+func annotate(ctx context.Context, query string) string {
+	var tags []string
+	if requestID := apihelpers.RequestIDFromContext(ctx); requestID != "" {
+		tags = append(tags, "request_id='"+url.QueryEscape(requestID)+"'")
+	}
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	if traceparent := carrier.Get("traceparent"); traceparent != "" {
+		tags = append(tags, "traceparent='"+url.QueryEscape(traceparent)+"'")
+	}
+	if len(tags) == 0 {
+		return query
+	}
+	return query + " /*" + strings.Join(tags, ",") + "*/"
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"cmp"
+	"net/http"
+	"strconv"
+
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
+	"github.com/JalajGoswami/video-ad-metrics/internal/config"
+	"github.com/JalajGoswami/video-ad-metrics/internal/jobs"
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+)
+
+// AdminHandler contains the dependencies needed for the admin HTTP handlers
+type AdminHandler struct {
+	Scheduler *jobs.Scheduler
+	Config    *config.Runtime
+}
+
+// NewAdminHandler creates a new AdminHandler
+func NewAdminHandler(scheduler *jobs.Scheduler, cfg *config.Runtime) *AdminHandler {
+	return &AdminHandler{
+		Scheduler: scheduler,
+		Config:    cfg,
+	}
+}
+
+// GetConfig returns the effective config with secrets redacted
+func (h *AdminHandler) GetConfig(w http.ResponseWriter, r *http.Request) {
+	cfg, err := h.Config.RedactedMap()
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error retrieving config: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving config")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, cfg, "")
+}
+
+// ListJobs returns every registered background job with its state and last run
+func (h *AdminHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	statuses, err := h.Scheduler.Jobs(r.Context())
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error retrieving jobs: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving jobs")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, statuses, "")
+}
+
+// ListJobRuns returns the most recent runs of a background job
+func (h *AdminHandler) ListJobRuns(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(cmp.Or(r.URL.Query().Get("limit"), "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		logger.RequestLogger.Error(r, "Invalid limit: %v", r.URL.Query().Get("limit"))
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "invalid value for query param `limit` provided")
+		return
+	}
+
+	runs, err := h.Scheduler.Runs(r.Context(), r.PathValue("name"), limit)
+	if err != nil {
+		h.jobError(w, r, err, "Error retrieving job runs")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, runs, "")
+}
+
+// TriggerJob starts a run of a background job right away
+func (h *AdminHandler) TriggerJob(w http.ResponseWriter, r *http.Request) {
+	if err := h.Scheduler.Trigger(r.PathValue("name")); err != nil {
+		h.jobError(w, r, err, "Error triggering job")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusAccepted, nil, "Job triggered successfully")
+}
+
+// PauseJob stops scheduled runs of a background job on every replica
+func (h *AdminHandler) PauseJob(w http.ResponseWriter, r *http.Request) {
+	if err := h.Scheduler.Pause(r.Context(), r.PathValue("name")); err != nil {
+		h.jobError(w, r, err, "Error pausing job")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, nil, "Job paused successfully")
+}
+
+// ResumeJob re-enables scheduled runs of a paused background job
+func (h *AdminHandler) ResumeJob(w http.ResponseWriter, r *http.Request) {
+	if err := h.Scheduler.Resume(r.Context(), r.PathValue("name")); err != nil {
+		h.jobError(w, r, err, "Error resuming job")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, nil, "Job resumed successfully")
+}
+
+func (h *AdminHandler) jobError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	switch err {
+	case jobs.ErrJobNotFound:
+		logger.RequestLogger.Error(r, "Job not found")
+		apihelpers.ErrorResponse(r, w, http.StatusNotFound, "Job not found")
+	case jobs.ErrJobAlreadyRunning:
+		logger.RequestLogger.Error(r, "Job is already running")
+		apihelpers.ErrorResponse(r, w, http.StatusConflict, "Job is already running")
+	case jobs.ErrSchedulerStopped:
+		logger.RequestLogger.Error(r, "Scheduler is not running")
+		apihelpers.ErrorResponse(r, w, http.StatusServiceUnavailable, "Scheduler is not running")
+	default:
+		logger.RequestLogger.Error(r, "%s: %v", message, err)
+		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, message)
+	}
+}
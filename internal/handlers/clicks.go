@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+)
+
+// ClickList is a page of clicks
+type ClickList struct {
+	Values []models.ListedClick `json:"values"`
+	// the cursor of the next page, empty on the last page
+	NextCursor string `json:"next_cursor"`
+	// total number of clicks, with include_total only
+	Total *int `json:"total,omitempty"`
+}
+
+// ListClicks returns the clicks of every ad, or of the ad_id one, by timestamp, archived ones included
+func (h *Handler) ListClicks(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("ad_id")
+	if id != "" {
+		logger.SetAdID(r, id)
+		if uuid.Validate(id) != nil {
+			logger.RequestLogger.Error(r, "Invalid ad ID: %v", id)
+			apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid ad ID")
+			return
+		}
+	}
+
+	opts, err := listClickOptions(r)
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error in list parameters: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.AdID = id
+
+	if id != "" {
+		if _, err := h.DB.GetAd(r.Context(), id); err != nil {
+			if err == database.ErrNotFound {
+				logger.RequestLogger.Error(r, "Ad not found")
+				apihelpers.ErrorResponse(r, w, http.StatusNotFound, "Ad not found")
+			} else {
+				logger.RequestLogger.Error(r, "Error retrieving ad: %v", err)
+				apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving ad")
+			}
+			return
+		}
+	}
+
+	clicks, err := h.DB.ListClicks(r.Context(), opts)
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error retrieving clicks: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving clicks")
+		return
+	}
+	result := ClickList{}
+	result.NextCursor = apihelpers.NextCursor(opts.PaginationOptions, clicks, func(click models.ListedClick) apihelpers.Cursor {
+		return database.ClickCursor(click, opts)
+	})
+	result.Values = *clicks
+
+	if opts.WithTotal {
+		totalCount, err := h.DB.CountClicks(r.Context(), opts)
+		if err != nil {
+			logger.RequestLogger.Error(r, "Error retrieving clicks count: %v", err)
+			apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving clicks count")
+			return
+		}
+		result.Total = &totalCount
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, result, "")
+}
+
+// listClickOptions reads the time range, order and pagination query params of ListClicks
+func listClickOptions(r *http.Request) (database.ListClickOptions, error) {
+	opts := database.ListClickOptions{}
+	query := r.URL.Query()
+	var err error
+	if opts.Order, err = orderParam(query); err != nil {
+		return opts, err
+	}
+	if opts.From, err = timeParam(query, "from"); err != nil {
+		return opts, err
+	}
+	if opts.To, err = timeParam(query, "to"); err != nil {
+		return opts, err
+	}
+	if opts.PaginationOptions, err = apihelpers.CursorPagination(r); err != nil {
+		return opts, err
+	}
+	opts.Default()
+	if opts.After != nil && opts.After.Sort != opts.CursorSort() {
+		return opts, errors.New("query param `cursor` was issued for another sort order")
+	}
+	return opts, nil
+}
+
+// clickEncoder writes clicks in an export format
+type clickEncoder interface {
+	Encode(click models.ListedClick) error
+	// Flush writes the buffered clicks
+	Flush() error
+	// Close writes the buffered clicks and ends the export
+	Close() error
+}
+
+// exportFormats are the formats of ExportClicks by name
+var exportFormats = map[string]struct {
+	contentType string
+	newEncoder  func(w io.Writer) clickEncoder
+}{
+	"csv":     {"text/csv; charset=utf-8", newCSVClickEncoder},
+	"ndjson":  {"application/x-ndjson", newNDJSONClickEncoder},
+	"parquet": {"application/vnd.apache.parquet", newParquetClickEncoder},
+}
+
+var clickCSVHeader = []string{"id", "ad_id", "timestamp", "ip_address", "playback_time", "created_at", "archived"}
+
+type csvClickEncoder struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func newCSVClickEncoder(w io.Writer) clickEncoder {
+	return &csvClickEncoder{w: csv.NewWriter(w)}
+}
+
+func (e *csvClickEncoder) Encode(click models.ListedClick) error {
+	if !e.wroteHeader {
+		e.wroteHeader = true
+		if err := e.w.Write(clickCSVHeader); err != nil {
+			return err
+		}
+	}
+	return e.w.Write([]string{
+		click.ID, click.AdID, click.Timestamp.Format(time.RFC3339Nano), click.IPAddress,
+		strconv.Itoa(click.PlaybackTime), click.CreatedAt.Format(time.RFC3339Nano), strconv.FormatBool(click.Archived),
+	})
+}
+
+func (e *csvClickEncoder) Flush() error {
+	if !e.wroteHeader {
+		// an empty export still has its header
+		e.wroteHeader = true
+		e.w.Write(clickCSVHeader)
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvClickEncoder) Close() error {
+	return e.Flush()
+}
+
+type ndjsonClickEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newNDJSONClickEncoder(w io.Writer) clickEncoder {
+	buffered := bufio.NewWriter(w)
+	return &ndjsonClickEncoder{w: buffered, enc: json.NewEncoder(buffered)}
+}
+
+func (e *ndjsonClickEncoder) Encode(click models.ListedClick) error {
+	return e.enc.Encode(click)
+}
+
+func (e *ndjsonClickEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+func (e *ndjsonClickEncoder) Close() error {
+	return e.Flush()
+}
+
+// parquetRowGroupRows bounds the rows of a Parquet row group, which is buffered until it is complete
+const parquetRowGroupRows = 100_000
+
+// parquetClick is a row of the Parquet export, with the columns of the CSV export
+type parquetClick struct {
+	ID           string    `parquet:"id"`
+	AdID         string    `parquet:"ad_id"`
+	Timestamp    time.Time `parquet:"timestamp,timestamp(microsecond)"`
+	IPAddress    string    `parquet:"ip_address"`
+	PlaybackTime int32     `parquet:"playback_time"`
+	CreatedAt    time.Time `parquet:"created_at,timestamp(microsecond)"`
+	Archived     bool      `parquet:"archived"`
+}
+
+// parquetClickEncoder writes row groups as they fill up, the file is only readable once
+// Close wrote its footer
+type parquetClickEncoder struct {
+	w    *parquet.GenericWriter[parquetClick]
+	rows []parquetClick
+}
+
+func newParquetClickEncoder(w io.Writer) clickEncoder {
+	return &parquetClickEncoder{w: parquet.NewGenericWriter[parquetClick](w,
+		parquet.Compression(&parquet.Snappy),
+		parquet.MaxRowsPerRowGroup(parquetRowGroupRows),
+	)}
+}
+
+func (e *parquetClickEncoder) Encode(click models.ListedClick) error {
+	e.rows = append(e.rows, parquetClick{
+		ID: click.ID, AdID: click.AdID, Timestamp: click.Timestamp, IPAddress: click.IPAddress,
+		PlaybackTime: int32(click.PlaybackTime), CreatedAt: click.CreatedAt, Archived: click.Archived,
+	})
+	return nil
+}
+
+func (e *parquetClickEncoder) Flush() error {
+	_, err := e.w.Write(e.rows)
+	e.rows = e.rows[:0]
+	return err
+}
+
+func (e *parquetClickEncoder) Close() error {
+	if err := e.Flush(); err != nil {
+		return err
+	}
+	return e.w.Close()
+}
+
+// exportFlushRows is the number of clicks written between flushes, so that exports reach the client as they go
+const exportFlushRows = 1000
+
+// ExportClicks streams the clicks of a time range, archived ones included, as CSV, NDJSON or Parquet
+func (h *Handler) ExportClicks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format, ok := exportFormats[query.Get("format")]
+	if !ok {
+		logger.RequestLogger.Error(r, "Invalid export format: %v", query.Get("format"))
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "invalid value for query param `format` provided, it must be csv, ndjson or parquet")
+		return
+	}
+	filter := database.ClickFilter{AdID: query.Get("ad_id")}
+	if filter.AdID != "" && uuid.Validate(filter.AdID) != nil {
+		logger.RequestLogger.Error(r, "Invalid ad ID: %v", filter.AdID)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid ad ID")
+		return
+	}
+	var err error
+	if filter.From, err = timeParam(query, "from"); err == nil {
+		filter.To, err = timeParam(query, "to")
+	}
+	if err == nil && (filter.From.IsZero() || filter.To.IsZero()) {
+		err = errors.New("query params `from` and `to` are required")
+	}
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error in export parameters: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// exports outlast the server's write timeout
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logger.RequestLogger.Error(r, "Error clearing the write deadline: %v", err)
+	}
+
+	encoder := format.newEncoder(w)
+	rows := 0
+	start := func() {
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="clicks-%s-%s.%s"`,
+			filter.From.UTC().Format("20060102T150405Z"), filter.To.UTC().Format("20060102T150405Z"), query.Get("format")))
+		w.WriteHeader(http.StatusOK)
+	}
+	err = h.DB.ExportClicks(r.Context(), filter, func(click models.ListedClick) error {
+		if rows == 0 {
+			start()
+		}
+		if err := encoder.Encode(click); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushRows == 0 {
+			if err := encoder.Flush(); err != nil {
+				return err
+			}
+			return controller.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error exporting clicks after %d rows: %v", rows, err)
+		if rows == 0 {
+			apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error exporting clicks")
+			return
+		}
+		// the status was sent already, aborting the response tells the client that the export is incomplete
+		panic(http.ErrAbortHandler)
+	}
+	if rows == 0 {
+		start()
+	}
+	if err := encoder.Close(); err != nil {
+		logger.RequestLogger.Error(r, "Error writing clicks export: %v", err)
+	}
+}
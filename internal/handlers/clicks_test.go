@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/JalajGoswami/video-ad-metrics/internal/openapi"
+	"github.com/JalajGoswami/video-ad-metrics/internal/stream"
+	"github.com/parquet-go/parquet-go"
+)
+
+const testAdID = "6f1c3b0e-4b8f-4c39-9a51-6f0b0c1d2e3f"
+
+// clicksRepository serves the clicks of testAdID, the methods the tests don't use panic
+type clicksRepository struct {
+	database.Repository
+	clicks []models.ListedClick
+}
+
+func (r *clicksRepository) GetAd(ctx context.Context, id string) (*models.Ad, error) {
+	if id != testAdID {
+		return nil, database.ErrNotFound
+	}
+	return &models.Ad{ID: id}, nil
+}
+
+func (r *clicksRepository) ListClicks(ctx context.Context, opts database.ListClickOptions) (*[]models.ListedClick, error) {
+	clicks := r.clicks[:min(opts.Limit+1, len(r.clicks))]
+	return &clicks, nil
+}
+
+func (r *clicksRepository) ExportClicks(ctx context.Context, filter database.ClickFilter, fn func(click models.ListedClick) error) error {
+	for _, click := range r.clicks {
+		if err := fn(click); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newClicksServer(t *testing.T) (*httptest.Server, *clicksRepository) {
+	t.Helper()
+	created := time.Date(2025, 1, 1, 0, 0, 10, 0, time.UTC)
+	repo := &clicksRepository{}
+	for i := range 3 {
+		repo.clicks = append(repo.clicks, models.ListedClick{
+			Click: models.Click{
+				ID: "click-" + string(rune('a'+i)), AdID: testAdID, IPAddress: "10.0.0.1",
+				Timestamp: created.Add(-time.Duration(i) * time.Minute), PlaybackTime: 10 * (i + 1), CreatedAt: created,
+			},
+			Archived: i == 2,
+		})
+	}
+
+	clicks := stream.NewHub(1)
+	mux := http.NewServeMux()
+	openapi.Register(mux, Routes(NewHandler(repo, clicks), nil, nil, nil, nil, nil, http.NotFoundHandler()),
+		apihelpers.AdminAuthMiddleware(func() string { return "admin-token" }))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, repo
+}
+
+func get(t *testing.T, url string, token string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading GET %s: %v", url, err)
+	}
+	return res, body
+}
+
+func TestListClicksRoute(t *testing.T) {
+	server, repo := newClicksServer(t)
+	url := server.URL + "/admin/clicks?ad_id=" + testAdID + "&rows=2"
+
+	// clicks carry the IP address of the viewers
+	if res, _ := get(t, url, ""); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /admin/clicks without the admin token responded %d, want 401", res.StatusCode)
+	}
+
+	res, body := get(t, url, "admin-token")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET /admin/clicks responded %d: %s", res.StatusCode, body)
+	}
+	var envelope struct {
+		Result ClickList `json:"result"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		t.Fatalf("decoding clicks: %v", err)
+	}
+	if len(envelope.Result.Values) != 2 || envelope.Result.Values[0].ID != repo.clicks[0].ID || envelope.Result.NextCursor == "" {
+		t.Errorf("GET /admin/clicks returned %+v, want the first 2 clicks and a next cursor", envelope.Result)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/admin/clicks", http.StatusOK},
+		{"/admin/clicks?ad_id=00000000-0000-0000-0000-000000000000", http.StatusNotFound},
+		{"/admin/clicks?ad_id=not-a-uuid", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if res, body := get(t, server.URL+tt.path, "admin-token"); res.StatusCode != tt.status {
+			t.Errorf("GET %s responded %d, want %d: %s", tt.path, res.StatusCode, tt.status, body)
+		}
+	}
+}
+
+func TestExportClicksParquet(t *testing.T) {
+	server, repo := newClicksServer(t)
+	url := server.URL + "/admin/clicks/export?format=parquet&from=2025-01-01T00:00:00Z&to=2025-01-02T00:00:00Z"
+
+	if res, _ := get(t, url, ""); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("export without the admin token responded %d, want 401", res.StatusCode)
+	}
+
+	res, body := get(t, url, "admin-token")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("export responded %d: %s", res.StatusCode, body)
+	}
+	if contentType := res.Header.Get("Content-Type"); contentType != "application/vnd.apache.parquet" {
+		t.Errorf("export Content-Type is %s, want application/vnd.apache.parquet", contentType)
+	}
+	rows, err := parquet.Read[parquetClick](bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("reading the Parquet export: %v", err)
+	}
+	if len(rows) != len(repo.clicks) {
+		t.Fatalf("Parquet export has %d rows, want %d", len(rows), len(repo.clicks))
+	}
+	for i, row := range rows {
+		click := repo.clicks[i]
+		want := parquetClick{
+			ID: click.ID, AdID: click.AdID, Timestamp: click.Timestamp, IPAddress: click.IPAddress,
+			PlaybackTime: int32(click.PlaybackTime), CreatedAt: click.CreatedAt, Archived: click.Archived,
+		}
+		if !row.Timestamp.Equal(want.Timestamp) || !row.CreatedAt.Equal(want.CreatedAt) {
+			t.Errorf("row %d has timestamps %v and %v, want %v and %v", i, row.Timestamp, row.CreatedAt, want.Timestamp, want.CreatedAt)
+		}
+		row.Timestamp, row.CreatedAt, want.Timestamp, want.CreatedAt = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+		if row != want {
+			t.Errorf("row %d is %+v, want %+v", i, row, want)
+		}
+	}
+
+	// an empty export is still a valid file
+	repo.clicks = nil
+	_, body = get(t, url, "admin-token")
+	if rows, err := parquet.Read[parquetClick](bytes.NewReader(body), int64(len(body))); err != nil || len(rows) != 0 {
+		t.Errorf("empty Parquet export read as %d rows, %v", len(rows), err)
+	}
+}
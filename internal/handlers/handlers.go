@@ -2,26 +2,36 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
 	"slices"
+	"strings"
 	"time"
 
 	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
 	"github.com/JalajGoswami/video-ad-metrics/internal/database"
 	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
 	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/JalajGoswami/video-ad-metrics/internal/monitoring"
+	"github.com/JalajGoswami/video-ad-metrics/internal/stream"
 	"github.com/google/uuid"
 )
 
 // Handler contains the dependencies needed for the HTTP handlers
 type Handler struct {
 	DB database.Repository
+	// receives the logged clicks for the live streams
+	Clicks *stream.Hub
 }
 
 // NewHandler creates a new Handler
-func NewHandler(db database.Repository) *Handler {
+func NewHandler(db database.Repository, clicks *stream.Hub) *Handler {
 	return &Handler{
-		DB: db,
+		DB:     db,
+		Clicks: clicks,
 	}
 }
 
@@ -34,10 +44,19 @@ func (h *Handler) CreateAd(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	if ad.Status == "" {
+		ad.Status = "active"
+	}
+	if err := validateAdUpdate(models.AdUpdate{Status: &ad.Status, Campaign: &ad.Campaign}); err != nil {
+		logger.RequestLogger.Error(r, "Invalid ad: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, err.Error())
+		return
+	}
 	ad.ID = uuid.New().String()
 	ad.CreatedAt = time.Now()
 
-	if err := h.DB.CreateAd(&ad); err != nil {
+	logger.SetAdID(r, ad.ID)
+	if err := h.DB.CreateAd(r.Context(), &ad); err != nil {
 		logger.RequestLogger.Error(r, "Error creating ad: %v", err)
 		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error creating ad")
 		return
@@ -46,16 +65,64 @@ func (h *Handler) CreateAd(w http.ResponseWriter, r *http.Request) {
 	apihelpers.SuccessResponse(r, w, http.StatusCreated, ad, "Ad created successfully")
 }
 
+// validateAdUpdate checks the status and campaign of an ad
+func validateAdUpdate(update models.AdUpdate) error {
+	if update.Status != nil && !slices.Contains(database.AdStatuses, *update.Status) {
+		return errors.New("status must be one of " + strings.Join(database.AdStatuses, ", "))
+	}
+	if update.Campaign != nil && len(*update.Campaign) > database.MaxCampaignLength {
+		return fmt.Errorf("campaign must be at most %d bytes long", database.MaxCampaignLength)
+	}
+	return nil
+}
+
+// UpdateAd changes the status or campaign of an ad
+func (h *Handler) UpdateAd(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	logger.SetAdID(r, id)
+	if uuid.Validate(id) != nil {
+		logger.RequestLogger.Error(r, "Invalid ad ID: %v", id)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid ad ID")
+		return
+	}
+	var update models.AdUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		logger.RequestLogger.Error(r, "Error decoding request body: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	if err := validateAdUpdate(update); err != nil {
+		logger.RequestLogger.Error(r, "Invalid ad update: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ad, err := h.DB.UpdateAd(r.Context(), id, update)
+	if err != nil {
+		if err == database.ErrNotFound {
+			logger.RequestLogger.Error(r, "Ad not found")
+			apihelpers.ErrorResponse(r, w, http.StatusNotFound, "Ad not found")
+		} else {
+			logger.RequestLogger.Error(r, "Error updating ad: %v", err)
+			apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error updating ad")
+		}
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, ad, "Ad updated successfully")
+}
+
 // GetAd retrieves an ad by ID
 func (h *Handler) GetAd(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
+	logger.SetAdID(r, id)
 	if uuid.Validate(id) != nil {
 		logger.RequestLogger.Error(r, "Invalid ad ID: %v", id)
 		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid ad ID")
 		return
 	}
 
-	ad, err := h.DB.GetAd(id)
+	ad, err := h.DB.GetAd(r.Context(), id)
 	if err != nil {
 		if err == database.ErrNotFound {
 			logger.RequestLogger.Error(r, "Ad not found")
@@ -70,37 +137,142 @@ func (h *Handler) GetAd(w http.ResponseWriter, r *http.Request) {
 	apihelpers.SuccessResponse(r, w, http.StatusOK, ad, "")
 }
 
-// ListAds returns all ads
-func (h *Handler) ListAds(w http.ResponseWriter, r *http.Request) {
+// AdList is a page of ads
+type AdList struct {
+	Values []models.ListedAd `json:"values"`
+	// page mode only
+	Pages *apihelpers.Pages `json:"pages,omitempty"`
+	// cursor mode only, the cursor of the next page, empty on the last page
+	NextCursor *string `json:"next_cursor,omitempty"`
+	// total number of ads, in page mode and with include_total in cursor mode
+	Total *int `json:"total,omitempty"`
+}
+
+// timeParam reads an RFC 3339 date time query param, zero when it is missing
+func timeParam(query url.Values, name string) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("invalid value for query param `" + name + "` provided, it must be an RFC 3339 date time")
+	}
+	return t, nil
+}
+
+// orderParam reads the order query param, asc or desc, empty when it is missing
+func orderParam(query url.Values) (string, error) {
+	order := query.Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		return "", errors.New("invalid value for query param `order` provided")
+	}
+	return order, nil
+}
+
+var domainPattern = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)*$`)
+
+// listAdOptions reads the filters, sort and pagination query params of ListAds
+func listAdOptions(r *http.Request) (database.ListAdOptions, error) {
 	opts := database.ListAdOptions{}
 	query := r.URL.Query()
 	opts.Search = query.Get("search")
-	opts.Order = query.Get("order")
-	pageOpts, getPaginationObject, err := apihelpers.Pagination(r)
+	var err error
+	if opts.Order, err = orderParam(query); err != nil {
+		return opts, err
+	}
+	opts.SearchMode = query.Get("search_mode")
+	if opts.SearchMode != "" && !slices.Contains(database.SearchModes, opts.SearchMode) {
+		return opts, errors.New("invalid value for query param `search_mode` provided, it must be one of " + strings.Join(database.SearchModes, ", "))
+	}
+	opts.Sort = query.Get("sort")
+	if opts.Sort != "" && !slices.Contains(database.AdSorts, opts.Sort) {
+		return opts, errors.New("invalid value for query param `sort` provided, it must be one of " + strings.Join(database.AdSorts, ", "))
+	}
+	if opts.Sort == "relevance" && opts.Search == "" {
+		return opts, errors.New("query param `sort` can only be relevance when searching")
+	}
+	if opts.CreatedFrom, err = timeParam(query, "created_from"); err != nil {
+		return opts, err
+	}
+	if opts.CreatedTo, err = timeParam(query, "created_to"); err != nil {
+		return opts, err
+	}
+	if value := query.Get("target_domain"); value != "" {
+		opts.TargetDomain = strings.ToLower(value)
+		if !domainPattern.MatchString(opts.TargetDomain) {
+			return opts, errors.New("invalid value for query param `target_domain` provided, it must be a host name like example.com")
+		}
+	}
+	if value := query.Get("status"); value != "" {
+		opts.Statuses = strings.Split(value, ",")
+		for _, status := range opts.Statuses {
+			if !slices.Contains(database.AdStatuses, status) {
+				return opts, errors.New("invalid value for query param `status` provided, it must be a comma separated list of " + strings.Join(database.AdStatuses, ", "))
+			}
+		}
+	}
+	opts.Campaign = query.Get("campaign")
+	if value := query.Get("include"); value != "" {
+		for _, include := range strings.Split(value, ",") {
+			if include != "analytics" {
+				return opts, errors.New("invalid value for query param `include` provided, only analytics can be included")
+			}
+			opts.IncludeAnalytics = true
+		}
+	}
+
+	if opts.PaginationOptions, err = apihelpers.Pagination(r); err != nil {
+		return opts, err
+	}
+	opts.Default()
+	if opts.After != nil && opts.After.Sort != opts.CursorSort() {
+		return opts, errors.New("query param `cursor` was issued for another sort order")
+	}
+	return opts, nil
+}
+
+// ListAds returns all ads
+func (h *Handler) ListAds(w http.ResponseWriter, r *http.Request) {
+	opts, err := listAdOptions(r)
 	if err != nil {
-		logger.RequestLogger.Error(r, "Error in pagination parameters: %v", err)
+		logger.RequestLogger.Error(r, "Error in list parameters: %v", err)
 		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, err.Error())
 		return
 	}
-	opts.PaginationOptions = pageOpts
-	opts.Default()
 
-	ads, err := h.DB.ListAds(opts)
+	ads, err := h.DB.ListAds(r.Context(), opts)
 	if err != nil {
 		logger.RequestLogger.Error(r, "Error retrieving ads: %v", err)
 		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving ads")
 		return
 	}
-	totalCount, err := h.DB.CountAds(opts)
-	if err != nil {
-		logger.RequestLogger.Error(r, "Error retrieving ads count: %v", err)
-		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving ads count")
-		return
+	result := AdList{}
+	if opts.Keyset {
+		nextCursor := apihelpers.NextCursor(opts.PaginationOptions, ads, func(ad models.ListedAd) apihelpers.Cursor {
+			return database.AdCursor(ad, opts)
+		})
+		result.NextCursor = &nextCursor
 	}
-
-	result := map[string]any{
-		"values": ads,
-		"pages":  getPaginationObject(len(*ads), totalCount),
+	if !opts.IncludeAnalytics {
+		// joined only to sort by them
+		for i := range *ads {
+			(*ads)[i].Analytics = nil
+		}
+	}
+	result.Values = *ads
+
+	if opts.WithTotal {
+		totalCount, err := h.DB.CountAds(r.Context(), opts)
+		if err != nil {
+			logger.RequestLogger.Error(r, "Error retrieving ads count: %v", err)
+			apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving ads count")
+			return
+		}
+		result.Total = &totalCount
+		if !opts.Keyset {
+			result.Pages = opts.Pages(len(*ads), totalCount)
+		}
 	}
 	apihelpers.SuccessResponse(r, w, http.StatusOK, result, "")
 }
@@ -110,13 +282,16 @@ func (h *Handler) LogClick(w http.ResponseWriter, r *http.Request) {
 	var click models.Click
 	if err := json.NewDecoder(r.Body).Decode(&click); err != nil {
 		logger.RequestLogger.Error(r, "Error decoding request body: %v", err)
+		monitoring.IncrementClicks("invalid")
 		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 	defer r.Body.Close()
 
+	logger.SetAdID(r, click.AdID)
 	if uuid.Validate(click.AdID) != nil {
 		logger.RequestLogger.Error(r, "Invalid ad ID: %v", click.AdID)
+		monitoring.IncrementClicks("invalid")
 		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid ad ID")
 		return
 	}
@@ -132,17 +307,22 @@ func (h *Handler) LogClick(w http.ResponseWriter, r *http.Request) {
 		click.IPAddress = r.RemoteAddr
 	}
 
-	if err := h.DB.LogClick(&click); err != nil {
+	if err := h.DB.LogClick(r.Context(), &click); err != nil {
 		if err == database.ErrNotFound {
 			logger.RequestLogger.Error(r, "Ad not found")
+			monitoring.IncrementClicks("not_found")
 			apihelpers.ErrorResponse(r, w, http.StatusNotFound, "Ad not found")
 		} else {
 			logger.RequestLogger.Error(r, "Error logging click: %v", err)
+			monitoring.IncrementClicks("error")
 			apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error logging click")
 		}
 		return
 	}
 
+	monitoring.IncrementClicks("logged")
+	// published once committed, so streams never show clicks which were rolled back
+	h.Clicks.Publish(click)
 	apihelpers.SuccessResponse(r, w, http.StatusCreated, click, "Click logged successfully")
 }
 
@@ -153,31 +333,45 @@ var durationMap = map[string]time.Duration{
 	"week":   time.Hour * 24 * 7,
 }
 
+// analyticsPeriod reads the period query param and returns it with the start of its range,
+// responding with the error when it isn't valid
+func analyticsPeriod(w http.ResponseWriter, r *http.Request) (string, time.Time, bool) {
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "hour"
+	} else if !slices.Contains([]string{"minute", "hour", "day", "week", "month"}, period) {
+		logger.RequestLogger.Error(r, "Invalid period: %v", period)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid period")
+		return "", time.Time{}, false
+	}
+
+	return period, periodStart(period), true
+}
+
+// periodStart returns the start of the range of a period ending now
+func periodStart(period string) time.Time {
+	if period == "month" {
+		return time.Now().AddDate(0, -1, 0)
+	}
+	return time.Now().Add(-durationMap[period])
+}
+
 // GetAdAnalytics retrieves analytics for an ad
 func (h *Handler) GetAdAnalytics(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
+	logger.SetAdID(r, id)
 	if uuid.Validate(id) != nil {
 		logger.RequestLogger.Error(r, "Invalid ad ID: %v", id)
 		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid ad ID")
 		return
 	}
 
-	query := r.URL.Query()
-	period := query.Get("period")
-	if period == "" {
-		period = "hour"
-	} else if !slices.Contains([]string{"minute", "hour", "day", "week", "month"}, period) {
-		logger.RequestLogger.Error(r, "Invalid period: %v", period)
-		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid period")
+	period, startDate, ok := analyticsPeriod(w, r)
+	if !ok {
 		return
 	}
 
-	startDate := time.Now().AddDate(0, -1, 0) // for month period
-	if period != "month" {
-		startDate = time.Now().Add(-durationMap[period])
-	}
-
-	analytics, err := h.DB.GetAdAnalytics(id, startDate)
+	analytics, err := h.DB.GetAdAnalytics(r.Context(), id, startDate)
 	if err != nil {
 		if err == database.ErrNotFound {
 			logger.RequestLogger.Error(r, "Ad not found")
@@ -189,6 +383,12 @@ func (h *Handler) GetAdAnalytics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setAdAverages(analytics, period)
+	apihelpers.SuccessResponse(r, w, http.StatusOK, analytics, "")
+}
+
+// setAdAverages sets the period and the averages derived from the totals of an ad's analytics
+func setAdAverages(analytics *models.AdAnalyticsData, period string) {
 	analytics.Period = period
 	if analytics.TotalClicks > 0 {
 		analytics.AveragePlaybackTime = float64(analytics.TotalPlaybackTime) / float64(analytics.TotalClicks)
@@ -196,28 +396,16 @@ func (h *Handler) GetAdAnalytics(w http.ResponseWriter, r *http.Request) {
 	if analytics.TotalClicksInRange > 0 {
 		analytics.AveragePlaybackTimeInRange = float64(analytics.TotalPlaybackTimeInRange) / float64(analytics.TotalClicksInRange)
 	}
-
-	apihelpers.SuccessResponse(r, w, http.StatusOK, analytics, "")
 }
 
 // GetAdsAnalytics retrieves analytics for all ads
 func (h *Handler) GetAdsAnalytics(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query()
-	period := query.Get("period")
-	if period == "" {
-		period = "hour"
-	} else if !slices.Contains([]string{"minute", "hour", "day", "week", "month"}, period) {
-		logger.RequestLogger.Error(r, "Invalid period: %v", period)
-		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid period")
+	period, startDate, ok := analyticsPeriod(w, r)
+	if !ok {
 		return
 	}
 
-	startDate := time.Now().AddDate(0, -1, 0) // for month period
-	if period != "month" {
-		startDate = time.Now().Add(-durationMap[period])
-	}
-
-	analytics, err := h.DB.GetAdsAnalytics(startDate)
+	analytics, err := h.DB.GetAdsAnalytics(r.Context(), startDate)
 	if err != nil {
 		logger.RequestLogger.Error(r, "Error retrieving analytics: %v", err)
 		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving analytics")
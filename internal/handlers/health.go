@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/health"
+	"github.com/JalajGoswami/video-ad-metrics/internal/jobs"
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+)
+
+// HealthHandler contains the dependencies needed for the health HTTP handlers
+type HealthHandler struct {
+	DB      database.Repository
+	Checker *health.Checker
+	Elector *jobs.LeaderElector
+}
+
+// NewHealthHandler creates a new HealthHandler
+func NewHealthHandler(db database.Repository, checker *health.Checker, elector *jobs.LeaderElector) *HealthHandler {
+	return &HealthHandler{
+		DB:      db,
+		Checker: checker,
+		Elector: elector,
+	}
+}
+
+// HealthStatus is the result of /health
+type HealthStatus struct {
+	Status   string        `json:"status"`
+	Instance string        `json:"instance"`  // this replica
+	IsLeader bool          `json:"is_leader"` // whether this replica runs scheduled jobs
+	Leader   *models.Lease `json:"leader"`
+}
+
+// LiveStatus is the result of /livez
+type LiveStatus struct {
+	Status string `json:"status"`
+}
+
+// Health reports whether the database answers and which replica leads background jobs
+func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
+	if err := h.DB.Ping(r.Context()); err != nil {
+		logger.RequestLogger.Error(r, "Database connection failed: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Database connection failed")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, HealthStatus{
+		Status:   "ok",
+		Instance: h.Elector.Instance(),
+		IsLeader: h.Elector.IsLeader(),
+		Leader:   h.Elector.Leader(),
+	}, "")
+}
+
+// Live reports that the process is up and serving, it checks no dependency
+// so a database outage doesn't get every replica restarted
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	apihelpers.SuccessResponse(r, w, http.StatusOK, LiveStatus{Status: health.StatusPass}, "")
+}
+
+// Ready reports whether the replica should receive traffic, with the result of every check
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	ready, report := h.Checker.Ready(r.Context())
+	if !ready {
+		apihelpers.ErrorResponseWithResult(r, w, http.StatusServiceUnavailable, "Not ready", report)
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, report, "")
+}
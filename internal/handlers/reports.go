@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/jobs"
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/google/uuid"
+)
+
+// ReportHandler contains the dependencies needed for the report HTTP handlers
+type ReportHandler struct {
+	DB       database.Repository
+	Reporter *jobs.Reporter
+}
+
+// NewReportHandler creates a new ReportHandler
+func NewReportHandler(db database.Repository, reporter *jobs.Reporter) *ReportHandler {
+	return &ReportHandler{
+		DB:       db,
+		Reporter: reporter,
+	}
+}
+
+// ReportList is a page of reports
+type ReportList struct {
+	Values []models.GeneratedReport `json:"values"`
+	// the cursor of the next page, empty on the last page
+	NextCursor string `json:"next_cursor"`
+}
+
+// CreateSpec stores a report spec, its first report is generated at the next run of its schedule
+func (h *ReportHandler) CreateSpec(w http.ResponseWriter, r *http.Request) {
+	var spec models.ReportSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		logger.RequestLogger.Error(r, "Error decoding request body: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := h.Reporter.Validate(&spec); err != nil {
+		logger.RequestLogger.Error(r, "Invalid report spec: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, err.Error())
+		return
+	}
+	spec.ID = uuid.New().String()
+	spec.CreatedAt = time.Now()
+	spec.LastRunAt = nil
+	var err error
+	if spec.NextRunAt, err = jobs.NextRun(spec, spec.CreatedAt); err != nil {
+		logger.RequestLogger.Error(r, "Error scheduling report spec: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.DB.CreateReportSpec(r.Context(), &spec); err != nil {
+		logger.RequestLogger.Error(r, "Error creating report spec: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error creating report spec")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusCreated, spec, "Report spec created successfully")
+}
+
+// ListSpecs returns every report spec by name
+func (h *ReportHandler) ListSpecs(w http.ResponseWriter, r *http.Request) {
+	specs, err := h.DB.ListReportSpecs(r.Context())
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error retrieving report specs: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving report specs")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, specs, "")
+}
+
+// GetSpec retrieves a report spec by ID
+func (h *ReportHandler) GetSpec(w http.ResponseWriter, r *http.Request) {
+	spec, ok := h.spec(w, r)
+	if !ok {
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, spec, "")
+}
+
+// DeleteSpec deletes a report spec with its reports
+func (h *ReportHandler) DeleteSpec(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if uuid.Validate(id) != nil {
+		logger.RequestLogger.Error(r, "Invalid report spec ID: %v", id)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid report spec ID")
+		return
+	}
+	if err := h.DB.DeleteReportSpec(r.Context(), id); err != nil {
+		if err == database.ErrNotFound {
+			logger.RequestLogger.Error(r, "Report spec not found")
+			apihelpers.ErrorResponse(r, w, http.StatusNotFound, "Report spec not found")
+		} else {
+			logger.RequestLogger.Error(r, "Error deleting report spec: %v", err)
+			apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error deleting report spec")
+		}
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, nil, "Report spec deleted successfully")
+}
+
+// RunSpec generates and delivers the report of a spec for its last complete period right away,
+// its schedule is left unchanged
+func (h *ReportHandler) RunSpec(w http.ResponseWriter, r *http.Request) {
+	spec, ok := h.spec(w, r)
+	if !ok {
+		return
+	}
+	report, err := h.Reporter.Generate(r.Context(), *spec, time.Now())
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error generating report: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error generating report")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusCreated, report, "Report generated successfully")
+}
+
+// spec reads the report spec of the id path param, responding with the error when it can't
+func (h *ReportHandler) spec(w http.ResponseWriter, r *http.Request) (*models.ReportSpec, bool) {
+	id := r.PathValue("id")
+	if uuid.Validate(id) != nil {
+		logger.RequestLogger.Error(r, "Invalid report spec ID: %v", id)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid report spec ID")
+		return nil, false
+	}
+	spec, err := h.DB.GetReportSpec(r.Context(), id)
+	if err != nil {
+		if err == database.ErrNotFound {
+			logger.RequestLogger.Error(r, "Report spec not found")
+			apihelpers.ErrorResponse(r, w, http.StatusNotFound, "Report spec not found")
+		} else {
+			logger.RequestLogger.Error(r, "Error retrieving report spec: %v", err)
+			apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving report spec")
+		}
+		return nil, false
+	}
+	return spec, true
+}
+
+// ListReports returns the generated reports, most recent first
+func (h *ReportHandler) ListReports(w http.ResponseWriter, r *http.Request) {
+	opts, err := listReportOptions(r)
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error in list parameters: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	reports, err := h.DB.ListReports(r.Context(), opts)
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error retrieving reports: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving reports")
+		return
+	}
+	result := ReportList{}
+	result.NextCursor = apihelpers.NextCursor(opts.PaginationOptions, reports, database.ReportCursor)
+	result.Values = *reports
+	apihelpers.SuccessResponse(r, w, http.StatusOK, result, "")
+}
+
+// listReportOptions reads the spec and pagination query params of ListReports
+func listReportOptions(r *http.Request) (database.ListReportOptions, error) {
+	opts := database.ListReportOptions{SpecID: r.URL.Query().Get("spec_id")}
+	if opts.SpecID != "" && uuid.Validate(opts.SpecID) != nil {
+		return opts, errors.New("invalid value for query param `spec_id` provided")
+	}
+	var err error
+	if opts.PaginationOptions, err = apihelpers.CursorPagination(r); err != nil {
+		return opts, err
+	}
+	opts.Default()
+	if opts.After != nil && opts.After.Sort != opts.CursorSort() {
+		return opts, errors.New("query param `cursor` was issued for another list")
+	}
+	return opts, nil
+}
+
+// GetReport downloads the file of a report
+func (h *ReportHandler) GetReport(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if uuid.Validate(id) != nil {
+		logger.RequestLogger.Error(r, "Invalid report ID: %v", id)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid report ID")
+		return
+	}
+	report, err := h.DB.GetReport(r.Context(), id)
+	if err != nil {
+		if err == database.ErrNotFound {
+			logger.RequestLogger.Error(r, "Report not found")
+			apihelpers.ErrorResponse(r, w, http.StatusNotFound, "Report not found")
+		} else {
+			logger.RequestLogger.Error(r, "Error retrieving report: %v", err)
+			apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving report")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", report.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(report.Content)))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": report.Filename}))
+	w.WriteHeader(http.StatusOK)
+	w.Write(report.Content)
+}
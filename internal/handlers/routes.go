@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"net/http"
+
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/health"
+	"github.com/JalajGoswami/video-ad-metrics/internal/jobs"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/JalajGoswami/video-ad-metrics/internal/openapi"
+)
+
+// APIInfo describes the API in its OpenAPI spec
+var APIInfo = openapi.Info{
+	Title:       "Video Ad Metrics API",
+	Description: "Ad management, click tracking and ad analytics. Every JSON response is wrapped in an envelope carrying the trace_id of the request.",
+	Version:     "1.0.0",
+}
+
+var (
+	adID   = openapi.Path("id", "ID of the ad", &openapi.Schema{Type: "string", Format: "uuid"})
+	period = openapi.Query("period", "Range of the in_range analytics, counted back from now",
+		&openapi.Schema{Type: "string", Enum: []any{"minute", "hour", "day", "week", "month"}, Default: "hour"})
+	jobName = openapi.Path("name", "Name of the job, e.g. archive-clicks", &openapi.Schema{Type: "string"})
+	specID  = openapi.Path("id", "ID of the report spec", &openapi.Schema{Type: "string", Format: "uuid"})
+	hookID  = openapi.Path("id", "ID of the webhook", &openapi.Schema{Type: "string", Format: "uuid"})
+)
+
+func number(f float64) *float64 {
+	return &f
+}
+
+func required(param openapi.Parameter) openapi.Parameter {
+	param.Required = true
+	return param
+}
+
+func enum(values []string) []any {
+	result := make([]any, len(values))
+	for i, value := range values {
+		result[i] = value
+	}
+	return result
+}
+
+// Routes lists every route of the API. The handlers may be nil when the routes are only
+// used for the spec (see cmd/openapi), method values on nil receivers are never called.
+func Routes(h *Handler, healthHandler *HealthHandler, admin *AdminHandler, reports *ReportHandler, webhooks *WebhookHandler, streams *StreamHandler, metrics http.Handler) []openapi.Route {
+	routes := []openapi.Route{
+		// Health routes
+		{
+			Method: "GET", Path: "/health", Name: "getHealth", Tags: []string{"Health"},
+			Summary: "Whether the database answers and which replica leads background jobs",
+			Result:  HealthStatus{}, Errors: []int{http.StatusInternalServerError},
+			Handler: http.HandlerFunc(healthHandler.Health),
+		},
+		{
+			Method: "GET", Path: "/livez", Name: "getLiveness", Tags: []string{"Health"},
+			Summary: "Always succeeds while the process serves requests",
+			Result:  LiveStatus{},
+			Handler: http.HandlerFunc(healthHandler.Live),
+		},
+		{
+			Method: "GET", Path: "/readyz", Name: "getReadiness", Tags: []string{"Health"},
+			Summary: "Whether the replica should receive traffic, with the result of every check",
+			Result:  health.Report{}, Errors: []int{http.StatusServiceUnavailable}, ErrorResult: health.Report{},
+			Handler: http.HandlerFunc(healthHandler.Ready),
+		},
+		{
+			Method: "GET", Path: "/metrics", Name: "getMetrics", Tags: []string{"Health"},
+			Summary:  "Prometheus metrics",
+			Produces: []string{"text/plain"},
+			Handler:  metrics,
+		},
+
+		// Ad management routes
+		{
+			Method: "GET", Path: "/ads", Name: "listAds", Tags: []string{"Ads"},
+			Summary: "List ads with filters, most recent first by default",
+			Params: []openapi.Parameter{
+				openapi.Query("page", "Page number (page mode)", &openapi.Schema{Type: "integer", Minimum: number(1), Default: 1}),
+				openapi.Query("cursor", "Selects cursor mode: empty for the first page, then the next_cursor of the previous page. Can't be used with page", &openapi.Schema{Type: "string"}),
+				openapi.Query("include_total", "Also return the total number of ads in cursor mode", &openapi.Schema{Type: "boolean", Default: false}),
+				openapi.Query("rows", "Page size", &openapi.Schema{Type: "integer", Minimum: number(1), Maximum: number(apihelpers.MaxPageSize), Default: apihelpers.DefaultPageSize}),
+				openapi.Query("sort", "Sort column, ties are broken by id. The stats sorts use the all time analytics. Defaults to relevance when searching and to created_at otherwise", &openapi.Schema{Type: "string", Enum: enum(database.AdSorts)}),
+				openapi.Query("order", "Sort order", &openapi.Schema{Type: "string", Enum: []any{"asc", "desc"}, Default: "desc"}),
+				openapi.Query("search", "Search the name and description, every word matching as a prefix in fulltext mode. Each listed ad gets a search object with its rank and a highlighted snippet", &openapi.Schema{Type: "string"}),
+				openapi.Query("search_mode", "fulltext matches stemmed words, trigram tolerates typos and matches any substring", &openapi.Schema{Type: "string", Enum: enum(database.SearchModes), Default: "fulltext"}),
+				openapi.Query("created_from", "Only ads created at or after this time", &openapi.Schema{Type: "string", Format: "date-time"}),
+				openapi.Query("created_to", "Only ads created before this time", &openapi.Schema{Type: "string", Format: "date-time"}),
+				openapi.Query("target_domain", "Only ads whose target url is on this host or its subdomains, e.g. example.com", &openapi.Schema{Type: "string"}),
+				openapi.Query("status", "Only ads with one of these statuses, comma separated, e.g. active,paused", &openapi.Schema{Type: "string"}),
+				openapi.Query("campaign", "Only the ads of this campaign", &openapi.Schema{Type: "string"}),
+				openapi.Query("include", "Embed the all time analytics of every ad", &openapi.Schema{Type: "string", Enum: []any{"analytics"}}),
+			},
+			Result: AdList{}, Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(h.ListAds),
+		},
+		{
+			Method: "POST", Path: "/ads", Name: "createAd", Tags: []string{"Ads"},
+			Summary: "Create an ad",
+			Body:    models.Ad{}, Status: http.StatusCreated,
+			Result: models.Ad{}, Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(h.CreateAd),
+		},
+		{
+			Method: "GET", Path: "/ads/{id}", Name: "getAd", Tags: []string{"Ads"},
+			Summary: "Get an ad by ID",
+			Params:  []openapi.Parameter{adID},
+			Result:  models.Ad{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(h.GetAd),
+		},
+		{
+			Method: "PATCH", Path: "/ads/{id}", Name: "updateAd", Tags: []string{"Ads"},
+			Summary: "Change the status or campaign of an ad",
+			Params:  []openapi.Parameter{adID}, Body: models.AdUpdate{},
+			Result: models.Ad{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(h.UpdateAd),
+		},
+
+		// Tracking routes
+		{
+			Method: "POST", Path: "/ads/clicks", Name: "logClick", Tags: []string{"Clicks"},
+			Summary: "Track a click on an ad, timestamp defaults to now minus the playback time and ip_address to the caller's",
+			Body:    models.Click{}, Status: http.StatusCreated,
+			Result: models.Click{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(h.LogClick),
+		},
+		{
+			Method: "GET", Path: "/admin/clicks", Name: "listClicks", Tags: []string{"Clicks"}, Auth: true,
+			Summary: "Raw clicks by timestamp, archived ones included, most recent first by default",
+			Params: []openapi.Parameter{
+				openapi.Query("ad_id", "Only the clicks of this ad", &openapi.Schema{Type: "string", Format: "uuid"}),
+				openapi.Query("from", "Only clicks at or after this time", &openapi.Schema{Type: "string", Format: "date-time"}),
+				openapi.Query("to", "Only clicks before this time", &openapi.Schema{Type: "string", Format: "date-time"}),
+				openapi.Query("order", "Order by timestamp", &openapi.Schema{Type: "string", Enum: []any{"asc", "desc"}, Default: "desc"}),
+				openapi.Query("cursor", "The next_cursor of the previous page, left out for the first page", &openapi.Schema{Type: "string"}),
+				openapi.Query("include_total", "Also return the total number of clicks", &openapi.Schema{Type: "boolean", Default: false}),
+				openapi.Query("rows", "Page size", &openapi.Schema{Type: "integer", Minimum: number(1), Maximum: number(apihelpers.MaxPageSize), Default: apihelpers.DefaultPageSize}),
+			},
+			Result: ClickList{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(h.ListClicks),
+		},
+		{
+			Method: "GET", Path: "/admin/clicks/export", Name: "exportClicks", Tags: []string{"Clicks"}, Auth: true,
+			Summary: "Stream the raw clicks of a time range by timestamp, archived ones included. An export cut short by an error ends without terminating the response",
+			Params: []openapi.Parameter{
+				required(openapi.Query("format", "Export format", &openapi.Schema{Type: "string", Enum: []any{"csv", "ndjson", "parquet"}})),
+				required(openapi.Query("from", "Clicks at or after this time", &openapi.Schema{Type: "string", Format: "date-time"})),
+				required(openapi.Query("to", "Clicks before this time", &openapi.Schema{Type: "string", Format: "date-time"})),
+				openapi.Query("ad_id", "Only the clicks of this ad", &openapi.Schema{Type: "string", Format: "uuid"}),
+			},
+			Produces: []string{"text/csv", "application/x-ndjson", "application/vnd.apache.parquet"},
+			Errors:   []int{http.StatusBadRequest, http.StatusInternalServerError},
+			Handler:  http.HandlerFunc(h.ExportClicks),
+		},
+		{
+			Method: "GET", Path: "/admin/clicks/tail", Name: "tailClicks", Tags: []string{"Clicks"}, Auth: true,
+			Summary: "WebSocket sending the clicks logged on the serving replica as JSON TailMessage text messages, once committed. Clicks are dropped for clients which don't read them in time",
+			Params: []openapi.Parameter{
+				openapi.Query("ad_id", "Only the clicks of this ad", &openapi.Schema{Type: "string", Format: "uuid"}),
+				openapi.Query("ip_prefix", "Only the clicks whose ip_address starts with this prefix, e.g. 10.1., or is in this CIDR, e.g. 10.0.0.0/8", &openapi.Schema{Type: "string"}),
+				openapi.Query("min_playback_time", "Only the clicks played for at least this many seconds", &openapi.Schema{Type: "integer", Minimum: number(0)}),
+			},
+			Status:  http.StatusSwitchingProtocols,
+			Errors:  []int{http.StatusBadRequest, http.StatusUpgradeRequired, http.StatusServiceUnavailable},
+			Handler: http.HandlerFunc(streams.TailClicks),
+		},
+
+		// Analytics routes
+		{
+			Method: "GET", Path: "/ads/analytics", Name: "getAdsAnalytics", Tags: []string{"Analytics"},
+			Summary: "Click and playback analytics of all ads",
+			Params:  []openapi.Parameter{period},
+			Result:  models.AnalyticsData{}, Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(h.GetAdsAnalytics),
+		},
+		{
+			Method: "GET", Path: "/ads/analytics/{id}", Name: "getAdAnalytics", Tags: []string{"Analytics"},
+			Summary: "Click and playback analytics of an ad",
+			Params:  []openapi.Parameter{adID, period},
+			Result:  models.AdAnalyticsData{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(h.GetAdAnalytics),
+		},
+		{
+			Method: "GET", Path: "/ads/analytics/{id}/stream", Name: "streamAdAnalytics", Tags: []string{"Analytics"},
+			Summary: "Server-Sent Events with the analytics of an ad, then every click logged on the serving replica. Resuming with Last-Event-ID replays the missed clicks while they are buffered",
+			Params: []openapi.Parameter{
+				adID, period,
+				openapi.Header("Last-Event-ID", "ID of the last event received, sent by EventSource when reconnecting", &openapi.Schema{Type: "string"}),
+			},
+			Produces: []string{"text/event-stream"},
+			Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError, http.StatusServiceUnavailable},
+			Handler:  http.HandlerFunc(streams.StreamAdAnalytics),
+		},
+
+		// Report routes
+		{
+			Method: "POST", Path: "/reports/specs", Name: "createReportSpec", Tags: []string{"Reports"}, Auth: true,
+			Summary: "Define a report generated on a schedule over the clicks of the previous day, week or month (UTC)",
+			Body:    models.ReportSpec{}, Status: http.StatusCreated,
+			Result: models.ReportSpec{}, Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(reports.CreateSpec),
+		},
+		{
+			Method: "GET", Path: "/reports/specs", Name: "listReportSpecs", Tags: []string{"Reports"}, Auth: true,
+			Summary: "Report specs by name",
+			Result:  []models.ReportSpec{}, Errors: []int{http.StatusInternalServerError},
+			Handler: http.HandlerFunc(reports.ListSpecs),
+		},
+		{
+			Method: "GET", Path: "/reports/specs/{id}", Name: "getReportSpec", Tags: []string{"Reports"}, Auth: true,
+			Summary: "Get a report spec by ID",
+			Params:  []openapi.Parameter{specID},
+			Result:  models.ReportSpec{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(reports.GetSpec),
+		},
+		{
+			Method: "DELETE", Path: "/reports/specs/{id}", Name: "deleteReportSpec", Tags: []string{"Reports"}, Auth: true,
+			Summary: "Delete a report spec with its reports",
+			Params:  []openapi.Parameter{specID},
+			Errors:  []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(reports.DeleteSpec),
+		},
+		{
+			Method: "POST", Path: "/reports/specs/{id}/run", Name: "runReportSpec", Tags: []string{"Reports"}, Auth: true,
+			Summary: "Generate and deliver the report of the last complete period right away, the schedule is unchanged",
+			Params:  []openapi.Parameter{specID}, Status: http.StatusCreated,
+			Result: models.GeneratedReport{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(reports.RunSpec),
+		},
+		{
+			Method: "GET", Path: "/reports", Name: "listReports", Tags: []string{"Reports"}, Auth: true,
+			Summary: "Generated reports, most recent first",
+			Params: []openapi.Parameter{
+				openapi.Query("spec_id", "Only the reports of this spec", &openapi.Schema{Type: "string", Format: "uuid"}),
+				openapi.Query("cursor", "The next_cursor of the previous page, left out for the first page", &openapi.Schema{Type: "string"}),
+				openapi.Query("rows", "Page size", &openapi.Schema{Type: "integer", Minimum: number(1), Maximum: number(apihelpers.MaxPageSize), Default: apihelpers.DefaultPageSize}),
+			},
+			Result: ReportList{}, Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(reports.ListReports),
+		},
+		{
+			Method: "GET", Path: "/reports/{id}", Name: "getReport", Tags: []string{"Reports"}, Auth: true,
+			Summary: "Download the file of a report",
+			Params: []openapi.Parameter{
+				openapi.Path("id", "ID of the report", &openapi.Schema{Type: "string", Format: "uuid"}),
+			},
+			Produces: []string{"text/csv", "text/html", "application/json"},
+			Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+			Handler:  http.HandlerFunc(reports.GetReport),
+		},
+
+		// Webhook routes
+		{
+			Method: "POST", Path: "/webhooks", Name: "createWebhook", Tags: []string{"Webhooks"}, Auth: true,
+			Summary: "Register an endpoint notified with signed JSON payloads, the secret is generated when left out and only returned here",
+			Body:    models.Webhook{}, Status: http.StatusCreated,
+			Result: models.Webhook{}, Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(webhooks.CreateWebhook),
+		},
+		{
+			Method: "GET", Path: "/webhooks", Name: "listWebhooks", Tags: []string{"Webhooks"}, Auth: true,
+			Summary: "Webhooks without their secret, oldest first",
+			Result:  []models.Webhook{}, Errors: []int{http.StatusInternalServerError},
+			Handler: http.HandlerFunc(webhooks.ListWebhooks),
+		},
+		{
+			Method: "DELETE", Path: "/webhooks/{id}", Name: "deleteWebhook", Tags: []string{"Webhooks"}, Auth: true,
+			Summary: "Delete a webhook with its rules and deliveries",
+			Params:  []openapi.Parameter{hookID},
+			Errors:  []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(webhooks.DeleteWebhook),
+		},
+		{
+			Method: "POST", Path: "/webhooks/{id}/rules", Name: "createWebhookRule", Tags: []string{"Webhooks"}, Auth: true,
+			Summary: "Notify the webhook once when a metric of an ad's analytics goes above or below a threshold, and again only after it went back",
+			Params:  []openapi.Parameter{hookID},
+			Body:    models.WebhookRule{}, Status: http.StatusCreated,
+			Result: models.WebhookRule{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(webhooks.CreateRule),
+		},
+		{
+			Method: "GET", Path: "/webhooks/{id}/rules", Name: "listWebhookRules", Tags: []string{"Webhooks"}, Auth: true,
+			Summary: "Rules of a webhook, oldest first",
+			Params:  []openapi.Parameter{hookID},
+			Result:  []models.WebhookRule{}, Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(webhooks.ListRules),
+		},
+		{
+			Method: "DELETE", Path: "/webhooks/{id}/rules/{rule_id}", Name: "deleteWebhookRule", Tags: []string{"Webhooks"}, Auth: true,
+			Summary: "Delete a rule of a webhook, its deliveries are kept",
+			Params: []openapi.Parameter{
+				hookID,
+				openapi.Path("rule_id", "ID of the rule", &openapi.Schema{Type: "string", Format: "uuid"}),
+			},
+			Errors:  []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(webhooks.DeleteRule),
+		},
+		{
+			Method: "GET", Path: "/webhooks/{id}/deliveries", Name: "listWebhookDeliveries", Tags: []string{"Webhooks"}, Auth: true,
+			Summary: "Deliveries of a webhook with the outcome of their last attempt, most recent first",
+			Params: []openapi.Parameter{
+				hookID,
+				openapi.Query("cursor", "The next_cursor of the previous page, left out for the first page", &openapi.Schema{Type: "string"}),
+				openapi.Query("rows", "Page size", &openapi.Schema{Type: "integer", Minimum: number(1), Maximum: number(apihelpers.MaxPageSize), Default: apihelpers.DefaultPageSize}),
+			},
+			Result: WebhookDeliveryList{}, Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(webhooks.ListDeliveries),
+		},
+
+		// Admin routes
+		{
+			Method: "GET", Path: "/admin/config", Name: "getConfig", Tags: []string{"Admin"}, Auth: true,
+			Summary: "Effective config with secrets redacted, shaped like config.example.yaml",
+			Result:  map[string]any{}, Errors: []int{http.StatusInternalServerError},
+			Handler: http.HandlerFunc(admin.GetConfig),
+		},
+		{
+			Method: "GET", Path: "/admin/jobs", Name: "listJobs", Tags: []string{"Admin"}, Auth: true,
+			Summary: "Background jobs with their state and last run",
+			Result:  []jobs.Status{}, Errors: []int{http.StatusInternalServerError},
+			Handler: http.HandlerFunc(admin.ListJobs),
+		},
+		{
+			Method: "GET", Path: "/admin/jobs/{name}/runs", Name: "listJobRuns", Tags: []string{"Admin"}, Auth: true,
+			Summary: "Most recent runs of a job, most recent first",
+			Params: []openapi.Parameter{
+				jobName,
+				openapi.Query("limit", "Number of runs", &openapi.Schema{Type: "integer", Minimum: number(1), Maximum: number(100), Default: 20}),
+			},
+			Result: []models.JobRun{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(admin.ListJobRuns),
+		},
+		{
+			Method: "POST", Path: "/admin/jobs/{name}/trigger", Name: "triggerJob", Tags: []string{"Admin"}, Auth: true,
+			Summary: "Run a job right away, even if paused",
+			Params:  []openapi.Parameter{jobName}, Status: http.StatusAccepted,
+			Errors:  []int{http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError, http.StatusServiceUnavailable},
+			Handler: http.HandlerFunc(admin.TriggerJob),
+		},
+		{
+			Method: "POST", Path: "/admin/jobs/{name}/pause", Name: "pauseJob", Tags: []string{"Admin"}, Auth: true,
+			Summary: "Stop scheduled runs of a job on every replica",
+			Params:  []openapi.Parameter{jobName},
+			Errors:  []int{http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(admin.PauseJob),
+		},
+		{
+			Method: "POST", Path: "/admin/jobs/{name}/resume", Name: "resumeJob", Tags: []string{"Admin"}, Auth: true,
+			Summary: "Re-enable scheduled runs of a paused job",
+			Params:  []openapi.Parameter{jobName},
+			Errors:  []int{http.StatusNotFound, http.StatusInternalServerError},
+			Handler: http.HandlerFunc(admin.ResumeJob),
+		},
+	}
+
+	// the spec describes itself as well
+	routes = append(routes, openapi.Route{
+		Method: "GET", Path: "/openapi.json", Name: "getOpenAPI", Tags: []string{"Health"},
+		Summary:  "This OpenAPI spec",
+		Produces: []string{"application/json"},
+		Handler:  openapi.Handler(APIInfo, &routes),
+	})
+	return routes
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/openapi"
+)
+
+// docs/openapi.json is generated by go run ./cmd/openapi, it has to change with the routes and models
+func TestOpenAPISpecUpToDate(t *testing.T) {
+	spec, err := json.MarshalIndent(openapi.Spec(APIInfo, Routes(nil, nil, nil, nil, nil, nil, nil)), "", "  ")
+	if err != nil {
+		t.Fatalf("encoding spec: %v", err)
+	}
+	spec = append(spec, '\n')
+
+	current, err := os.ReadFile("../../docs/openapi.json")
+	if err != nil {
+		t.Fatalf("reading docs/openapi.json: %v", err)
+	}
+	if !bytes.Equal(current, spec) {
+		t.Fatal("docs/openapi.json is out of date, a route or model changed: run go run ./cmd/openapi and review the diff")
+	}
+}
@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/JalajGoswami/video-ad-metrics/internal/stream"
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+)
+
+// Kinds of the subscriptions of the streams to the hub, each has its own subscriber limit
+const (
+	AnalyticsStream = "analytics"
+	TailStream      = "tail"
+)
+
+// StreamOptions configures the live streams
+type StreamOptions struct {
+	// analytics stream clients served at once, 0 for no limit
+	MaxSubscribers int
+	// click tail clients served at once, 0 for no limit
+	TailMaxSubscribers int
+	// interval of the heartbeat comments and pings
+	Heartbeat time.Duration
+	// interval at which analytics streams read the analytics of their ad again
+	AnalyticsInterval time.Duration
+}
+
+// Default fills in the unset intervals
+func (o *StreamOptions) Default() {
+	if o.Heartbeat <= 0 {
+		o.Heartbeat = 15 * time.Second
+	}
+	if o.AnalyticsInterval <= 0 {
+		o.AnalyticsInterval = 10 * time.Second
+	}
+}
+
+// StreamHandler serves the live streams fed by the clicks logged on this replica
+type StreamHandler struct {
+	DB  database.Repository
+	Hub *stream.Hub
+
+	mu      sync.RWMutex
+	options StreamOptions
+}
+
+// NewStreamHandler creates a new StreamHandler
+func NewStreamHandler(db database.Repository, hub *stream.Hub, opts StreamOptions) *StreamHandler {
+	h := &StreamHandler{DB: db, Hub: hub}
+	h.SetOptions(opts)
+	return h
+}
+
+// SetOptions changes the subscriber limits, the current streams are kept, and the intervals
+// from the next heartbeat or analytics of every stream on
+func (h *StreamHandler) SetOptions(opts StreamOptions) {
+	opts.Default()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.options = opts
+	h.Hub.SetMaxSubscribers(AnalyticsStream, opts.MaxSubscribers)
+	h.Hub.SetMaxSubscribers(TailStream, opts.TailMaxSubscribers)
+}
+
+// Options returns the options of the streams
+func (h *StreamHandler) Options() StreamOptions {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.options
+}
+
+// StreamedClick is the data of the click events of an analytics stream
+type StreamedClick struct {
+	ID           string    `json:"id"`
+	AdID         string    `json:"ad_id"`
+	Timestamp    time.Time `json:"timestamp"`
+	PlaybackTime int       `json:"playback_time"` // in seconds
+}
+
+// StreamAdAnalytics sends the analytics of an ad as Server-Sent Events: an analytics event
+// with the totals when the stream starts, then a click event for every click logged on this
+// replica, and an analytics event whenever the analytics read again every AnalyticsInterval
+// changed, which also counts the clicks logged on other replicas. A client resuming with
+// Last-Event-ID gets the click events it missed instead of the first analytics event, unless
+// they are no longer buffered.
+func (h *StreamHandler) StreamAdAnalytics(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	logger.SetAdID(r, id)
+	if uuid.Validate(id) != nil {
+		logger.RequestLogger.Error(r, "Invalid ad ID: %v", id)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid ad ID")
+		return
+	}
+	period, startDate, ok := analyticsPeriod(w, r)
+	if !ok {
+		return
+	}
+
+	// subscribe before reading the totals so that no click is missed, a click logged
+	// meanwhile may be both in the totals and in a click event
+	sub, err := h.Hub.Subscribe(stream.SubscribeOptions{
+		Kind:        AnalyticsStream,
+		Filter:      func(click models.Click) bool { return click.AdID == id },
+		LastEventID: r.Header.Get("Last-Event-ID"),
+	})
+	if err != nil {
+		subscribeError(w, r, err)
+		return
+	}
+	defer sub.Close()
+
+	var analytics *models.AdAnalyticsData
+	if sub.Resumed {
+		_, err = h.DB.GetAd(r.Context(), id)
+	} else {
+		analytics, err = h.DB.GetAdAnalytics(r.Context(), id, startDate)
+	}
+	if err != nil {
+		if err == database.ErrNotFound {
+			logger.RequestLogger.Error(r, "Ad not found")
+			apihelpers.ErrorResponse(r, w, http.StatusNotFound, "Ad not found")
+		} else {
+			logger.RequestLogger.Error(r, "Error retrieving analytics: %v", err)
+			apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving analytics")
+		}
+		return
+	}
+
+	// streams outlast the server's write timeout
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logger.RequestLogger.Error(r, "Error clearing the write deadline: %v", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// keeps nginx from buffering the events
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// analytics events carry the ID of the last event sent before them, to resume from
+	lastID := sub.LastID
+	// last analytics sent, encoded, so that unchanged analytics are not sent again
+	var sent []byte
+	if analytics != nil {
+		setAdAverages(analytics, period)
+		sent, err = writeEvent(w, lastID, "analytics", analytics)
+	}
+	for _, event := range sub.Missed {
+		if err != nil {
+			break
+		}
+		_, err = writeEvent(w, event.ID, "click", streamedClick(event.Click))
+	}
+	if err == nil {
+		err = controller.Flush()
+	}
+
+	heartbeat := time.NewTicker(h.Options().Heartbeat)
+	defer heartbeat.Stop()
+	refresh := time.NewTicker(h.Options().AnalyticsInterval)
+	defer refresh.Stop()
+	for err == nil {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				// lagging behind or shutting down, the client reconnects and resumes from its last event
+				return
+			}
+			lastID = event.ID
+			_, err = writeEvent(w, event.ID, "click", streamedClick(event.Click))
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+			heartbeat.Reset(h.Options().Heartbeat)
+		case <-refresh.C:
+			sent, err = h.refreshAnalytics(w, r, id, period, lastID, sent)
+			refresh.Reset(h.Options().AnalyticsInterval)
+		}
+		if err == nil {
+			err = controller.Flush()
+		}
+	}
+	logger.RequestLogger.Error(r, "Error writing analytics stream: %v", err)
+}
+
+// refreshAnalytics reads the analytics of the ad again and sends them unless they are the
+// last analytics sent, it returns the analytics sent last. The stream goes on without
+// analytics when they can't be read, the next refresh may succeed.
+func (h *StreamHandler) refreshAnalytics(w http.ResponseWriter, r *http.Request, id, period, lastID string, sent []byte) ([]byte, error) {
+	analytics, err := h.DB.GetAdAnalytics(r.Context(), id, periodStart(period))
+	if err != nil {
+		if r.Context().Err() == nil {
+			logger.RequestLogger.Error(r, "Error refreshing analytics: %v", err)
+		}
+		return sent, nil
+	}
+	setAdAverages(analytics, period)
+	payload, err := json.Marshal(analytics)
+	if err != nil || bytes.Equal(payload, sent) {
+		return sent, err
+	}
+	return writeEvent(w, lastID, "analytics", analytics)
+}
+
+// subscribeError responds with the error of a subscription to the hub
+func subscribeError(w http.ResponseWriter, r *http.Request, err error) {
+	logger.RequestLogger.Error(r, "Error subscribing to clicks: %v", err)
+	message := "Too many streams, retry later"
+	if err == stream.ErrClosed {
+		message = "Server shutting down"
+	}
+	apihelpers.ErrorResponse(r, w, http.StatusServiceUnavailable, message)
+}
+
+func streamedClick(click models.Click) StreamedClick {
+	return StreamedClick{ID: click.ID, AdID: click.AdID, Timestamp: click.Timestamp, PlaybackTime: click.PlaybackTime}
+}
+
+// writeEvent writes a Server-Sent Event with data encoded as JSON, which holds on a single line,
+// and returns the encoded data
+func writeEvent(w http.ResponseWriter, id, event string, data any) ([]byte, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, payload)
+	return payload, err
+}
+
+// tailWriteTimeout bounds each message of the click tail, so that a vanished client doesn't hold its subscription
+const tailWriteTimeout = 10 * time.Second
+
+// TailMessage is a message of the click tail
+type TailMessage struct {
+	Type  string        `json:"type"` // click, or dropped before the first click after some were dropped
+	Click *models.Click `json:"click,omitempty"`
+	// number of clicks dropped since the previous message, because the client didn't read them in time
+	Dropped int64 `json:"dropped,omitempty"`
+}
+
+// TailClicks upgrades to a WebSocket sending every click logged on this replica which matches
+// the filters as a JSON text message, once committed. Clicks are dropped rather than queued for
+// clients which don't read them in time, a dropped message tells how many.
+func (h *StreamHandler) TailClicks(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		logger.RequestLogger.Error(r, "Click tail requested without WebSocket upgrade")
+		apihelpers.ErrorResponse(r, w, http.StatusUpgradeRequired, "This endpoint only serves WebSocket connections")
+		return
+	}
+	filter, err := tailFilter(r.URL.Query())
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error in tail parameters: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sub, err := h.Hub.Subscribe(stream.SubscribeOptions{Kind: TailStream, Filter: filter, DropWhenFull: true})
+	if err != nil {
+		subscribeError(w, r, err)
+		return
+	}
+	defer sub.Close()
+
+	server := websocket.Server{
+		// clients authenticate with the admin token, which browsers can't send, so Origin isn't checked
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   func(ws *websocket.Conn) { h.tail(r, ws, sub) },
+	}
+	server.ServeHTTP(w, r)
+}
+
+func (h *StreamHandler) tail(r *http.Request, ws *websocket.Conn, sub *stream.Subscription) {
+	defer ws.Close()
+	// the hijacked connection keeps the deadlines of the server's timeouts
+	ws.SetReadDeadline(time.Time{})
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		// messages from the client are ignored, reading answers pings and the closing handshake
+		var message []byte
+		for websocket.Message.Receive(ws, &message) == nil {
+		}
+	}()
+
+	heartbeat := time.NewTicker(h.Options().Heartbeat)
+	defer heartbeat.Stop()
+	var err error
+	for err == nil {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				// shutting down
+				return
+			}
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				err = sendTail(ws, TailMessage{Type: "dropped", Dropped: dropped})
+			}
+			if err == nil {
+				err = sendTail(ws, TailMessage{Type: "click", Click: &event.Click})
+			}
+		case <-heartbeat.C:
+			ws.SetWriteDeadline(time.Now().Add(tailWriteTimeout))
+			ws.PayloadType = websocket.PingFrame
+			_, err = ws.Write(nil)
+			ws.PayloadType = websocket.TextFrame
+			heartbeat.Reset(h.Options().Heartbeat)
+		}
+	}
+	logger.RequestLogger.Error(r, "Error writing click tail: %v", err)
+}
+
+func sendTail(ws *websocket.Conn, message TailMessage) error {
+	ws.SetWriteDeadline(time.Now().Add(tailWriteTimeout))
+	return websocket.JSON.Send(ws, message)
+}
+
+// tailFilter reads the filters of the click tail, every click matches without filters
+func tailFilter(query url.Values) (func(models.Click) bool, error) {
+	adID := query.Get("ad_id")
+	if adID != "" && uuid.Validate(adID) != nil {
+		return nil, errors.New("invalid value for query param `ad_id` provided, it must be a UUID")
+	}
+	ipPrefix := query.Get("ip_prefix")
+	var network netip.Prefix
+	if strings.Contains(ipPrefix, "/") {
+		var err error
+		if network, err = netip.ParsePrefix(ipPrefix); err != nil {
+			return nil, errors.New("invalid value for query param `ip_prefix` provided, it must be the start of an address or a CIDR like 10.0.0.0/8")
+		}
+		network = network.Masked()
+	}
+	minPlaybackTime := 0
+	if value := query.Get("min_playback_time"); value != "" {
+		var err error
+		if minPlaybackTime, err = strconv.Atoi(value); err != nil || minPlaybackTime < 0 {
+			return nil, errors.New("invalid value for query param `min_playback_time` provided, it must be a number of seconds")
+		}
+	}
+
+	return func(click models.Click) bool {
+		if (adID != "" && click.AdID != adID) || click.PlaybackTime < minPlaybackTime {
+			return false
+		}
+		if network.IsValid() {
+			return network.Contains(clickAddr(click.IPAddress))
+		}
+		return strings.HasPrefix(click.IPAddress, ipPrefix)
+	}, nil
+}
+
+// clickAddr parses the ip_address of a click, which defaults to the caller's address with its port
+func clickAddr(value string) netip.Addr {
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap()
+	}
+	addr, _ := netip.ParseAddr(value)
+	return addr.Unmap()
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/JalajGoswami/video-ad-metrics/internal/stream"
+	"golang.org/x/net/websocket"
+)
+
+// analyticsRepository serves the analytics of testAdID, the methods the tests don't use panic
+type analyticsRepository struct {
+	database.Repository
+	totalClicks atomic.Int64
+}
+
+func (r *analyticsRepository) GetAdAnalytics(ctx context.Context, id string, rangeDate time.Time) (*models.AdAnalyticsData, error) {
+	if id != testAdID {
+		return nil, database.ErrNotFound
+	}
+	total := int(r.totalClicks.Load())
+	return &models.AdAnalyticsData{AdID: id, TotalClicks: total, TotalPlaybackTime: 10 * total}, nil
+}
+
+func newStreamServer(t *testing.T, opts StreamOptions) (*httptest.Server, *StreamHandler, *analyticsRepository) {
+	t.Helper()
+	repo := &analyticsRepository{}
+	hub := stream.NewHub(16)
+	t.Cleanup(hub.Close)
+	streams := NewStreamHandler(repo, hub, opts)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ads/analytics/{id}/stream", streams.StreamAdAnalytics)
+	mux.HandleFunc("GET /admin/clicks/tail", streams.TailClicks)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, streams, repo
+}
+
+type sseEvent struct {
+	id, event, data string
+}
+
+// openStream starts an analytics stream of testAdID and returns a function reading its next event
+func openStream(t *testing.T, server *httptest.Server) func() sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/ads/analytics/"+testAdID+"/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("opening the analytics stream: %v", err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("analytics stream responded %d", res.StatusCode)
+	}
+
+	lines := bufio.NewScanner(res.Body)
+	return func() sseEvent {
+		t.Helper()
+		var event sseEvent
+		for lines.Scan() {
+			field, value, _ := strings.Cut(lines.Text(), ": ")
+			switch field {
+			case "id":
+				event.id = value
+			case "event":
+				event.event = value
+			case "data":
+				event.data = value
+			case "":
+				if event.event != "" {
+					return event
+				}
+			}
+		}
+		t.Fatalf("analytics stream ended: %v", lines.Err())
+		return event
+	}
+}
+
+func analyticsEvent(t *testing.T, event sseEvent) models.AdAnalyticsData {
+	t.Helper()
+	if event.event != "analytics" {
+		t.Fatalf("got a %s event, want analytics: %s", event.event, event.data)
+	}
+	var analytics models.AdAnalyticsData
+	if err := json.Unmarshal([]byte(event.data), &analytics); err != nil {
+		t.Fatalf("decoding analytics: %v", err)
+	}
+	return analytics
+}
+
+func TestStreamAdAnalyticsRefresh(t *testing.T) {
+	server, streams, repo := newStreamServer(t, StreamOptions{Heartbeat: time.Minute, AnalyticsInterval: 10 * time.Millisecond})
+	repo.totalClicks.Store(1)
+	next := openStream(t, server)
+
+	if analytics := analyticsEvent(t, next()); analytics.TotalClicks != 1 || analytics.Period != "hour" {
+		t.Fatalf("first analytics are %+v, want 1 click over an hour", analytics)
+	}
+
+	// unchanged analytics are not sent again, the next event is the click
+	time.Sleep(50 * time.Millisecond)
+	click := models.Click{ID: "click-a", AdID: testAdID, PlaybackTime: 20}
+	streams.Hub.Publish(click)
+	event := next()
+	if event.event != "click" || !strings.Contains(event.data, `"id":"click-a"`) {
+		t.Fatalf("got %s event %s, want the click", event.event, event.data)
+	}
+
+	// clicks counted elsewhere, e.g. by another replica, are pushed on the next refresh
+	repo.totalClicks.Store(3)
+	refreshed := next()
+	analytics := analyticsEvent(t, refreshed)
+	if analytics.TotalClicks != 3 || analytics.AveragePlaybackTime != 10 {
+		t.Errorf("refreshed analytics are %+v, want 3 clicks of 10s", analytics)
+	}
+	// resuming from a refresh doesn't replay the clicks it counts
+	if refreshed.id != event.id {
+		t.Errorf("refreshed analytics have ID %s, want the ID of the last click %s", refreshed.id, event.id)
+	}
+}
+
+func TestStreamSubscriberLimits(t *testing.T) {
+	server, streams, _ := newStreamServer(t, StreamOptions{MaxSubscribers: 1, TailMaxSubscribers: 1, Heartbeat: time.Minute})
+	next := openStream(t, server)
+	next()
+
+	res, _ := get(t, server.URL+"/ads/analytics/"+testAdID+"/stream", "")
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("second analytics stream responded %d, want 503", res.StatusCode)
+	}
+
+	// the click tail has its own limit, a full analytics audience doesn't lock admins out
+	tailURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/admin/clicks/tail"
+	ws, err := websocket.Dial(tailURL, "", server.URL)
+	if err != nil {
+		t.Fatalf("opening the click tail: %v", err)
+	}
+	defer ws.Close()
+	if _, err := websocket.Dial(tailURL, "", server.URL); err == nil || !strings.Contains(err.Error(), "bad status") {
+		t.Errorf("second click tail returned %v, want a bad status", err)
+	}
+
+	if analytics, tail := streams.Hub.Subscribers(AnalyticsStream), streams.Hub.Subscribers(TailStream); analytics != 1 || tail != 1 {
+		t.Errorf("hub has %d analytics and %d tail subscribers, want 1 of each", analytics, tail)
+	}
+
+	// raising a limit applies to the next subscriptions
+	streams.SetOptions(StreamOptions{MaxSubscribers: 2, TailMaxSubscribers: 1})
+	openStream(t, server)()
+}
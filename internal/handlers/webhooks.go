@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/jobs"
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/google/uuid"
+)
+
+// WebhookHandler contains the dependencies needed for the webhook HTTP handlers
+type WebhookHandler struct {
+	DB database.Repository
+}
+
+// NewWebhookHandler creates a new WebhookHandler
+func NewWebhookHandler(db database.Repository) *WebhookHandler {
+	return &WebhookHandler{
+		DB: db,
+	}
+}
+
+// WebhookDeliveryList is a page of webhook deliveries
+type WebhookDeliveryList struct {
+	Values []models.WebhookDelivery `json:"values"`
+	// the cursor of the next page, empty on the last page
+	NextCursor string `json:"next_cursor"`
+}
+
+// CreateWebhook registers an endpoint, its secret is only returned here
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var webhook models.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		logger.RequestLogger.Error(r, "Error decoding request body: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		logger.RequestLogger.Error(r, "Invalid webhook url: %v", webhook.URL)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "url must be an http(s):// url")
+		return
+	}
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+	webhook.ID = uuid.New().String()
+	webhook.CreatedAt = time.Now()
+
+	if err := h.DB.CreateWebhook(r.Context(), &webhook); err != nil {
+		logger.RequestLogger.Error(r, "Error creating webhook: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error creating webhook")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusCreated, webhook, "Webhook created successfully")
+}
+
+// ListWebhooks returns every webhook without its secret
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.DB.ListWebhooks(r.Context())
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error retrieving webhooks: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving webhooks")
+		return
+	}
+	for i := range *webhooks {
+		(*webhooks)[i].Secret = ""
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, webhooks, "")
+}
+
+// DeleteWebhook deletes a webhook with its rules and deliveries
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.DB.DeleteWebhook(r.Context(), id); err != nil {
+		h.webhookError(w, r, err, "Error deleting webhook")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, nil, "Webhook deleted successfully")
+}
+
+// CreateRule adds a threshold rule to a webhook
+func (h *WebhookHandler) CreateRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	var rule models.WebhookRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		logger.RequestLogger.Error(r, "Error decoding request body: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := jobs.ValidateWebhookRule(&rule); err != nil {
+		logger.RequestLogger.Error(r, "Invalid webhook rule: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.SetAdID(r, rule.AdID)
+	if _, err := h.DB.GetAd(r.Context(), rule.AdID); err != nil {
+		if err == database.ErrNotFound {
+			logger.RequestLogger.Error(r, "Ad not found")
+			apihelpers.ErrorResponse(r, w, http.StatusNotFound, "Ad not found")
+		} else {
+			logger.RequestLogger.Error(r, "Error retrieving ad: %v", err)
+			apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving ad")
+		}
+		return
+	}
+	rule.ID = uuid.New().String()
+	rule.WebhookID = id
+	rule.Triggered = false
+	rule.LastTriggeredAt = nil
+	rule.CreatedAt = time.Now()
+
+	if err := h.DB.CreateWebhookRule(r.Context(), &rule); err != nil {
+		h.webhookError(w, r, err, "Error creating webhook rule")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusCreated, rule, "Webhook rule created successfully")
+}
+
+// ListRules returns the rules of a webhook
+func (h *WebhookHandler) ListRules(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	rules, err := h.DB.ListWebhookRules(r.Context(), id)
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error retrieving webhook rules: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving webhook rules")
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, rules, "")
+}
+
+// DeleteRule deletes a rule of a webhook, its deliveries are kept
+func (h *WebhookHandler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	ruleID := r.PathValue("rule_id")
+	if uuid.Validate(ruleID) != nil {
+		logger.RequestLogger.Error(r, "Invalid webhook rule ID: %v", ruleID)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid webhook rule ID")
+		return
+	}
+	if err := h.DB.DeleteWebhookRule(r.Context(), id, ruleID); err != nil {
+		if err == database.ErrNotFound {
+			logger.RequestLogger.Error(r, "Webhook rule not found")
+			apihelpers.ErrorResponse(r, w, http.StatusNotFound, "Webhook rule not found")
+		} else {
+			logger.RequestLogger.Error(r, "Error deleting webhook rule: %v", err)
+			apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error deleting webhook rule")
+		}
+		return
+	}
+	apihelpers.SuccessResponse(r, w, http.StatusOK, nil, "Webhook rule deleted successfully")
+}
+
+// ListDeliveries returns the deliveries of a webhook, most recent first
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	opts := database.ListWebhookDeliveryOptions{WebhookID: id}
+	var err error
+	if opts.PaginationOptions, err = apihelpers.CursorPagination(r); err == nil {
+		opts.Default()
+		if opts.After != nil && opts.After.Sort != opts.CursorSort() {
+			err = errors.New("query param `cursor` was issued for another list")
+		}
+	}
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error in list parameters: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	deliveries, err := h.DB.ListWebhookDeliveries(r.Context(), opts)
+	if err != nil {
+		logger.RequestLogger.Error(r, "Error retrieving webhook deliveries: %v", err)
+		apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, "Error retrieving webhook deliveries")
+		return
+	}
+	result := WebhookDeliveryList{}
+	result.NextCursor = apihelpers.NextCursor(opts.PaginationOptions, deliveries, database.WebhookDeliveryCursor)
+	result.Values = *deliveries
+	apihelpers.SuccessResponse(r, w, http.StatusOK, result, "")
+}
+
+// webhookID reads the webhook ID path param, responding with the error when it isn't valid
+func webhookID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("id")
+	if uuid.Validate(id) != nil {
+		logger.RequestLogger.Error(r, "Invalid webhook ID: %v", id)
+		apihelpers.ErrorResponse(r, w, http.StatusBadRequest, "Invalid webhook ID")
+		return "", false
+	}
+	return id, true
+}
+
+// webhookError responds with the error of a webhook operation, 404 for unknown webhooks
+func (h *WebhookHandler) webhookError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	if err == database.ErrNotFound {
+		logger.RequestLogger.Error(r, "Webhook not found")
+		apihelpers.ErrorResponse(r, w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	logger.RequestLogger.Error(r, "%s: %v", message, err)
+	apihelpers.ErrorResponse(r, w, http.StatusInternalServerError, message)
+}
@@ -0,0 +1,160 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+)
+
+// Check statuses
+const (
+	StatusPass = "pass"
+	StatusWarn = "warn" // failing, but not a reason to stop sending traffic to this replica
+	StatusFail = "fail"
+)
+
+// Check is a single readiness check, it should give up when ctx is done
+type Check struct {
+	Name string
+	// non critical checks report a warning instead of making the replica not ready
+	Critical bool
+	Run      func(ctx context.Context) error
+}
+
+// Result is the outcome of a check in the readiness report
+type Result struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Report is the readiness of the replica with the result of every check
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+var errShuttingDown = errors.New("server is shutting down")
+
+// Checker runs the readiness checks, concurrently and each with its own timeout
+type Checker struct {
+	timeout      time.Duration
+	checks       []Check
+	shuttingDown atomic.Bool
+}
+
+// NewChecker creates a new Checker giving every check at most timeout to complete
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{timeout: timeout, checks: checks}
+}
+
+// ShuttingDown makes the replica report not ready, so load balancers stop
+// sending it new requests while the in-flight ones drain
+func (c *Checker) ShuttingDown() {
+	c.shuttingDown.Store(true)
+}
+
+// Ready runs every check and reports whether the replica should receive traffic
+func (c *Checker) Ready(ctx context.Context) (bool, Report) {
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	ready := true
+	if c.shuttingDown.Load() {
+		ready = false
+		results = append(results, Result{Name: "shutdown", Status: StatusFail, Error: errShuttingDown.Error()})
+	}
+	for _, result := range results {
+		if result.Status == StatusFail {
+			ready = false
+		}
+	}
+
+	report := Report{Status: StatusPass, Checks: results}
+	if !ready {
+		report.Status = StatusFail
+	}
+	return ready, report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Run(ctx)
+	result := Result{
+		Name:       check.Name,
+		Status:     StatusPass,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.Status = StatusWarn
+		if check.Critical {
+			result.Status = StatusFail
+		}
+	}
+	return result
+}
+
+// DatabaseCheck checks that the primary database answers
+func DatabaseCheck(db database.Repository) Check {
+	return Check{Name: "database", Critical: true, Run: db.Ping}
+}
+
+// SchemaCheck checks that every table the service uses has been created
+func SchemaCheck(db database.Repository) Check {
+	return Check{Name: "schema", Critical: true, Run: db.CheckSchema}
+}
+
+// JobFreshnessCheck warns when a job hasn't succeeded on any replica for longer than maxAge.
+// Jobs which never succeeded yet (e.g. on a new deployment) are not reported.
+func JobFreshnessCheck(db database.Repository, job string, maxAge time.Duration) Check {
+	return Check{
+		Name: "job:" + job,
+		Run: func(ctx context.Context) error {
+			run, err := db.GetLastSuccessfulJobRun(ctx, job)
+			if errors.Is(err, database.ErrNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if age := time.Since(run.StartedAt); age > maxAge {
+				return fmt.Errorf("last succeeded %s ago, more than %s", age.Round(time.Second), maxAge)
+			}
+			return nil
+		},
+	}
+}
+
+// PoolSaturationCheck warns when every connection of the pool is in use, so
+// requests (clicks being logged included) queue up waiting for a connection
+func PoolSaturationCheck(stats func() sql.DBStats) Check {
+	return Check{
+		Name: "database_pool",
+		Run: func(ctx context.Context) error {
+			s := stats()
+			if s.MaxOpenConnections > 0 && s.InUse >= s.MaxOpenConnections {
+				return fmt.Errorf("all %d connections in use", s.MaxOpenConnections)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/monitoring"
+)
+
+// Archiver moves old clicks out of the clicks table to keep analytics queries fast
+type Archiver struct {
+	DB      database.Repository
+	mu      sync.RWMutex
+	options database.ArchiveOptions
+}
+
+// NewArchiver creates a new Archiver
+func NewArchiver(db database.Repository, opts database.ArchiveOptions) *Archiver {
+	a := &Archiver{DB: db}
+	a.SetOptions(opts)
+	return a
+}
+
+// SetOptions changes the retention and batch size used from the next run on
+func (a *Archiver) SetOptions(opts database.ArchiveOptions) {
+	opts.Default()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.options = opts
+}
+
+// Options returns the options used by the next run
+func (a *Archiver) Options() database.ArchiveOptions {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.options
+}
+
+// Run performs a single archiving pass and records its metrics
+func (a *Archiver) Run(ctx context.Context) error {
+	opts := a.Options()
+	start := time.Now()
+	archived, err := a.DB.ArchiveOldClicks(ctx, opts)
+	duration := time.Since(start)
+	monitoring.ObserveArchiveDuration(duration)
+
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			logger.InfoLog("Archiving interrupted after %d clicks, will resume on next run", archived)
+		}
+		return err
+	}
+
+	monitoring.SetArchiveLastSuccess(time.Now())
+	logger.InfoLog("Archived %d clicks older than %s in %s", archived, opts.Retention, duration.Round(time.Millisecond))
+	return nil
+}
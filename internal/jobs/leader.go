@@ -0,0 +1,126 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/JalajGoswami/video-ad-metrics/internal/monitoring"
+)
+
+// LeaderElector elects a single leader among replicas using a lease in the database.
+// The leader renews its lease well before it expires; if it dies or loses the database
+// the lease runs out and another replica takes over on its next attempt.
+type LeaderElector struct {
+	db       database.Repository
+	name     string
+	instance string
+	ttl      time.Duration
+
+	mu         sync.RWMutex
+	lease      *models.Lease
+	isLeader   bool
+	validUntil time.Time
+}
+
+// NewLeaderElector creates a new LeaderElector for the named role
+func NewLeaderElector(db database.Repository, name, instance string, ttl time.Duration) *LeaderElector {
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	return &LeaderElector{
+		db:       db,
+		name:     name,
+		instance: instance,
+		ttl:      ttl,
+	}
+}
+
+// Instance returns the identifier of this replica
+func (e *LeaderElector) Instance() string {
+	return e.instance
+}
+
+// IsLeader reports whether this replica holds the lease. Leadership is given up locally
+// once the lease could have expired, even if the database couldn't be reached to renew it.
+func (e *LeaderElector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.isLeader && time.Now().Before(e.validUntil)
+}
+
+// Leader returns the last known lease, nil if no replica has been elected yet
+func (e *LeaderElector) Leader() *models.Lease {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.lease
+}
+
+// Elect makes a single attempt to take or renew the lease
+func (e *LeaderElector) Elect(ctx context.Context) {
+	attemptedAt := time.Now()
+	lease, err := e.db.AcquireLease(ctx, e.name, e.instance, e.ttl)
+	if err != nil && err != database.ErrNotFound {
+		if ctx.Err() == nil {
+			logger.ErrorLog("Leader election for %s failed: %v", e.name, err)
+		}
+		// keep the current state, IsLeader stops reporting leadership once the lease runs out
+		return
+	}
+
+	isLeader := lease != nil && lease.Holder == e.instance
+	e.mu.Lock()
+	wasLeader := e.isLeader && time.Now().Before(e.validUntil)
+	e.lease = lease
+	e.isLeader = isLeader
+	// measured from before the attempt so we never assume more time than the database granted
+	e.validUntil = attemptedAt.Add(e.ttl)
+	e.mu.Unlock()
+
+	if isLeader != wasLeader {
+		monitoring.SetLeader(isLeader)
+		if isLeader {
+			logger.InfoLog("This replica (%s) is now the %s leader", e.instance, e.name)
+		} else {
+			logger.InfoLog("This replica (%s) is no longer the %s leader", e.instance, e.name)
+		}
+	}
+}
+
+// Start keeps taking or renewing the lease until ctx is cancelled, then releases it
+// so another replica doesn't have to wait for it to expire
+func (e *LeaderElector) Start(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+			e.Elect(ctx)
+		}
+	}
+}
+
+func (e *LeaderElector) release() {
+	e.mu.Lock()
+	wasLeader := e.isLeader
+	e.isLeader = false
+	e.mu.Unlock()
+	if !wasLeader {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.db.ReleaseLease(ctx, e.name, e.instance); err != nil {
+		logger.ErrorLog("Failed to release %s leadership: %v", e.name, err)
+		return
+	}
+	monitoring.SetLeader(false)
+	logger.InfoLog("Released %s leadership", e.name)
+}
@@ -0,0 +1,65 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+)
+
+// MonthlyRollup returns a job recomputing monthly_analytics for the current and previous month,
+// the previous month is included so clicks logged late around the month boundary are counted
+func MonthlyRollup(db database.Repository) Func {
+	return func(ctx context.Context) error {
+		now := time.Now().UTC()
+		from := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+		rows, err := db.RollupMonthlyAnalytics(ctx, from)
+		if err != nil {
+			return err
+		}
+		logger.InfoLog("Rolled up monthly analytics since %s (%d rows)", from.Format("2006-01"), rows)
+		return nil
+	}
+}
+
+// Purger deletes archived clicks older than the retention window,
+// a zero retention keeps archived clicks forever
+type Purger struct {
+	DB      database.Repository
+	mu      sync.RWMutex
+	options database.ArchiveOptions
+}
+
+// NewPurger creates a new Purger
+func NewPurger(db database.Repository, opts database.ArchiveOptions) *Purger {
+	p := &Purger{DB: db}
+	p.SetOptions(opts)
+	return p
+}
+
+// SetOptions changes the retention and batch size used from the next run on
+func (p *Purger) SetOptions(opts database.ArchiveOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.options = opts
+}
+
+// Run performs a single purge pass
+func (p *Purger) Run(ctx context.Context) error {
+	p.mu.RLock()
+	opts := p.options
+	p.mu.RUnlock()
+
+	if opts.Retention <= 0 {
+		logger.DebugLog("Archived clicks retention is disabled, nothing to purge")
+		return nil
+	}
+	deleted, err := p.DB.PurgeArchivedClicks(ctx, opts)
+	if err != nil {
+		return err
+	}
+	logger.InfoLog("Purged %d archived clicks older than %s", deleted, opts.Retention)
+	return nil
+}
@@ -0,0 +1,87 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+)
+
+// deliver sends a stored report to the destination of its spec
+func (r *Reporter) deliver(ctx context.Context, spec models.ReportSpec, report *models.GeneratedReport) error {
+	opts := r.Options()
+	switch spec.Delivery {
+	case "directory":
+		if opts.Directory == "" {
+			return errors.New("directory delivery is not configured")
+		}
+		return writeReport(filepath.Join(opts.Directory, spec.ID), report)
+	case "webhook":
+		if spec.WebhookURL == nil {
+			return errors.New("no webhook url")
+		}
+		return postReport(ctx, *spec.WebhookURL, opts.WebhookTimeout, report)
+	}
+	return nil
+}
+
+// writeReport writes the file of a report in dir, through a temporary file renamed
+// once complete so that readers of the directory never see a partial report
+func writeReport(dir string, report *models.GeneratedReport) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	file, err := os.CreateTemp(dir, ".report-*")
+	if err != nil {
+		return fmt.Errorf("failed to create report file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(report.Content); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write report file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write report file: %w", err)
+	}
+	if err := os.Chmod(file.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write report file: %w", err)
+	}
+	if err := os.Rename(file.Name(), filepath.Join(dir, report.Filename)); err != nil {
+		return fmt.Errorf("failed to write report file: %w", err)
+	}
+	return nil
+}
+
+// postReport posts the file of a report to a webhook, any 2xx response is a success
+func postReport(ctx context.Context, webhookURL string, timeout time.Duration, report *models.GeneratedReport) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(report.Content))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", report.ContentType)
+	req.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": report.Filename}))
+	req.Header.Set("X-Report-ID", report.ID)
+	req.Header.Set("X-Report-Spec-ID", report.SpecID)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package jobs
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"html/template"
+	"strconv"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+)
+
+// reportContentTypes are the content types of the report formats
+var reportContentTypes = map[string]string{
+	"csv":  "text/csv; charset=utf-8",
+	"html": "text/html; charset=utf-8",
+	"json": "application/json",
+}
+
+// renderReport renders the rows of a report in the format of its spec
+func renderReport(spec models.ReportSpec, report *models.GeneratedReport, rows []models.ReportRow) ([]byte, string, error) {
+	var buf bytes.Buffer
+	var err error
+	switch spec.Format {
+	case "json":
+		err = renderReportJSON(&buf, spec, report, rows)
+	case "html":
+		header, cells := reportTable(spec, rows)
+		err = reportHTML.Execute(&buf, map[string]any{"Spec": spec, "Report": report, "Header": header, "Rows": cells})
+	default:
+		header, cells := reportTable(spec, rows)
+		w := csv.NewWriter(&buf)
+		w.Write(header)
+		w.WriteAll(cells)
+		err = w.Error()
+	}
+	return buf.Bytes(), reportContentTypes[spec.Format], err
+}
+
+// averagePlaybackTime is the mean playback time of the clicks of row, 0 without clicks
+func averagePlaybackTime(row models.ReportRow) float64 {
+	if row.Clicks == 0 {
+		return 0
+	}
+	return float64(row.PlaybackTime) / float64(row.Clicks)
+}
+
+// reportTable lays out the rows of a report as text cells for CSV and HTML,
+// the group columns come first then the metrics in the order of the spec
+func reportTable(spec models.ReportSpec, rows []models.ReportRow) ([]string, [][]string) {
+	var header []string
+	var byAd, byTime bool
+	for _, group := range spec.GroupBy {
+		if group == "ad" {
+			byAd = true
+		} else {
+			byTime = true
+		}
+	}
+	if byAd {
+		header = append(header, "ad_id", "ad_name")
+	}
+	if byTime {
+		header = append(header, "period_start")
+	}
+	header = append(header, spec.Metrics...)
+
+	cells := make([][]string, 0, len(rows))
+	for _, row := range rows {
+		var line []string
+		if byAd {
+			line = append(line, deref(row.AdID), deref(row.AdName))
+		}
+		if byTime {
+			start := ""
+			if row.Bucket != nil {
+				start = row.Bucket.UTC().Format(time.RFC3339)
+			}
+			line = append(line, start)
+		}
+		for _, metric := range spec.Metrics {
+			switch metric {
+			case "clicks":
+				line = append(line, strconv.Itoa(row.Clicks))
+			case "playback_time":
+				line = append(line, strconv.Itoa(row.PlaybackTime))
+			case "average_playback_time":
+				line = append(line, strconv.FormatFloat(averagePlaybackTime(row), 'f', 2, 64))
+			}
+		}
+		cells = append(cells, line)
+	}
+	return header, cells
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+type reportJSONRow struct {
+	AdID                *string    `json:"ad_id,omitempty"`
+	AdName              *string    `json:"ad_name,omitempty"`
+	PeriodStart         *time.Time `json:"period_start,omitempty"`
+	Clicks              *int       `json:"clicks,omitempty"`
+	PlaybackTime        *int       `json:"playback_time,omitempty"`
+	AveragePlaybackTime *float64   `json:"average_playback_time,omitempty"`
+}
+
+func renderReportJSON(buf *bytes.Buffer, spec models.ReportSpec, report *models.GeneratedReport, rows []models.ReportRow) error {
+	values := make([]reportJSONRow, 0, len(rows))
+	for _, row := range rows {
+		value := reportJSONRow{AdID: row.AdID, AdName: row.AdName, PeriodStart: row.Bucket}
+		for _, metric := range spec.Metrics {
+			switch metric {
+			case "clicks":
+				value.Clicks = &row.Clicks
+			case "playback_time":
+				value.PlaybackTime = &row.PlaybackTime
+			case "average_playback_time":
+				average := averagePlaybackTime(row)
+				value.AveragePlaybackTime = &average
+			}
+		}
+		values = append(values, value)
+	}
+	encoder := json.NewEncoder(buf)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]any{
+		"report_id":    report.ID,
+		"spec_id":      spec.ID,
+		"name":         spec.Name,
+		"period_start": report.PeriodStart,
+		"period_end":   report.PeriodEnd,
+		"generated_at": report.CreatedAt,
+		"rows":         values,
+	})
+}
+
+var reportHTML = template.Must(template.New("report").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.UTC().Format("2006-01-02") },
+	"last": func(t time.Time) string { return t.UTC().AddDate(0, 0, -1).Format("2006-01-02") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Spec.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; }
+td { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Spec.Name}}</h1>
+<p>{{date .Report.PeriodStart}} to {{last .Report.PeriodEnd}} (UTC), generated {{.Report.CreatedAt.UTC.Format "2006-01-02 15:04"}} UTC</p>
+<table>
+<thead><tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{else}}<tr><td colspan="{{len .Header}}">No clicks</td></tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,301 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+// Report spec values, see models.ReportSpec
+var (
+	ReportMetrics    = []string{"clicks", "playback_time", "average_playback_time"}
+	ReportPeriods    = []string{"day", "week", "month"}
+	ReportGroups     = []string{"ad", "day", "week", "month"}
+	ReportFormats    = []string{"csv", "html", "json"}
+	ReportDeliveries = []string{"none", "directory", "webhook"}
+)
+
+// Delivery statuses of a report
+const (
+	DeliveryNone      = "none"
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// reportSchedules are the default schedules by period, an hour after the period ends
+// so that clicks logged late are counted
+var reportSchedules = map[string]string{
+	"day":   "0 1 * * *",
+	"week":  "0 1 * * 1",
+	"month": "0 1 1 * *",
+}
+
+// ReportOptions controls where reports are delivered
+type ReportOptions struct {
+	// directory receiving the reports with directory delivery, which is disabled when empty
+	Directory string
+	// time limit of each webhook call
+	WebhookTimeout time.Duration
+}
+
+func (o *ReportOptions) Default() {
+	if o.WebhookTimeout <= 0 {
+		o.WebhookTimeout = 10 * time.Second
+	}
+}
+
+// Reporter generates the reports of the report specs which are due and delivers them
+type Reporter struct {
+	DB      database.Repository
+	mu      sync.RWMutex
+	options ReportOptions
+}
+
+// NewReporter creates a new Reporter
+func NewReporter(db database.Repository, opts ReportOptions) *Reporter {
+	r := &Reporter{DB: db}
+	r.SetOptions(opts)
+	return r
+}
+
+// SetOptions changes the delivery options used from the next report on
+func (r *Reporter) SetOptions(opts ReportOptions) {
+	opts.Default()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.options = opts
+}
+
+// Options returns the delivery options used by the next report
+func (r *Reporter) Options() ReportOptions {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.options
+}
+
+// Validate checks a new report spec and fills in its defaults, its errors are meant for the client
+func (r *Reporter) Validate(spec *models.ReportSpec) error {
+	if strings.TrimSpace(spec.Name) == "" {
+		return errors.New("name is required")
+	}
+	if spec.AdIDs == nil {
+		spec.AdIDs = []string{}
+	}
+	for _, id := range spec.AdIDs {
+		if uuid.Validate(id) != nil {
+			return fmt.Errorf("invalid ad ID %q in ad_ids", id)
+		}
+	}
+	if spec.Campaigns == nil {
+		spec.Campaigns = []string{}
+	}
+	for _, campaign := range spec.Campaigns {
+		if campaign == "" || len(campaign) > database.MaxCampaignLength {
+			return fmt.Errorf("invalid campaign %q in campaigns, it must be 1 to %d bytes long", campaign, database.MaxCampaignLength)
+		}
+	}
+	if len(spec.Metrics) == 0 {
+		spec.Metrics = slices.Clone(ReportMetrics)
+	}
+	for _, metric := range spec.Metrics {
+		if !slices.Contains(ReportMetrics, metric) {
+			return fmt.Errorf("invalid metric %q, it must be one of %s", metric, strings.Join(ReportMetrics, ", "))
+		}
+	}
+	if !slices.Contains(ReportPeriods, spec.Period) {
+		return fmt.Errorf("period must be one of %s", strings.Join(ReportPeriods, ", "))
+	}
+	if spec.GroupBy == nil {
+		spec.GroupBy = []string{}
+	}
+	intervals := 0
+	for _, group := range spec.GroupBy {
+		if !slices.Contains(ReportGroups, group) {
+			return fmt.Errorf("invalid group_by %q, it must be one of %s", group, strings.Join(ReportGroups, ", "))
+		}
+		if group != "ad" {
+			intervals++
+		}
+	}
+	if intervals > 1 {
+		return errors.New("group_by accepts a single one of day, week or month")
+	}
+
+	if spec.Format == "" {
+		spec.Format = "csv"
+	}
+	if !slices.Contains(ReportFormats, spec.Format) {
+		return fmt.Errorf("format must be one of %s", strings.Join(ReportFormats, ", "))
+	}
+	if spec.Schedule == "" {
+		spec.Schedule = reportSchedules[spec.Period]
+	}
+	if _, err := cron.ParseStandard(spec.Schedule); err != nil {
+		return fmt.Errorf("schedule is not a valid cron expression: %q", spec.Schedule)
+	}
+
+	if spec.Delivery == "" {
+		spec.Delivery = DeliveryNone
+	}
+	switch spec.Delivery {
+	case DeliveryNone:
+		spec.WebhookURL = nil
+	case "directory":
+		if r.Options().Directory == "" {
+			return errors.New("directory delivery is not configured on this server")
+		}
+		spec.WebhookURL = nil
+	case "webhook":
+		if spec.WebhookURL == nil {
+			return errors.New("webhook_url is required with webhook delivery")
+		}
+		u, err := url.Parse(*spec.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("webhook_url must be an http(s):// url")
+		}
+	default:
+		return fmt.Errorf("delivery must be one of %s", strings.Join(ReportDeliveries, ", "))
+	}
+	return nil
+}
+
+// NextRun returns the next scheduled run of spec after t, schedules are in UTC
+func NextRun(spec models.ReportSpec, t time.Time) (time.Time, error) {
+	schedule, err := cron.ParseStandard(spec.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedule.Next(t.UTC()), nil
+}
+
+// reportPeriod returns the last complete day, week (from Monday) or month before now, in UTC
+func reportPeriod(period string, now time.Time) (from, to time.Time) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case "week":
+		to = today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		return to.AddDate(0, 0, -7), to
+	case "month":
+		to = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return to.AddDate(0, -1, 0), to
+	default:
+		return today.AddDate(0, 0, -1), today
+	}
+}
+
+// Run generates and delivers the report of every due spec. A spec is rescheduled once its report
+// is stored, failed deliveries are recorded on the report and are not retried.
+func (r *Reporter) Run(ctx context.Context) error {
+	now := time.Now()
+	specs, err := r.DB.ListDueReportSpecs(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, spec := range *specs {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		if _, err := r.Generate(ctx, spec, now); err != nil {
+			errs = append(errs, fmt.Errorf("report spec %s: %w", spec.ID, err))
+			continue
+		}
+		next, err := NextRun(spec, now)
+		if err == nil {
+			err = r.DB.SetReportSpecRun(ctx, spec.ID, now, next)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("report spec %s: %w", spec.ID, err))
+		}
+	}
+	if len(*specs) > 0 {
+		logger.InfoLog("Generated %d of %d due reports", len(*specs)-len(errs), len(*specs))
+	}
+	return errors.Join(errs...)
+}
+
+// Generate renders the report of spec for the last complete period before now, stores it
+// then delivers it. Only rendering and storing errors are returned, the delivery outcome is
+// recorded on the returned report.
+func (r *Reporter) Generate(ctx context.Context, spec models.ReportSpec, now time.Time) (*models.GeneratedReport, error) {
+	from, to := reportPeriod(spec.Period, now)
+	query := database.ReportQuery{AdIDs: spec.AdIDs, Campaigns: spec.Campaigns, From: from, To: to}
+	for _, group := range spec.GroupBy {
+		if group == "ad" {
+			query.ByAd = true
+		} else {
+			query.Interval = group
+		}
+	}
+	rows, err := r.DB.GetReportRows(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.GeneratedReport{
+		ID:             uuid.New().String(),
+		SpecID:         spec.ID,
+		Filename:       reportFilename(spec, from),
+		PeriodStart:    from,
+		PeriodEnd:      to,
+		DeliveryStatus: DeliveryPending,
+		CreatedAt:      now,
+	}
+	if spec.Delivery == DeliveryNone {
+		report.DeliveryStatus = DeliveryNone
+	}
+	report.Content, report.ContentType, err = renderReport(spec, report, *rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render report: %w", err)
+	}
+	report.Size = len(report.Content)
+	if err := r.DB.CreateReport(ctx, report); err != nil {
+		return nil, err
+	}
+	if spec.Delivery == DeliveryNone {
+		return report, nil
+	}
+
+	report.DeliveryStatus = DeliveryDelivered
+	if err := r.deliver(ctx, spec, report); err != nil {
+		logger.ErrorLog("Failed to deliver report %s of spec %s: %v", report.ID, spec.ID, err)
+		message := err.Error()
+		report.DeliveryStatus = DeliveryFailed
+		report.DeliveryError = &message
+	}
+	if err := r.DB.SetReportDelivery(ctx, report.ID, report.DeliveryStatus, report.DeliveryError); err != nil {
+		logger.ErrorLog("Failed to record delivery of report %s: %v", report.ID, err)
+	}
+	return report, nil
+}
+
+// reportFilename names the file of a report after its spec and period start, e.g. weekly-clicks-2024-01-08.csv
+func reportFilename(spec models.ReportSpec, from time.Time) string {
+	name := strings.Trim(strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			return c
+		case c >= 'A' && c <= 'Z':
+			return c + 'a' - 'A'
+		}
+		return '-'
+	}, spec.Name), "-")
+	if name == "" {
+		name = "report"
+	}
+	return fmt.Sprintf("%s-%s.%s", name, from.Format("2006-01-02"), spec.Format)
+}
@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+)
+
+// reportsRepository records the queries of the reports, the methods the tests don't use panic
+type reportsRepository struct {
+	database.Repository
+	queries []database.ReportQuery
+}
+
+func (r *reportsRepository) GetReportRows(ctx context.Context, query database.ReportQuery) (*[]models.ReportRow, error) {
+	r.queries = append(r.queries, query)
+	return &[]models.ReportRow{}, nil
+}
+
+func (r *reportsRepository) CreateReport(ctx context.Context, report *models.GeneratedReport) error {
+	return nil
+}
+
+func TestReportCampaigns(t *testing.T) {
+	repo := &reportsRepository{}
+	reporter := NewReporter(repo, ReportOptions{})
+
+	spec := models.ReportSpec{Name: "Summer campaigns", Period: "week", Campaigns: []string{"summer-sale", "summer-launch"}}
+	if err := reporter.Validate(&spec); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+	if spec.AdIDs == nil {
+		t.Error("Validate() left ad_ids nil, which is stored as NULL")
+	}
+	if _, err := reporter.Generate(context.Background(), spec, time.Now()); err != nil {
+		t.Fatalf("Generate() = %v", err)
+	}
+	if len(repo.queries) != 1 || !slices.Equal(repo.queries[0].Campaigns, spec.Campaigns) {
+		t.Errorf("report queries are %+v, want the campaigns of the spec", repo.queries)
+	}
+
+	// every ad is covered without campaigns
+	spec = models.ReportSpec{Name: "Every ad", Period: "day"}
+	if err := reporter.Validate(&spec); err != nil || spec.Campaigns == nil {
+		t.Errorf("Validate() = %v with campaigns %#v, want no error and no campaigns", err, spec.Campaigns)
+	}
+
+	for _, campaign := range []string{"", strings.Repeat("c", database.MaxCampaignLength+1)} {
+		spec := models.ReportSpec{Name: "Invalid campaign", Period: "day", Campaigns: []string{campaign}}
+		if err := reporter.Validate(&spec); err == nil || !strings.Contains(err.Error(), "invalid campaign") {
+			t.Errorf("Validate() of campaign %q = %v, want an invalid campaign error", campaign, err)
+		}
+	}
+}
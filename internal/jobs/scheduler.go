@@ -0,0 +1,403 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/JalajGoswami/video-ad-metrics/internal/jobs")
+
+var (
+	ErrJobNotFound       = errors.New("job not found")
+	ErrJobAlreadyRunning = errors.New("job is already running")
+	// ErrSchedulerStopped is returned when triggering a job before the scheduler started or once it is stopping
+	ErrSchedulerStopped = errors.New("scheduler is not running")
+)
+
+// Func is the work done by a scheduled job, it should stop when ctx is cancelled
+type Func func(ctx context.Context) error
+
+type job struct {
+	name     string
+	spec     string
+	schedule cron.Schedule
+	run      Func
+	next     time.Time
+	running  bool
+}
+
+// Status describes a registered job for the admin API
+type Status struct {
+	Name     string         `json:"name"`
+	Schedule string         `json:"schedule"`
+	Paused   bool           `json:"paused"`
+	Running  bool           `json:"running"`
+	NextRun  time.Time      `json:"next_run"`
+	LastRun  *models.JobRun `json:"last_run,omitempty"`
+}
+
+// Leadership tells the scheduler whether this replica should run scheduled jobs
+type Leadership interface {
+	IsLeader() bool
+}
+
+// Scheduler runs named jobs on cron schedules. Scheduled runs only happen on the leader
+// replica, and every run also takes a Postgres advisory lock named after the job, so a
+// job never runs twice at the same time even during a leadership change or when it is
+// triggered manually on another replica. The lock pins a connection of the primary pool for
+// the whole run, on top of the connections the job queries with. Every run is recorded in
+// the jobs table.
+type Scheduler struct {
+	db         database.Repository
+	instance   string
+	leadership Leadership
+	mu         sync.Mutex
+	jobs       map[string]*job
+	names      []string
+	wake       chan struct{}
+	ctx        context.Context
+	// set once Start waits for the running jobs, no run may start after that
+	stopping bool
+	wg       sync.WaitGroup
+}
+
+// NewScheduler creates a new Scheduler, instance identifies this replica in the jobs table.
+// A nil leadership runs scheduled jobs on every replica.
+func NewScheduler(db database.Repository, instance string, leadership Leadership) *Scheduler {
+	return &Scheduler{
+		db:         db,
+		instance:   instance,
+		leadership: leadership,
+		jobs:       map[string]*job{},
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// InstanceID returns an identifier for this replica, the configured one if set or hostname and pid
+func InstanceID(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// Register adds a job running on the given cron expression (standard 5 fields or descriptors like @daily)
+func (s *Scheduler) Register(name, spec string, run Func) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q for job %s: %w", spec, name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[name]; ok {
+		return fmt.Errorf("job %s is already registered", name)
+	}
+	s.jobs[name] = &job{
+		name:     name,
+		spec:     spec,
+		schedule: schedule,
+		run:      run,
+		next:     schedule.Next(time.Now()),
+	}
+	s.names = append(s.names, name)
+	s.notify()
+	return nil
+}
+
+// Start runs due jobs until ctx is cancelled, then waits for running jobs to return
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		next := now.Add(time.Minute)
+		for _, j := range s.jobs {
+			if !j.next.After(now) {
+				s.execute(ctx, j, "schedule")
+				j.next = j.schedule.Next(now)
+			}
+			if j.next.Before(next) {
+				next = j.next
+			}
+		}
+		s.mu.Unlock()
+
+		timer.Reset(time.Until(next))
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.stopping = true
+			s.mu.Unlock()
+			if running := s.runningJobs(); len(running) > 0 {
+				logger.InfoLog("Waiting for running jobs to stop: %s", strings.Join(running, ", "))
+			}
+			s.wg.Wait()
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Scheduler) runningJobs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var running []string
+	for _, j := range s.jobs {
+		if j.running {
+			running = append(running, j.name)
+		}
+	}
+	slices.Sort(running)
+	return running
+}
+
+// Reschedule changes the cron expression of a registered job
+func (s *Scheduler) Reschedule(name, spec string) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q for job %s: %w", spec, name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return ErrJobNotFound
+	}
+	j.spec = spec
+	j.schedule = schedule
+	j.next = schedule.Next(time.Now())
+	s.notify()
+	return nil
+}
+
+// RunAtStartup makes the first scheduled run of a job happen as soon as the scheduler starts
+func (s *Scheduler) RunAtStartup(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return ErrJobNotFound
+	}
+	j.next = time.Now()
+	s.notify()
+	return nil
+}
+
+// Trigger runs a job right away on this replica, regardless of its schedule or paused state
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if s.ctx == nil || s.stopping {
+		return ErrSchedulerStopped
+	}
+	if j.running {
+		return ErrJobAlreadyRunning
+	}
+	s.execute(s.ctx, j, "manual")
+	return nil
+}
+
+// Pause stops scheduled runs of a job on every replica until it is resumed
+func (s *Scheduler) Pause(ctx context.Context, name string) error {
+	return s.setPaused(ctx, name, true)
+}
+
+// Resume re-enables scheduled runs of a paused job
+func (s *Scheduler) Resume(ctx context.Context, name string) error {
+	return s.setPaused(ctx, name, false)
+}
+
+func (s *Scheduler) setPaused(ctx context.Context, name string, paused bool) error {
+	if !s.has(name) {
+		return ErrJobNotFound
+	}
+	return s.db.SetJobPaused(ctx, name, paused)
+}
+
+// Runs returns the most recent runs of a job across all replicas
+func (s *Scheduler) Runs(ctx context.Context, name string, limit int) (*[]models.JobRun, error) {
+	if !s.has(name) {
+		return nil, ErrJobNotFound
+	}
+	return s.db.ListJobRuns(ctx, name, limit)
+}
+
+// Jobs returns the status of every registered job in registration order
+func (s *Scheduler) Jobs(ctx context.Context) ([]Status, error) {
+	lastRuns, err := s.db.GetLatestJobRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	statuses := make([]Status, 0, len(s.names))
+	for _, name := range s.names {
+		j := s.jobs[name]
+		statuses = append(statuses, Status{
+			Name:     j.name,
+			Schedule: j.spec,
+			Running:  j.running,
+			NextRun:  j.next,
+		})
+	}
+	s.mu.Unlock()
+
+	for i := range statuses {
+		paused, err := s.isPaused(ctx, statuses[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		statuses[i].Paused = paused
+
+		idx := slices.IndexFunc(*lastRuns, func(run models.JobRun) bool { return run.JobName == statuses[i].Name })
+		if idx >= 0 {
+			statuses[i].LastRun = &(*lastRuns)[idx]
+		}
+	}
+	return statuses, nil
+}
+
+func (s *Scheduler) has(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.jobs[name]
+	return ok
+}
+
+func (s *Scheduler) isPaused(ctx context.Context, name string) (bool, error) {
+	state, err := s.db.GetJobState(ctx, name)
+	if err != nil {
+		if err == database.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return state.Paused, nil
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// execute starts a run of j in the background, s.mu must be held
+func (s *Scheduler) execute(ctx context.Context, j *job, trigger string) {
+	if j.running {
+		logger.InfoLog("Skipping run of job %s, previous run is still in progress", j.name)
+		return
+	}
+	j.running = true
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			j.running = false
+			s.mu.Unlock()
+		}()
+
+		if err := s.runLocked(ctx, j, trigger); err != nil && ctx.Err() == nil {
+			logger.ErrorLog("Job %s failed: %v", j.name, err)
+		}
+	}()
+}
+
+// runLocked runs j while holding its advisory lock and records the run
+func (s *Scheduler) runLocked(ctx context.Context, j *job, trigger string) error {
+	if trigger == "schedule" {
+		if s.leadership != nil && !s.leadership.IsLeader() {
+			logger.DebugLog("Skipping run of job %s, this replica is not the leader", j.name)
+			return nil
+		}
+		paused, err := s.isPaused(ctx, j.name)
+		if err != nil {
+			return fmt.Errorf("failed to check if job is paused: %w", err)
+		}
+		if paused {
+			logger.DebugLog("Skipping run of paused job %s", j.name)
+			return nil
+		}
+	}
+
+	release, acquired, err := s.db.TryAdvisoryLock(ctx, "job:"+j.name)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		logger.DebugLog("Skipping run of job %s, it is running on another replica", j.name)
+		return nil
+	}
+	defer release()
+
+	// the run's span is the parent of the spans of the queries it makes
+	ctx, span := tracer.Start(ctx, "job "+j.name, trace.WithAttributes(
+		attribute.String("job.name", j.name),
+		attribute.String("job.trigger", trigger),
+	))
+	defer span.End()
+
+	run := &models.JobRun{
+		ID:          uuid.New().String(),
+		JobName:     j.name,
+		Status:      "running",
+		TriggeredBy: trigger,
+		Instance:    s.instance,
+		StartedAt:   time.Now(),
+	}
+	if err := s.db.CreateJobRun(ctx, run); err != nil {
+		return err
+	}
+
+	runErr := j.run(ctx)
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	run.Status = "succeeded"
+	if runErr != nil {
+		run.Status = "failed"
+		message := runErr.Error()
+		run.Error = &message
+		span.RecordError(runErr)
+		span.SetStatus(codes.Error, message)
+	}
+	// record the outcome even if the run was interrupted by shutdown
+	if err := s.db.FinishJobRun(context.WithoutCancel(ctx), run); err != nil {
+		logger.ErrorLog("Failed to record run of job %s: %v", j.name, err)
+	}
+	return runErr
+}
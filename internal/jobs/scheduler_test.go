@@ -0,0 +1,101 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+)
+
+// schedulerRepository grants every advisory lock and records job runs, the methods the tests don't use panic
+type schedulerRepository struct {
+	database.Repository
+
+	mu   sync.Mutex
+	runs []models.JobRun
+}
+
+func (r *schedulerRepository) TryAdvisoryLock(ctx context.Context, key string) (func(), bool, error) {
+	return func() {}, true, nil
+}
+
+func (r *schedulerRepository) CreateJobRun(ctx context.Context, run *models.JobRun) error {
+	return nil
+}
+
+func (r *schedulerRepository) FinishJobRun(ctx context.Context, run *models.JobRun) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs = append(r.runs, *run)
+	return nil
+}
+
+func (r *schedulerRepository) finishedRuns() []models.JobRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]models.JobRun{}, r.runs...)
+}
+
+func TestTriggerOnlyWhileRunning(t *testing.T) {
+	repo := &schedulerRepository{}
+	scheduler := NewScheduler(repo, "test", nil)
+	release := make(chan struct{})
+	// a schedule which never comes in the test, so that only triggers run the job
+	if err := scheduler.Register("test-job", "0 0 1 1 *", func(ctx context.Context) error {
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := scheduler.Trigger("test-job"); err != ErrSchedulerStopped {
+		t.Fatalf("Trigger() before Start = %v, want ErrSchedulerStopped", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		scheduler.Start(ctx)
+	}()
+	// Start sets its context before waiting for its first timer
+	deadline := time.Now().Add(time.Second)
+	err := scheduler.Trigger("test-job")
+	for err == ErrSchedulerStopped && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		err = scheduler.Trigger("test-job")
+	}
+	if err != nil {
+		t.Fatalf("Trigger() = %v, want the job to start", err)
+	}
+	if err := scheduler.Trigger("test-job"); err != ErrJobAlreadyRunning {
+		t.Errorf("Trigger() of a running job = %v, want ErrJobAlreadyRunning", err)
+	}
+
+	// stopping waits for the running job, triggers are rejected meanwhile
+	cancel()
+	for {
+		scheduler.mu.Lock()
+		stopping := scheduler.stopping
+		scheduler.mu.Unlock()
+		if stopping {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := scheduler.Trigger("test-job"); err != ErrSchedulerStopped {
+		t.Errorf("Trigger() while stopping = %v, want ErrSchedulerStopped", err)
+	}
+	close(release)
+	<-stopped
+
+	if err := scheduler.Trigger("test-job"); err != ErrSchedulerStopped {
+		t.Errorf("Trigger() after stopping = %v, want ErrSchedulerStopped", err)
+	}
+	if runs := repo.finishedRuns(); len(runs) != 1 || runs[0].TriggeredBy != "manual" {
+		t.Errorf("finished runs are %+v, want the single manual run", runs)
+	}
+}
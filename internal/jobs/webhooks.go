@@ -0,0 +1,374 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/google/uuid"
+)
+
+// Webhook rule values, see models.WebhookRule
+var (
+	WebhookMetrics = []string{
+		"total_clicks", "total_playback_time", "average_playback_time",
+		"total_clicks_in_range", "total_playback_time_in_range", "average_playback_time_in_range",
+	}
+	WebhookOperators = []string{"above", "below"}
+	WebhookPeriods   = []string{"minute", "hour", "day", "week", "month"}
+)
+
+// Delivery statuses of a webhook delivery
+const (
+	WebhookPending   = "pending"
+	WebhookSucceeded = "succeeded"
+	WebhookFailed    = "failed"
+)
+
+// WebhookEventThreshold is the event of the deliveries sent when a rule triggers
+const WebhookEventThreshold = "threshold.crossed"
+
+// webhookBatchSize is the max number of deliveries attempted per run
+const webhookBatchSize = 100
+
+// ValidateWebhookRule checks a new rule and fills in its defaults, its errors are meant for the client
+func ValidateWebhookRule(rule *models.WebhookRule) error {
+	if uuid.Validate(rule.AdID) != nil {
+		return errors.New("ad_id must be a valid ad ID")
+	}
+	if !slices.Contains(WebhookMetrics, rule.Metric) {
+		return fmt.Errorf("metric must be one of %s", strings.Join(WebhookMetrics, ", "))
+	}
+	if !slices.Contains(WebhookOperators, rule.Operator) {
+		return fmt.Errorf("operator must be one of %s", strings.Join(WebhookOperators, ", "))
+	}
+	if rule.Period == "" {
+		rule.Period = "hour"
+	}
+	if !slices.Contains(WebhookPeriods, rule.Period) {
+		return fmt.Errorf("period must be one of %s", strings.Join(WebhookPeriods, ", "))
+	}
+	return nil
+}
+
+// SignWebhook returns the X-Webhook-Signature of a payload sent at timestamp (unix seconds):
+// the hex HMAC-SHA256 of "<timestamp>.<payload>" keyed by the webhook secret
+func SignWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookOptions controls how webhook deliveries are sent and retried
+type WebhookOptions struct {
+	// time limit of each attempt
+	Timeout time.Duration
+	// attempts before a delivery is failed
+	MaxAttempts int
+	// delay before the first retry, doubled after each failed attempt up to BackoffMax
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+}
+
+func (o *WebhookOptions) Default() {
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 8
+	}
+	if o.BackoffBase <= 0 {
+		o.BackoffBase = time.Minute
+	}
+	if o.BackoffMax < o.BackoffBase {
+		o.BackoffMax = max(time.Hour, o.BackoffBase)
+	}
+}
+
+// backoff is the delay after the given number of failed attempts
+func (o WebhookOptions) backoff(attempts int) time.Duration {
+	delay := o.BackoffBase
+	for i := 1; i < attempts && delay < o.BackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, o.BackoffMax)
+}
+
+// Notifier evaluates the webhook rules against the analytics of their ads and sends
+// the resulting deliveries, retrying failed ones with exponential backoff
+type Notifier struct {
+	DB      database.Repository
+	Client  *http.Client
+	mu      sync.RWMutex
+	options WebhookOptions
+}
+
+// NewNotifier creates a new Notifier
+func NewNotifier(db database.Repository, opts WebhookOptions) *Notifier {
+	n := &Notifier{DB: db, Client: &http.Client{}}
+	n.SetOptions(opts)
+	return n
+}
+
+// SetOptions changes the timeout and retry options used from the next attempt on
+func (n *Notifier) SetOptions(opts WebhookOptions) {
+	opts.Default()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.options = opts
+}
+
+// Options returns the options used by the next attempt
+func (n *Notifier) Options() WebhookOptions {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.options
+}
+
+// Run evaluates every rule then attempts the due deliveries, new ones included
+func (n *Notifier) Run(ctx context.Context) error {
+	now := time.Now()
+	evaluateErr := n.Evaluate(ctx, now)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(evaluateErr, n.Deliver(ctx))
+}
+
+// analyticsKey identifies the analytics shared by the rules on the same ad and period
+type analyticsKey struct {
+	adID   string
+	period string
+}
+
+// Evaluate queues a delivery for every rule whose condition became true, and re-arms the
+// triggered rules whose condition is false again
+func (n *Notifier) Evaluate(ctx context.Context, now time.Time) error {
+	rules, err := n.DB.ListWebhookRules(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	analytics := map[analyticsKey]*models.AdAnalyticsData{}
+	var errs []error
+	for _, rule := range *rules {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		key := analyticsKey{rule.AdID, rule.Period}
+		data, ok := analytics[key]
+		if !ok {
+			if data, err = n.DB.GetAdAnalytics(ctx, rule.AdID, rangeStart(rule.Period, now)); err != nil {
+				errs = append(errs, fmt.Errorf("webhook rule %s: %w", rule.ID, err))
+				continue
+			}
+			data.Period = rule.Period
+			if data.TotalClicks > 0 {
+				data.AveragePlaybackTime = float64(data.TotalPlaybackTime) / float64(data.TotalClicks)
+			}
+			if data.TotalClicksInRange > 0 {
+				data.AveragePlaybackTimeInRange = float64(data.TotalPlaybackTimeInRange) / float64(data.TotalClicksInRange)
+			}
+			analytics[key] = data
+		}
+
+		value, ok := metricValue(rule.Metric, data)
+		if !ok {
+			// averages without clicks are unknown rather than 0, the rule is left as is
+			continue
+		}
+		crossed := value > rule.Threshold
+		if rule.Operator == "below" {
+			crossed = value < rule.Threshold
+		}
+
+		switch {
+		case crossed && !rule.Triggered:
+			delivery, err := thresholdDelivery(rule, data, value, now)
+			if err == nil {
+				err = n.DB.TriggerWebhookRule(ctx, rule.ID, delivery)
+			}
+			if err != nil && err != database.ErrNotFound {
+				errs = append(errs, fmt.Errorf("webhook rule %s: %w", rule.ID, err))
+			}
+		case !crossed && rule.Triggered:
+			if err := n.DB.ResetWebhookRule(ctx, rule.ID); err != nil {
+				errs = append(errs, fmt.Errorf("webhook rule %s: %w", rule.ID, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rangeStart is the start of the in_range analytics of a period ending at now, as in GET /ads/analytics/{id}
+func rangeStart(period string, now time.Time) time.Time {
+	switch period {
+	case "minute":
+		return now.Add(-time.Minute)
+	case "day":
+		return now.Add(-24 * time.Hour)
+	case "week":
+		return now.Add(-7 * 24 * time.Hour)
+	case "month":
+		return now.AddDate(0, -1, 0)
+	default:
+		return now.Add(-time.Hour)
+	}
+}
+
+// metricValue returns a metric of the analytics, false for the averages of no clicks
+func metricValue(metric string, data *models.AdAnalyticsData) (float64, bool) {
+	switch metric {
+	case "total_clicks":
+		return float64(data.TotalClicks), true
+	case "total_playback_time":
+		return float64(data.TotalPlaybackTime), true
+	case "average_playback_time":
+		return data.AveragePlaybackTime, data.TotalClicks > 0
+	case "total_clicks_in_range":
+		return float64(data.TotalClicksInRange), true
+	case "total_playback_time_in_range":
+		return float64(data.TotalPlaybackTimeInRange), true
+	case "average_playback_time_in_range":
+		return data.AveragePlaybackTimeInRange, data.TotalClicksInRange > 0
+	}
+	return 0, false
+}
+
+// thresholdPayload is the JSON body of the deliveries sent when a rule triggers
+type thresholdPayload struct {
+	ID        string                  `json:"id"` // of the delivery, the same on every attempt
+	Event     string                  `json:"event"`
+	CreatedAt time.Time               `json:"created_at"`
+	Rule      models.WebhookRule      `json:"rule"`
+	Value     float64                 `json:"value"` // of the metric when the rule triggered
+	Analytics *models.AdAnalyticsData `json:"analytics"`
+}
+
+func thresholdDelivery(rule models.WebhookRule, data *models.AdAnalyticsData, value float64, now time.Time) (*models.WebhookDelivery, error) {
+	delivery := &models.WebhookDelivery{
+		ID:            uuid.New().String(),
+		WebhookID:     rule.WebhookID,
+		RuleID:        &rule.ID,
+		Event:         WebhookEventThreshold,
+		Status:        WebhookPending,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+	}
+	rule.Triggered = true
+	rule.LastTriggeredAt = &now
+	payload, err := json.Marshal(thresholdPayload{
+		ID: delivery.ID, Event: delivery.Event, CreatedAt: now, Rule: rule, Value: value, Analytics: data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %w", err)
+	}
+	delivery.Payload = payload
+	return delivery, nil
+}
+
+// Deliver attempts the due deliveries, rescheduling the failed ones until they run out of attempts
+func (n *Notifier) Deliver(ctx context.Context) error {
+	deliveries, err := n.DB.ListDueWebhookDeliveries(ctx, time.Now(), webhookBatchSize)
+	if err != nil || len(*deliveries) == 0 {
+		return err
+	}
+	webhooks, err := n.DB.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	byID := map[string]models.Webhook{}
+	for _, webhook := range *webhooks {
+		byID[webhook.ID] = webhook
+	}
+
+	opts := n.Options()
+	var errs []error
+	sent := 0
+	for _, delivery := range *deliveries {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		webhook, ok := byID[delivery.WebhookID]
+		if !ok {
+			// deleted since, along with its deliveries
+			continue
+		}
+
+		status, err := n.send(ctx, opts, webhook, delivery)
+		now := time.Now()
+		delivery.Attempts++
+		delivery.ResponseStatus = status
+		delivery.Error = nil
+		switch {
+		case err == nil:
+			delivery.Status = WebhookSucceeded
+			delivery.NextAttemptAt = nil
+			delivery.DeliveredAt = &now
+			sent++
+		case delivery.Attempts >= opts.MaxAttempts:
+			logger.ErrorLog("Webhook delivery %s failed after %d attempts: %v", delivery.ID, delivery.Attempts, err)
+			message := err.Error()
+			delivery.Status = WebhookFailed
+			delivery.NextAttemptAt = nil
+			delivery.Error = &message
+		default:
+			logger.DebugLog("Webhook delivery %s attempt %d failed: %v", delivery.ID, delivery.Attempts, err)
+			message := err.Error()
+			next := now.Add(opts.backoff(delivery.Attempts))
+			delivery.NextAttemptAt = &next
+			delivery.Error = &message
+		}
+		if err := n.DB.UpdateWebhookDelivery(ctx, &delivery); err != nil {
+			errs = append(errs, fmt.Errorf("webhook delivery %s: %w", delivery.ID, err))
+		}
+	}
+	logger.InfoLog("Sent %d of %d due webhook deliveries", sent, len(*deliveries))
+	return errors.Join(errs...)
+}
+
+// send posts a signed delivery, any 2xx response is a success. The status of the response
+// is returned when one was received.
+func (n *Notifier) send(ctx context.Context, opts WebhookOptions, webhook models.Webhook, delivery models.WebhookDelivery) (*int, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "video-ad-metrics-webhooks")
+	req.Header.Set("X-Webhook-ID", delivery.ID)
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", SignWebhook(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &resp.StatusCode, fmt.Errorf("responded with %s", resp.Status)
+	}
+	return &resp.StatusCode, nil
+}
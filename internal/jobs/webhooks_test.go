@@ -0,0 +1,342 @@
+package jobs
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+)
+
+const (
+	testAdID   = "6f1c3b0e-4b8f-4c39-9a51-6f0b0c1d2e3f"
+	testSecret = "whsec-test"
+)
+
+// webhookRepository keeps a webhook, its rules and deliveries in memory, the methods the tests don't use panic
+type webhookRepository struct {
+	database.Repository
+
+	mu         sync.Mutex
+	webhook    models.Webhook
+	rules      []models.WebhookRule
+	deliveries []models.WebhookDelivery
+	// analytics of testAdID
+	totalClicks int
+}
+
+func (r *webhookRepository) ListWebhookRules(ctx context.Context, webhookID string) (*[]models.WebhookRule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rules := append([]models.WebhookRule{}, r.rules...)
+	return &rules, nil
+}
+
+func (r *webhookRepository) GetAdAnalytics(ctx context.Context, adID string, rangeDate time.Time) (*models.AdAnalyticsData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if adID != testAdID {
+		return nil, database.ErrNotFound
+	}
+	return &models.AdAnalyticsData{AdID: adID, TotalClicks: r.totalClicks, TotalClicksInRange: r.totalClicks}, nil
+}
+
+func (r *webhookRepository) TriggerWebhookRule(ctx context.Context, id string, delivery *models.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, rule := range r.rules {
+		if rule.ID == id && !rule.Triggered {
+			r.rules[i].Triggered = true
+			r.rules[i].LastTriggeredAt = &delivery.CreatedAt
+			r.deliveries = append(r.deliveries, *delivery)
+			return nil
+		}
+	}
+	return database.ErrNotFound
+}
+
+func (r *webhookRepository) ResetWebhookRule(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, rule := range r.rules {
+		if rule.ID == id {
+			r.rules[i].Triggered = false
+		}
+	}
+	return nil
+}
+
+func (r *webhookRepository) ListDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) (*[]models.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	due := []models.WebhookDelivery{}
+	for _, delivery := range r.deliveries {
+		if delivery.Status == WebhookPending && !delivery.NextAttemptAt.After(now) && len(due) < limit {
+			due = append(due, delivery)
+		}
+	}
+	return &due, nil
+}
+
+func (r *webhookRepository) ListWebhooks(ctx context.Context) (*[]models.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &[]models.Webhook{r.webhook}, nil
+}
+
+func (r *webhookRepository) UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.deliveries {
+		if r.deliveries[i].ID == delivery.ID {
+			r.deliveries[i] = *delivery
+		}
+	}
+	return nil
+}
+
+func (r *webhookRepository) setTotalClicks(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.totalClicks = n
+}
+
+// makeDue moves the next attempt of the pending deliveries to now, instead of waiting for their backoff
+func (r *webhookRepository) makeDue() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for i := range r.deliveries {
+		if r.deliveries[i].NextAttemptAt != nil {
+			r.deliveries[i].NextAttemptAt = &now
+		}
+	}
+}
+
+func (r *webhookRepository) allDeliveries() []models.WebhookDelivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]models.WebhookDelivery{}, r.deliveries...)
+}
+
+// receivedWebhook is a request received by the test receiver
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is a webhook endpoint responding with statuses in turn, then with the last one
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	received []receivedWebhook
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.received = append(rc.received, receivedWebhook{header: r.Header.Clone(), body: body})
+	status := rc.statuses[min(len(rc.received), len(rc.statuses))-1]
+	w.WriteHeader(status)
+}
+
+func (rc *receiver) requests() []receivedWebhook {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]receivedWebhook{}, rc.received...)
+}
+
+// verifySignature checks a request the way a receiver does, with the shared secret
+func verifySignature(t *testing.T, request receivedWebhook) {
+	t.Helper()
+	timestamp := request.header.Get("X-Webhook-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Errorf("X-Webhook-Timestamp %q isn't a recent unix time", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(request.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := request.header.Get("X-Webhook-Signature"); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("X-Webhook-Signature is %q, want %q", got, want)
+	}
+}
+
+func newWebhookTest(t *testing.T, statuses ...int) (*Notifier, *webhookRepository, *receiver) {
+	t.Helper()
+	rc := &receiver{statuses: statuses}
+	server := httptest.NewServer(rc)
+	t.Cleanup(server.Close)
+
+	repo := &webhookRepository{
+		webhook: models.Webhook{ID: "webhook-1", URL: server.URL, Secret: testSecret},
+		rules: []models.WebhookRule{{
+			ID: "rule-1", WebhookID: "webhook-1", AdID: testAdID,
+			Metric: "total_clicks", Operator: "above", Threshold: 10, Period: "hour",
+		}},
+	}
+	notifier := NewNotifier(repo, WebhookOptions{MaxAttempts: 3, BackoffBase: time.Minute, BackoffMax: time.Hour})
+	notifier.Client = server.Client()
+	return notifier, repo, rc
+}
+
+func TestSignWebhook(t *testing.T) {
+	payload := []byte(`{"id":"delivery-1"}`)
+	signature := SignWebhook(testSecret, "1700000000", payload)
+	if signature != SignWebhook(testSecret, "1700000000", payload) {
+		t.Error("SignWebhook isn't deterministic")
+	}
+	for name, other := range map[string]string{
+		"secret":    SignWebhook("other-secret", "1700000000", payload),
+		"timestamp": SignWebhook(testSecret, "1700000001", payload),
+		"payload":   SignWebhook(testSecret, "1700000000", []byte(`{"id":"delivery-2"}`)),
+	} {
+		if other == signature {
+			t.Errorf("changing the %s doesn't change the signature", name)
+		}
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	verifySignature(t, receivedWebhook{
+		header: http.Header{"X-Webhook-Timestamp": {now}, "X-Webhook-Signature": {SignWebhook(testSecret, now, payload)}},
+		body:   payload,
+	})
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	notifier, repo, rc := newWebhookTest(t, http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK)
+	repo.setTotalClicks(11)
+
+	// the rule triggers and its delivery is attempted in the same run
+	if err := notifier.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var previousDelay time.Duration
+	for attempt, status := range []int{http.StatusServiceUnavailable, http.StatusInternalServerError} {
+		deliveries := repo.allDeliveries()
+		if len(deliveries) != 1 {
+			t.Fatalf("%d deliveries after attempt %d, want 1", len(deliveries), attempt+1)
+		}
+		delivery := deliveries[0]
+		if delivery.Status != WebhookPending || delivery.Attempts != attempt+1 || delivery.ResponseStatus == nil ||
+			*delivery.ResponseStatus != status || delivery.Error == nil || delivery.NextAttemptAt == nil {
+			t.Fatalf("delivery after attempt %d is %+v, want pending after a %d response", attempt+1, delivery, status)
+		}
+		delay := time.Until(*delivery.NextAttemptAt)
+		if want := notifier.Options().backoff(attempt + 1); delay <= previousDelay || delay > want || delay < want-time.Second {
+			t.Errorf("next attempt after attempt %d is in %v, want about %v", attempt+1, delay, want)
+		}
+		previousDelay = delay
+
+		// not due yet
+		if err := notifier.Deliver(ctx); err != nil {
+			t.Fatalf("Deliver: %v", err)
+		}
+		if sent := len(rc.requests()); sent != attempt+1 {
+			t.Fatalf("%d requests sent before the next attempt was due, want %d", sent, attempt+1)
+		}
+		repo.makeDue()
+		if err := notifier.Deliver(ctx); err != nil {
+			t.Fatalf("Deliver: %v", err)
+		}
+	}
+
+	delivery := repo.allDeliveries()[0]
+	if delivery.Status != WebhookSucceeded || delivery.Attempts != 3 || delivery.NextAttemptAt != nil || delivery.DeliveredAt == nil || delivery.Error != nil {
+		t.Errorf("delivery after the 200 response is %+v, want succeeded after 3 attempts", delivery)
+	}
+
+	requests := rc.requests()
+	if len(requests) != 3 {
+		t.Fatalf("receiver got %d requests, want 3", len(requests))
+	}
+	for i, request := range requests {
+		verifySignature(t, request)
+		if id := request.header.Get("X-Webhook-ID"); id != delivery.ID {
+			t.Errorf("request %d has X-Webhook-ID %q, want the delivery ID %s on every attempt", i, id, delivery.ID)
+		}
+		if event := request.header.Get("X-Webhook-Event"); event != WebhookEventThreshold {
+			t.Errorf("request %d has X-Webhook-Event %q, want %s", i, event, WebhookEventThreshold)
+		}
+		var payload thresholdPayload
+		if err := json.Unmarshal(request.body, &payload); err != nil {
+			t.Fatalf("decoding payload: %v", err)
+		}
+		if payload.ID != delivery.ID || payload.Rule.ID != "rule-1" || payload.Value != 11 || payload.Analytics == nil || payload.Analytics.TotalClicks != 11 {
+			t.Errorf("request %d has payload %+v, want rule-1 crossed at 11 clicks", i, payload)
+		}
+	}
+}
+
+func TestWebhookFailsAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	notifier, repo, rc := newWebhookTest(t, http.StatusInternalServerError)
+	repo.setTotalClicks(11)
+
+	if err := notifier.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	for range 5 {
+		repo.makeDue()
+		if err := notifier.Deliver(ctx); err != nil {
+			t.Fatalf("Deliver: %v", err)
+		}
+	}
+
+	if sent := len(rc.requests()); sent != 3 {
+		t.Errorf("receiver got %d requests, want MaxAttempts 3", sent)
+	}
+	delivery := repo.allDeliveries()[0]
+	if delivery.Status != WebhookFailed || delivery.Attempts != 3 || delivery.NextAttemptAt != nil || delivery.Error == nil ||
+		delivery.ResponseStatus == nil || *delivery.ResponseStatus != http.StatusInternalServerError {
+		t.Errorf("delivery is %+v, want failed after 3 attempts answered with 500", delivery)
+	}
+}
+
+func TestWebhookRuleFiresOncePerCrossing(t *testing.T) {
+	ctx := context.Background()
+	notifier, repo, rc := newWebhookTest(t, http.StatusOK)
+
+	steps := []struct {
+		totalClicks int
+		deliveries  int
+		triggered   bool
+	}{
+		{totalClicks: 5, deliveries: 0, triggered: false},
+		// crosses the threshold
+		{totalClicks: 11, deliveries: 1, triggered: true},
+		// still above, no new delivery
+		{totalClicks: 15, deliveries: 1, triggered: true},
+		// back below, re-armed
+		{totalClicks: 8, deliveries: 1, triggered: false},
+		// crosses again
+		{totalClicks: 12, deliveries: 2, triggered: true},
+	}
+	for i, step := range steps {
+		repo.setTotalClicks(step.totalClicks)
+		if err := notifier.Run(ctx); err != nil {
+			t.Fatalf("step %d: Run: %v", i, err)
+		}
+		if deliveries := len(repo.allDeliveries()); deliveries != step.deliveries {
+			t.Errorf("step %d at %d clicks: %d deliveries, want %d", i, step.totalClicks, deliveries, step.deliveries)
+		}
+		if sent := len(rc.requests()); sent != step.deliveries {
+			t.Errorf("step %d at %d clicks: receiver got %d requests, want %d", i, step.totalClicks, sent, step.deliveries)
+		}
+		if triggered := repo.rules[0].Triggered; triggered != step.triggered {
+			t.Errorf("step %d at %d clicks: rule triggered is %v, want %v", i, step.totalClicks, triggered, step.triggered)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/logger"
+)
+
+// Component is a part of the service started and stopped by the Manager
+type Component struct {
+	Name string
+	// Start must not block, long running work goes in a goroutine (see Background). Components
+	// without Start, like connections opened while wiring the service, count as started when added.
+	Start func(ctx context.Context) error
+	// Stop releases the component, giving up when ctx is done. Optional.
+	Stop func(ctx context.Context) error
+	// StopTimeout bounds Stop, the Manager's default applies when zero
+	StopTimeout time.Duration
+}
+
+type component struct {
+	Component
+	started bool
+}
+
+// Manager starts components in the order they were added and stops them in reverse order,
+// so a component can rely on every component added before it while it runs and stops
+type Manager struct {
+	components         []*component
+	defaultStopTimeout time.Duration
+	failed             chan error
+}
+
+// NewManager creates a new Manager giving components without their own timeout defaultStopTimeout to stop
+func NewManager(defaultStopTimeout time.Duration) *Manager {
+	return &Manager{defaultStopTimeout: defaultStopTimeout, failed: make(chan error, 1)}
+}
+
+// Add registers a component, it is started after and stopped before the ones already added
+func (m *Manager) Add(c Component) {
+	m.components = append(m.components, &component{Component: c, started: c.Start == nil})
+}
+
+// Fail makes Run stop every component, for components failing after they started
+func (m *Manager) Fail(err error) {
+	select {
+	case m.failed <- err:
+	default:
+	}
+}
+
+// Run starts every component, then waits until ctx is done or a component fails, and stops
+// every started component. It returns the failure which stopped the service, if any.
+func (m *Manager) Run(ctx context.Context) error {
+	for _, c := range m.components {
+		if c.started {
+			continue
+		}
+		if err := c.Start(context.WithoutCancel(ctx)); err != nil {
+			return m.Shutdown(fmt.Errorf("failed to start %s: %w", c.Name, err))
+		}
+		c.started = true
+	}
+
+	select {
+	case <-ctx.Done():
+		return m.Shutdown(nil)
+	case err := <-m.failed:
+		return m.Shutdown(err)
+	}
+}
+
+// Shutdown stops every started component in reverse order, each within its stop timeout, and
+// returns cause joined with the errors of components failing to stop. Run calls it once ctx is
+// done, call it directly when wiring the service fails before Run.
+func (m *Manager) Shutdown(cause error) error {
+	errs := []error{cause}
+	for i := len(m.components) - 1; i >= 0; i-- {
+		c := m.components[i]
+		if !c.started {
+			continue
+		}
+		c.started = false
+		if err := m.stop(c.Component); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) stop(c Component) error {
+	if c.Stop == nil {
+		return nil
+	}
+	timeout := c.StopTimeout
+	if timeout <= 0 {
+		timeout = m.defaultStopTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	logger.InfoLog("Stopping %s", c.Name)
+	start := time.Now()
+	if err := c.Stop(ctx); err != nil {
+		logger.ErrorLog("Failed to stop %s: %v", c.Name, err)
+		return fmt.Errorf("failed to stop %s: %w", c.Name, err)
+	}
+	logger.InfoLog("Stopped %s in %s", c.Name, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// Background adapts a blocking run func, which returns once its ctx is cancelled, to a Component.
+// Stopping cancels run's ctx and waits for it to return.
+func Background(name string, run func(ctx context.Context), stopTimeout time.Duration) Component {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+	return Component{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			ctx, cancel = context.WithCancel(ctx)
+			go func() {
+				defer close(done)
+				run(ctx)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return fmt.Errorf("%s did not stop in time", name)
+			}
+		},
+		StopTimeout: stopTimeout,
+	}
+}
+
+// HTTPServer serves server's handler on its address. Stopping closes the listener and
+// waits for the in-flight requests to complete, failing when they don't in time.
+func HTTPServer(server *http.Server, fail func(error), stopTimeout time.Duration) Component {
+	var inFlight atomic.Int64
+	handler := server.Handler
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		handler.ServeHTTP(w, r)
+	})
+
+	return Component{
+		Name: "http server",
+		Start: func(ctx context.Context) error {
+			// listen right away so a port already in use fails the startup
+			listener, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				return err
+			}
+			go func() {
+				if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					fail(fmt.Errorf("http server failed: %w", err))
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			logger.InfoLog("Draining %d in-flight requests", inFlight.Load())
+			if err := server.Shutdown(ctx); err != nil {
+				// abandon the remaining requests rather than keeping the process alive
+				pending := inFlight.Load()
+				server.Close()
+				return fmt.Errorf("%d requests still in flight: %w", pending, err)
+			}
+			return nil
+		},
+		StopTimeout: stopTimeout,
+	}
+}
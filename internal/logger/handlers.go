@@ -0,0 +1,117 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// levelSplitHandler sends errors to one handler (stderr) and everything else to another (stdout)
+type levelSplitHandler struct {
+	out slog.Handler
+	err slog.Handler
+}
+
+func (h *levelSplitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.out.Enabled(ctx, level)
+}
+
+func (h *levelSplitHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= slog.LevelError {
+		return h.err.Handle(ctx, record)
+	}
+	return h.out.Handle(ctx, record)
+}
+
+func (h *levelSplitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelSplitHandler{out: h.out.WithAttrs(attrs), err: h.err.WithAttrs(attrs)}
+}
+
+func (h *levelSplitHandler) WithGroup(name string) slog.Handler {
+	return &levelSplitHandler{out: h.out.WithGroup(name), err: h.err.WithGroup(name)}
+}
+
+// prettyHandler writes colored, human readable lines for development:
+// LEVEL: 2006-01-02 15:04:05 message key=value ...
+type prettyHandler struct {
+	opts   *slog.HandlerOptions
+	mu     *sync.Mutex
+	w      io.Writer
+	attrs  []slog.Attr
+	prefix string
+}
+
+func newPrettyHandler(w io.Writer, opts *slog.HandlerOptions) *prettyHandler {
+	return &prettyHandler{opts: opts, mu: &sync.Mutex{}, w: w}
+}
+
+func (h *prettyHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.opts.Level.Level()
+}
+
+func (h *prettyHandler) Handle(_ context.Context, record slog.Record) error {
+	level := LevelInfo
+	switch {
+	case record.Level >= slog.LevelError:
+		level = LevelError
+	case record.Level < slog.LevelInfo:
+		level = LevelDebug
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s%s:%s %s %s", level.Color(), level, ColorReset, record.Time.Format("2006-01-02 15:04:05"), record.Message)
+	for _, attr := range h.attrs {
+		writeAttr(&buf, "", attr)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		writeAttr(&buf, h.prefix, attr)
+		return true
+	})
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+func writeAttr(buf *bytes.Buffer, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		for _, groupAttr := range attr.Value.Group() {
+			writeAttr(buf, prefix+attr.Key+".", groupAttr)
+		}
+		return
+	}
+	value := attr.Value.String()
+	if strings.ContainsAny(value, " \"=") {
+		value = fmt.Sprintf("%q", value)
+	}
+	fmt.Fprintf(buf, " %s%s=%s", prefix, attr.Key, value)
+}
+
+func (h *prettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, attr := range attrs {
+		attr.Key = h.prefix + attr.Key
+		clone.attrs = append(clone.attrs, attr)
+	}
+	return &clone
+}
+
+func (h *prettyHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.prefix = h.prefix + name + "."
+	return &clone
+}
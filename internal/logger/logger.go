@@ -1,10 +1,16 @@
 package logger
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"math/rand/v2"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
@@ -24,6 +30,7 @@ const (
 
 var logLevelNames = []string{"DEBUG", "INFO", "ERROR"}
 var logLevelColors = []LogColor{ColorYellow, ColorGreen, ColorRed}
+var logLevelSlog = []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelError}
 
 // Log levels
 const (
@@ -41,107 +48,227 @@ func (l LogLevel) Color() LogColor {
 	return logLevelColors[l]
 }
 
-func getLogLevelFromEnv() LogLevel {
-	level := os.Getenv("LOG_LEVEL")
-	switch level {
-	case "DEBUG", "debug":
-		return LevelDebug
-	case "INFO", "info":
-		return LevelInfo
-	case "ERROR", "error":
-		return LevelError
+// Slog returns the matching log/slog level
+func (l LogLevel) Slog() slog.Level {
+	return logLevelSlog[l]
+}
+
+// ParseLogLevel returns the log level for its name, case insensitive
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "error":
+		return LevelError, nil
 	}
-	return LevelInfo
+	return LevelInfo, fmt.Errorf("unknown log level %q", level)
 }
 
-// requestLogger is a structured logger for HTTP requests
-type requestLogger struct {
-	infoLogger  *log.Logger
-	errorLogger *log.Logger
-	debugLogger *log.Logger
-	logLevel    LogLevel
-}
-
-var RequestLogger *requestLogger
-
-// New creates a new Request Logger instance
-func SetupRequestLogger() {
-	RequestLogger = &requestLogger{
-		infoLogger:  log.New(os.Stdout, "", 0),
-		errorLogger: log.New(os.Stderr, "", 0),
-		debugLogger: log.New(os.Stdout, "", 0),
-		logLevel:    getLogLevelFromEnv(),
+// Log formats
+const (
+	FormatText = "text" // colored, human readable lines for development
+	FormatJSON = "json" // one JSON object per line for log pipelines
+)
+
+var (
+	level  = new(slog.LevelVar)
+	format = FormatText
+)
+
+func init() {
+	SetAccessLogSampleRate(1)
+	// usable before Setup is called, e.g. while loading the config
+	slog.SetDefault(newLogger(FormatText))
+}
+
+// Setup configures the format and minimum level of every log written by the service.
+// Errors are written to stderr, everything else to stdout.
+func Setup(logFormat string, logLevel LogLevel) {
+	format = logFormat
+	SetLevel(logLevel)
+	slog.SetDefault(newLogger(logFormat))
+}
+
+// SetLevel changes the minimum level logged, it is safe to call while serving requests
+func SetLevel(logLevel LogLevel) {
+	level.Set(logLevel.Slog())
+}
+
+func newLogger(logFormat string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if logFormat == FormatJSON {
+		return slog.New(&levelSplitHandler{
+			out: slog.NewJSONHandler(os.Stdout, opts),
+			err: slog.NewJSONHandler(os.Stderr, opts),
+		})
+	}
+	return slog.New(&levelSplitHandler{
+		out: newPrettyHandler(os.Stdout, opts),
+		err: newPrettyHandler(os.Stderr, opts),
+	})
+}
+
+// requestAttrs collects fields added by handlers while serving a request (e.g. ad_id),
+// shared by pointer so middlewares outside the handler see them too
+type requestAttrs struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+type requestAttrsKey struct{}
+
+// AddAttrs adds fields to every log of the request, including its access log
+func AddAttrs(r *http.Request, attrs ...slog.Attr) {
+	if bag, ok := r.Context().Value(requestAttrsKey{}).(*requestAttrs); ok {
+		bag.mu.Lock()
+		bag.attrs = append(bag.attrs, attrs...)
+		bag.mu.Unlock()
+	}
+}
+
+// SetAdID adds the ad the request is about to every log of the request
+func SetAdID(r *http.Request, adID string) {
+	AddAttrs(r, slog.String("ad_id", adID))
+}
+
+// RequestAttrs returns the request fields common to every log of the request
+func RequestAttrs(r *http.Request) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("trace_id", apihelpers.GetTraceId(r)),
+		slog.String("request_id", apihelpers.GetRequestId(r)),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+	}
+	if bag, ok := r.Context().Value(requestAttrsKey{}).(*requestAttrs); ok {
+		bag.mu.Lock()
+		attrs = append(attrs, bag.attrs...)
+		bag.mu.Unlock()
 	}
+	return attrs
 }
 
-// formatStructuredLog formats a structured log message with the provided information
-func formatStructuredLog(level LogLevel, traceID, method, url, message string) string {
-	color := level.Color()
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	return fmt.Sprintf("%s%s:%s %s %s %s %s %s",
-		color, level, ColorReset,
-		traceID, timestamp, method, url, message)
+// requestLogger is a structured logger for HTTP requests
+type requestLogger struct{}
+
+var RequestLogger = &requestLogger{}
+
+func (l *requestLogger) log(r *http.Request, level LogLevel, message string, args ...any) {
+	slog.LogAttrs(r.Context(), level.Slog(), fmt.Sprintf(message, args...), RequestAttrs(r)...)
 }
 
 // Info logs an informational message for an HTTP request
 func (l *requestLogger) Info(r *http.Request, message string, args ...any) {
-	if l.logLevel > LevelInfo {
-		return
-	}
-	logMessage := formatStructuredLog(LevelInfo, apihelpers.GetTraceId(r), r.Method, r.URL.String(), fmt.Sprintf(message, args...))
-	l.infoLogger.Println(logMessage)
+	l.log(r, LevelInfo, message, args...)
 }
 
 // Error logs an error message for an HTTP request
 func (l *requestLogger) Error(r *http.Request, message string, args ...any) {
-	if l.logLevel > LevelError {
-		return
-	}
-	logMessage := formatStructuredLog(LevelError, apihelpers.GetTraceId(r), r.Method, r.URL.String(), fmt.Sprintf(message, args...))
-	l.errorLogger.Println(logMessage)
+	l.log(r, LevelError, message, args...)
 }
 
 // Debug logs a debug message for an HTTP request
 func (l *requestLogger) Debug(r *http.Request, message string, args ...any) {
-	if l.logLevel > LevelDebug {
-		return
-	}
-	logMessage := formatStructuredLog(LevelDebug, apihelpers.GetTraceId(r), r.Method, r.URL.String(), fmt.Sprintf(message, args...))
-	l.debugLogger.Println(logMessage)
+	l.log(r, LevelDebug, message, args...)
 }
 
-// LoggingMiddleware wraps HandleFunc with logging
-func (l *requestLogger) LoggingMiddleware(next http.Handler) http.Handler {
+// AccessLogMiddleware logs one line per completed request with its status, response size,
+// latency, client IP and user agent. Successful requests are sampled (see SetAccessLogSampleRate),
+// failed ones are always logged, server errors at error level. Requests aborted by a panic are
+// logged as 500s with aborted set.
+func (l *requestLogger) AccessLogMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		l.Info(r, "")
+		start := time.Now()
+		r = r.WithContext(context.WithValue(r.Context(), requestAttrsKey{}, &requestAttrs{}))
+		recorder := apihelpers.NewResponseRecorder(w)
+
+		// logged from a defer so that requests aborted by a panic (e.g. http.ErrAbortHandler
+		// cutting an export short) are logged too, as server errors
+		completed := false
+		defer func() {
+			status := recorder.StatusCode
+			if !completed {
+				status = http.StatusInternalServerError
+			}
+			logLevel := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				logLevel = slog.LevelError
+			} else if status < http.StatusBadRequest && rand.Float64() >= accessLogSampleRate() {
+				return
+			}
+
+			attrs := append(RequestAttrs(r),
+				slog.String("route", apihelpers.GetRoute(r)),
+				slog.Int("status", status),
+				slog.Int64("size", recorder.Size),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("client_ip", apihelpers.ClientIP(r)),
+				slog.String("user_agent", r.UserAgent()),
+			)
+			if !completed {
+				attrs = append(attrs, slog.Bool("aborted", true))
+			}
+			slog.LogAttrs(r.Context(), logLevel, "request completed", attrs...)
+		}()
+
 		// Call the next handler
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(recorder, r)
+		completed = true
 	})
 }
 
+var sampleRate atomic.Uint64
+
+// SetAccessLogSampleRate sets the fraction (0 to 1) of successful requests written to the access log
+func SetAccessLogSampleRate(rate float64) {
+	sampleRate.Store(math.Float64bits(rate))
+}
+
+func accessLogSampleRate() float64 {
+	return math.Float64frombits(sampleRate.Load())
+}
+
+// ContextLog logs a structured message outside of HTTP handlers (e.g. from the database layer),
+// with the trace and request IDs of ctx when it belongs to a request or a traced job
+func ContextLog(ctx context.Context, level LogLevel, message string, attrs ...slog.Attr) {
+	if traceID := apihelpers.TraceIDFromContext(ctx); traceID != "" {
+		attrs = append(attrs, slog.String("trace_id", traceID))
+	}
+	if requestID := apihelpers.RequestIDFromContext(ctx); requestID != "" {
+		attrs = append(attrs, slog.String("request_id", requestID))
+	}
+	slog.LogAttrs(ctx, level.Slog(), message, attrs...)
+}
+
 // Non-structured logging functions for scripts and non-request scenarios
 
 // InfoLog logs a simple informational message without structure
 func InfoLog(message string, args ...any) {
-	fmt.Printf("%s%s:%s %s\n", ColorGreen, LevelInfo, ColorReset, fmt.Sprintf(message, args...))
+	slog.Info(fmt.Sprintf(message, args...))
 }
 
 // ErrorLog logs a simple error message without structure
 func ErrorLog(message string, args ...any) {
-	fmt.Printf("%s%s:%s %s\n", ColorRed, LevelError, ColorReset, fmt.Sprintf(message, args...))
+	slog.Error(fmt.Sprintf(message, args...))
 }
 
 // FatalLog logs a simple fatal message without structure that will exit the program
 func FatalLog(message string, args ...any) {
-	fmt.Printf("%s%s:%s %s\n", ColorRed, LevelError, ColorReset, fmt.Sprintf(message, args...))
+	slog.Error(fmt.Sprintf(message, args...))
 	os.Exit(1)
 }
 
 // DebugLog logs a simple debug message without structure
 func DebugLog(message string, args ...any) {
-	fmt.Printf("%s%s:%s %s\n", ColorYellow, LevelDebug, ColorReset, fmt.Sprintf(message, args...))
+	slog.Debug(fmt.Sprintf(message, args...))
 }
 
+// LogColored prints a colored banner in text format, and a plain info log in JSON format
 func LogColored(color LogColor, message string, args ...any) {
+	if format == FormatJSON {
+		slog.Info(strings.TrimSpace(fmt.Sprintf(message, args...)))
+		return
+	}
 	fmt.Printf("%s%s%s\n", color, fmt.Sprintf(message, args...), ColorReset)
 }
@@ -1,27 +1,68 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
+
+	"github.com/lib/pq"
 )
 
+// Fields tagged openapi:"readonly" are set by the server and ignored in request bodies,
+// fields tagged openapi:"optional" may be left out of request bodies
+
 // Ad represents a video advertisement
 type Ad struct {
-	ID          string    `json:"id" db:"id"`
+	ID          string    `json:"id" db:"id" openapi:"readonly"`
 	Name        string    `json:"name" db:"name"`
-	Description string    `json:"description" db:"description"`
+	Description string    `json:"description" db:"description" openapi:"optional"`
 	ImageURL    string    `json:"image_url" db:"image_url"`
 	TargetURL   string    `json:"target_url" db:"target_url"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	Status      string    `json:"status" db:"status" openapi:"optional"`     // active, paused or archived, active by default
+	Campaign    string    `json:"campaign" db:"campaign" openapi:"optional"` // campaign the ad belongs to, none when empty
+	CreatedAt   time.Time `json:"created_at" db:"created_at" openapi:"readonly"`
+}
+
+// AdUpdate changes the fields of an ad which are set, the others are kept
+type AdUpdate struct {
+	Status   *string `json:"status,omitempty"`
+	Campaign *string `json:"campaign,omitempty"` // empty to remove the ad from its campaign
+}
+
+// AdStats are the all time aggregate stats of an ad
+type AdStats struct {
+	TotalClicks       int `json:"total_clicks" db:"total_clicks"`
+	TotalPlaybackTime int `json:"total_playback_time" db:"total_playback_time"` // in seconds
+}
+
+// SearchMatch tells how an ad matches a search
+type SearchMatch struct {
+	Rank float64 `json:"rank" db:"rank"` // higher is more relevant
+	// excerpt of the description, HTML escaped, with the matched words between <mark> and </mark>
+	Snippet string `json:"snippet" db:"snippet"`
+}
+
+// ListedAd is an ad in a listing, with its stats when they were requested
+// and how it matches the search when searching
+type ListedAd struct {
+	Ad
+	Analytics *AdStats     `json:"analytics,omitempty" db:"analytics"`
+	Search    *SearchMatch `json:"search,omitempty" db:"search"`
 }
 
 // Click represents a user interaction with an ad
 type Click struct {
-	ID           string    `json:"id" db:"id"`
+	ID           string    `json:"id" db:"id" openapi:"readonly"`
 	AdID         string    `json:"ad_id" db:"ad_id"`
-	Timestamp    time.Time `json:"timestamp" db:"timestamp"`
-	IPAddress    string    `json:"ip_address" db:"ip_address"`
-	PlaybackTime int       `json:"playback_time" db:"playback_time"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	Timestamp    time.Time `json:"timestamp" db:"timestamp" openapi:"optional"`   // defaults to now minus the playback time
+	IPAddress    string    `json:"ip_address" db:"ip_address" openapi:"optional"` // defaults to the caller's address
+	PlaybackTime int       `json:"playback_time" db:"playback_time"`              // in seconds
+	CreatedAt    time.Time `json:"created_at" db:"created_at" openapi:"readonly"`
+}
+
+// ListedClick is a click read back from either clicks or archived_clicks
+type ListedClick struct {
+	Click
+	Archived bool `json:"archived" db:"archived"` // whether it was moved to archived_clicks
 }
 
 // ArchivedClick has the same structure as Click but is stored in a separate table
@@ -80,3 +121,129 @@ type AdAnalyticsData struct {
 	TotalPlaybackTimeInRange   int     `json:"total_playback_time_in_range" db:"total_playback_time_in_range"`
 	AveragePlaybackTimeInRange float64 `json:"average_playback_time_in_range"`
 }
+
+// JobRun records a single execution of a background job
+type JobRun struct {
+	ID          string     `json:"id" db:"id"`
+	JobName     string     `json:"job_name" db:"name"`
+	Status      string     `json:"status" db:"status"`             // running, succeeded, failed
+	TriggeredBy string     `json:"triggered_by" db:"triggered_by"` // schedule, manual
+	Instance    string     `json:"instance" db:"instance"`
+	Error       *string    `json:"error,omitempty" db:"error"`
+	StartedAt   time.Time  `json:"started_at" db:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+// JobState holds the persisted state of a background job shared by all replicas
+type JobState struct {
+	Name      string    `json:"name" db:"name"`
+	Paused    bool      `json:"paused" db:"paused"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Lease represents leadership of a singleton role held by one replica until it expires
+type Lease struct {
+	Name       string    `json:"name" db:"name"`
+	Holder     string    `json:"holder" db:"holder"`
+	AcquiredAt time.Time `json:"acquired_at" db:"acquired_at"`
+	RenewedAt  time.Time `json:"renewed_at" db:"renewed_at"`
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// ReportSpec defines a report generated on a schedule over the clicks of the previous period
+type ReportSpec struct {
+	ID   string `json:"id" db:"id" openapi:"readonly"`
+	Name string `json:"name" db:"name"`
+	// ads covered by the report, all ads when empty
+	AdIDs pq.StringArray `json:"ad_ids" db:"ad_ids" openapi:"optional"`
+	// campaigns whose ads are covered by the report, all campaigns when empty. With ad_ids
+	// too, the report covers the listed ads which belong to one of these campaigns.
+	Campaigns pq.StringArray `json:"campaigns" db:"campaigns" openapi:"optional"`
+	// clicks, playback_time or average_playback_time, all of them by default
+	Metrics pq.StringArray `json:"metrics" db:"metrics" openapi:"optional"`
+	// range covered by each report: the previous day, week (from Monday) or month, in UTC
+	Period string `json:"period" db:"period"`
+	// ad and/or one of day, week or month, a single row with the totals when empty
+	GroupBy pq.StringArray `json:"group_by" db:"group_by" openapi:"optional"`
+	// csv, html or json, csv by default
+	Format string `json:"format" db:"format" openapi:"optional"`
+	// cron expression, by default shortly after the end of each period
+	Schedule string `json:"schedule" db:"schedule" openapi:"optional"`
+	// none, directory or webhook, none by default (reports are only stored)
+	Delivery   string     `json:"delivery" db:"delivery" openapi:"optional"`
+	WebhookURL *string    `json:"webhook_url,omitempty" db:"webhook_url"` // with webhook delivery only
+	NextRunAt  time.Time  `json:"next_run_at" db:"next_run_at" openapi:"readonly"`
+	LastRunAt  *time.Time `json:"last_run_at" db:"last_run_at" openapi:"readonly"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at" openapi:"readonly"`
+}
+
+// GeneratedReport is a report generated from a ReportSpec, its file is served by GET /reports/{id}
+type GeneratedReport struct {
+	ID          string    `json:"id" db:"id"`
+	SpecID      string    `json:"spec_id" db:"spec_id"`
+	Filename    string    `json:"filename" db:"filename"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Size        int       `json:"size" db:"size"` // in bytes
+	Content     []byte    `json:"-" db:"content"`
+	PeriodStart time.Time `json:"period_start" db:"period_start"`
+	PeriodEnd   time.Time `json:"period_end" db:"period_end"`
+	// none, pending, delivered or failed
+	DeliveryStatus string    `json:"delivery_status" db:"delivery_status"`
+	DeliveryError  *string   `json:"delivery_error,omitempty" db:"delivery_error"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// ReportRow holds the metrics of a group of clicks, AdID and Bucket are set when grouping by them
+type ReportRow struct {
+	AdID         *string    `db:"ad_id"`
+	AdName       *string    `db:"ad_name"`
+	Bucket       *time.Time `db:"bucket"`
+	Clicks       int        `db:"clicks"`
+	PlaybackTime int        `db:"playback_time"`
+}
+
+// Webhook is an endpoint notified with signed JSON payloads when one of its rules triggers
+type Webhook struct {
+	ID          string  `json:"id" db:"id" openapi:"readonly"`
+	URL         string  `json:"url" db:"url"`
+	Description *string `json:"description,omitempty" db:"description"`
+	// key of the HMAC-SHA256 signature of the payloads, generated when left out and only returned on creation
+	Secret    string    `json:"secret,omitempty" db:"secret"`
+	CreatedAt time.Time `json:"created_at" db:"created_at" openapi:"readonly"`
+}
+
+// WebhookRule notifies its webhook when a metric of an ad's analytics crosses a threshold.
+// It triggers once when its condition becomes true and again only after it was false.
+type WebhookRule struct {
+	ID        string `json:"id" db:"id" openapi:"readonly"`
+	WebhookID string `json:"webhook_id" db:"webhook_id" openapi:"readonly"`
+	AdID      string `json:"ad_id" db:"ad_id"`
+	// an AdAnalyticsData metric, e.g. total_clicks or average_playback_time_in_range
+	Metric string `json:"metric" db:"metric"`
+	// above or below
+	Operator  string  `json:"operator" db:"operator"`
+	Threshold float64 `json:"threshold" db:"threshold"`
+	// range of the *_in_range metrics: minute, hour, day, week or month, hour by default
+	Period          string     `json:"period" db:"period" openapi:"optional"`
+	Triggered       bool       `json:"triggered" db:"triggered" openapi:"readonly"`
+	LastTriggeredAt *time.Time `json:"last_triggered_at" db:"last_triggered_at" openapi:"readonly"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at" openapi:"readonly"`
+}
+
+// WebhookDelivery is a notification sent to a webhook, with the outcome of its last attempt
+type WebhookDelivery struct {
+	ID        string          `json:"id" db:"id"`
+	WebhookID string          `json:"webhook_id" db:"webhook_id"`
+	RuleID    *string         `json:"rule_id" db:"rule_id"`
+	Event     string          `json:"event" db:"event"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	// pending, succeeded or failed (after its last attempt)
+	Status        string     `json:"status" db:"status"`
+	Attempts      int        `json:"attempts" db:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at" db:"next_attempt_at"` // while pending
+	// HTTP status of the last attempt, nil when no response was received
+	ResponseStatus *int       `json:"response_status" db:"response_status"`
+	Error          *string    `json:"error,omitempty" db:"error"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at" db:"delivered_at"`
+}
@@ -0,0 +1,105 @@
+package monitoring
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// catalog records the metrics defined in this package while registering them,
+// so generated rules and dashboards can be checked against them (see CheckExpression)
+type catalog struct {
+	prometheus.Registerer
+	names map[string]bool
+}
+
+var (
+	metrics = &catalog{Registerer: prometheus.DefaultRegisterer, names: map[string]bool{}}
+	factory = promauto.With(metrics)
+)
+
+// Register records the names of the series exposed by collector and registers it
+func (c *catalog) Register(collector prometheus.Collector) error {
+	suffixes := []string{""}
+	switch collector.(type) {
+	case prometheus.Histogram, *prometheus.HistogramVec:
+		suffixes = []string{"_bucket", "_sum", "_count"}
+	}
+
+	descs := make(chan *prometheus.Desc)
+	go func() {
+		collector.Describe(descs)
+		close(descs)
+	}()
+	for desc := range descs {
+		// Desc doesn't expose its name other than through String
+		if match := descName.FindStringSubmatch(desc.String()); match != nil {
+			for _, suffix := range suffixes {
+				c.names[match[1]+suffix] = true
+			}
+		}
+	}
+	return c.Registerer.Register(collector)
+}
+
+// MustRegister registers collectors through Register, panicking on errors
+func (c *catalog) MustRegister(collectors ...prometheus.Collector) {
+	for _, collector := range collectors {
+		if err := c.Register(collector); err != nil {
+			panic(err)
+		}
+	}
+}
+
+var descName = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+// MetricNames returns the sorted names of the series exposed by the metrics of this package,
+// histograms by their _bucket, _sum and _count series
+func MetricNames() []string {
+	names := make([]string, 0, len(metrics.names))
+	for name := range metrics.names {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+var (
+	exprString   = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+	exprMatchers = regexp.MustCompile(`\{[^}]*\}`)
+	exprRange    = regexp.MustCompile(`\[[^\]]*\]`)
+	exprGrouping = regexp.MustCompile(`\b(?:by|without|on|ignoring|group_left|group_right)\s*\([^)]*\)`)
+	exprName     = regexp.MustCompile(`[A-Za-z_:][A-Za-z0-9_:]*`)
+	exprKeywords = []string{"and", "or", "unless", "bool", "offset", "by", "without", "on", "ignoring", "group_left", "group_right", "inf", "nan"}
+)
+
+// CheckExpression fails when a PromQL expression selects a series which is neither exposed by this
+// package nor one of the recorded series. Label names and function names aren't checked.
+func CheckExpression(expr string, recorded map[string]bool) error {
+	stripped := exprString.ReplaceAllString(expr, " ")
+	stripped = exprMatchers.ReplaceAllString(stripped, " ")
+	stripped = exprRange.ReplaceAllString(stripped, " ")
+	stripped = exprGrouping.ReplaceAllString(stripped, " ")
+
+	var unknown []string
+	for _, loc := range exprName.FindAllStringIndex(stripped, -1) {
+		name := stripped[loc[0]:loc[1]]
+		// skip function calls, keywords and the exponent of numbers like 1e-3
+		if strings.HasPrefix(strings.TrimLeft(stripped[loc[1]:], " "), "(") ||
+			slices.Contains(exprKeywords, strings.ToLower(name)) ||
+			(loc[0] > 0 && stripped[loc[0]-1] >= '0' && stripped[loc[0]-1] <= '9') {
+			continue
+		}
+		if !metrics.names[name] && !recorded[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown metrics %s in %q", strings.Join(unknown, ", "), expr)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package monitoring
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Dashboard is the subset of the Grafana dashboard JSON model used by the generated dashboard
+type Dashboard struct {
+	UID           string     `json:"uid"`
+	Title         string     `json:"title"`
+	Tags          []string   `json:"tags"`
+	Timezone      string     `json:"timezone"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Refresh       string     `json:"refresh"`
+	Time          TimeRange  `json:"time"`
+	Templating    Templating `json:"templating"`
+	Panels        []Panel    `json:"panels"`
+}
+
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type Templating struct {
+	List []Variable `json:"list"`
+}
+
+type Variable struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Query string `json:"query"`
+}
+
+type Panel struct {
+	ID          int         `json:"id"`
+	Title       string      `json:"title"`
+	Type        string      `json:"type"`
+	Description string      `json:"description,omitempty"`
+	GridPos     GridPos     `json:"gridPos"`
+	Datasource  Datasource  `json:"datasource"`
+	FieldConfig FieldConfig `json:"fieldConfig"`
+	Targets     []Target    `json:"targets"`
+}
+
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type Datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type FieldConfig struct {
+	Defaults FieldDefaults `json:"defaults"`
+}
+
+type FieldDefaults struct {
+	Unit string `json:"unit"`
+}
+
+type Target struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+}
+
+type panel struct {
+	Title       string
+	Type        string
+	Unit        string
+	Description string
+	Targets     []Target
+}
+
+// NewDashboard builds the service's Grafana dashboard, panels use the series recorded by Rules
+func NewDashboard() Dashboard {
+	panels := []panel{
+		{
+			Title: "Click ingest availability (30m)", Type: "stat", Unit: "percentunit",
+			Description: "Share of POST /ads/clicks requests not failing with a server error",
+			Targets:     []Target{{Expr: `1 - job:click_ingest_errors:ratio_rate30m`}},
+		},
+		{
+			Title: "Click ingest p99 latency", Type: "stat", Unit: "s",
+			Targets: []Target{{Expr: `job:http_request_duration_seconds:p99_rate5m{method="POST",path="/ads/clicks"}`}},
+		},
+		{
+			Title: "Clicks logged", Type: "stat", Unit: "ops",
+			Targets: []Target{{Expr: `sum(rate(clicks_total{status="logged"}[5m]))`}},
+		},
+		{
+			Title: "Last successful archiving", Type: "stat", Unit: "s",
+			Description: "Time since click archiving last succeeded",
+			Targets:     []Target{{Expr: `job:archive_last_success:age_seconds`}},
+		},
+		{
+			Title: "Click ingest requests by status", Type: "timeseries", Unit: "reqps",
+			Targets: []Target{{Expr: `sum by (status) (rate(http_requests_total{method="POST",path="/ads/clicks"}[5m]))`, LegendFormat: "{{status}}"}},
+		},
+		{
+			Title: "Clicks by outcome", Type: "timeseries", Unit: "ops",
+			Targets: []Target{{Expr: `sum by (status) (rate(clicks_total[5m]))`, LegendFormat: "{{status}}"}},
+		},
+		{
+			Title: "p99 latency by route", Type: "timeseries", Unit: "s",
+			Targets: []Target{{Expr: `max by (method, path) (job:http_request_duration_seconds:p99_rate5m)`, LegendFormat: "{{method}} {{path}}"}},
+		},
+		{
+			Title: "Requests in flight", Type: "timeseries", Unit: "short",
+			Targets: []Target{{Expr: `sum by (instance) (http_requests_in_flight)`, LegendFormat: "{{instance}}"}},
+		},
+		{
+			Title: "Database pool usage", Type: "timeseries", Unit: "percentunit",
+			Targets: []Target{{Expr: `max by (instance, pool) (instance:database_connections_in_use:ratio)`, LegendFormat: "{{instance}} {{pool}}"}},
+		},
+		{
+			Title: "Database connection wait time", Type: "timeseries", Unit: "s",
+			Description: "Time per second queries spent waiting for a free connection",
+			Targets:     []Target{{Expr: `sum by (pool) (rate(database_wait_duration_seconds_total[5m]))`, LegendFormat: "{{pool}}"}},
+		},
+		{
+			Title: "Database p99 latency by method", Type: "timeseries", Unit: "s",
+			Targets: []Target{{Expr: `histogram_quantile(0.99, sum by (method, le) (rate(database_query_duration_seconds_bucket[5m])))`, LegendFormat: "{{method}}"}},
+		},
+		{
+			Title: "Archived clicks", Type: "timeseries", Unit: "ops",
+			Targets: []Target{{Expr: `sum(rate(archived_clicks_total[5m]))`, LegendFormat: "archived"}},
+		},
+		{
+			Title: "Leader", Type: "timeseries", Unit: "short",
+			Description: "Replica running scheduled jobs, exactly one should be 1",
+			Targets:     []Target{{Expr: `max by (instance) (leader_is_leader)`, LegendFormat: "{{instance}}"}},
+		},
+	}
+	dashboard := Dashboard{
+		UID:           "video-ad-metrics",
+		Title:         "Video Ad Metrics",
+		Tags:          []string{"video-ad-metrics"},
+		Timezone:      "browser",
+		SchemaVersion: 39,
+		Refresh:       "30s",
+		Time:          TimeRange{From: "now-6h", To: "now"},
+		Templating: Templating{List: []Variable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+		}},
+	}
+
+	// stats in a row on top, then two time series per row
+	datasource := Datasource{Type: "prometheus", UID: "${datasource}"}
+	x, y := 0, 0
+	for i, p := range panels {
+		pos := GridPos{H: 8, W: 12, X: x, Y: y}
+		if p.Type == "stat" {
+			pos = GridPos{H: 4, W: 6, X: x, Y: y}
+		}
+		if x += pos.W; x >= 24 {
+			x, y = 0, y+pos.H
+		}
+		for j := range p.Targets {
+			p.Targets[j].RefID = string(rune('A' + j))
+		}
+		dashboard.Panels = append(dashboard.Panels, Panel{
+			ID:          i + 1,
+			Title:       p.Title,
+			Type:        p.Type,
+			Description: p.Description,
+			GridPos:     pos,
+			Datasource:  datasource,
+			FieldConfig: FieldConfig{Defaults: FieldDefaults{Unit: p.Unit}},
+			Targets:     p.Targets,
+		})
+	}
+	return dashboard
+}
+
+// Check fails when a panel queries a metric which is neither exposed by the service nor recorded
+func (d Dashboard) Check(recorded map[string]bool) error {
+	var errs []error
+	for _, panel := range d.Panels {
+		for _, target := range panel.Targets {
+			if err := CheckExpression(target.Expr, recorded); err != nil {
+				errs = append(errs, fmt.Errorf("panel %q: %w", panel.Title, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
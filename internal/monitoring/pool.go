@@ -0,0 +1,49 @@
+package monitoring
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector reports the connection wait totals of the registered database pools
+type poolCollector struct {
+	mu           sync.Mutex
+	pools        map[string]func() sql.DBStats
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+var databasePools = &poolCollector{
+	pools: map[string]func() sql.DBStats{},
+	waitCount: prometheus.NewDesc("database_wait_count_total",
+		"Total number of times a query waited for a free database connection", []string{"pool"}, nil),
+	waitDuration: prometheus.NewDesc("database_wait_duration_seconds_total",
+		"Total time spent waiting for a free database connection in seconds", []string{"pool"}, nil),
+}
+
+func init() {
+	metrics.MustRegister(databasePools)
+}
+
+func (c *poolCollector) add(pool string, stats func() sql.DBStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pools[pool] = stats
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.waitCount
+	ch <- c.waitDuration
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for pool, stats := range c.pools {
+		s := stats()
+		ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(s.WaitCount), pool)
+		ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, s.WaitDuration.Seconds(), pool)
+	}
+}
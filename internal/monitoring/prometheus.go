@@ -1,98 +1,283 @@
 package monitoring
 
 import (
+	"context"
+	"database/sql"
 	"net/http"
 	"strconv"
 	"time"
 
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
-	// RequestsTotal tracks the total number of HTTP requests
-	RequestsTotal = promauto.NewCounterVec(
+	// RequestsTotal tracks the total number of HTTP requests, path is the matched route pattern
+	RequestsTotal = factory.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "http_requests_total",
-			Help: "Total number of HTTP requests by method and path",
+			Help: "Total number of HTTP requests by method, route and status",
 		},
 		[]string{"method", "path", "status"},
 	)
 
 	// RequestDuration tracks the duration of HTTP requests
-	RequestDuration = promauto.NewHistogramVec(
+	RequestDuration = factory.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "http_request_duration_seconds",
-			Help:    "Duration of HTTP requests in seconds",
+			Help:    "Duration of HTTP requests in seconds by method and route",
 			Buckets: prometheus.DefBuckets,
 		},
 		[]string{"method", "path"},
 	)
 
-	// DatabaseConnections tracks the current number of active DB connections
-	DatabaseConnections = promauto.NewGauge(
+	// RequestsInFlight tracks the number of HTTP requests being served
+	RequestsInFlight = factory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Number of HTTP requests currently being served",
+		},
+	)
+
+	// DatabaseConnections tracks the current number of DB connections per pool and state
+	DatabaseConnections = factory.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "database_connections",
-			Help: "Number of active database connections",
+			Help: "Number of database connections by pool and state (open, in_use, idle)",
+		},
+		[]string{"pool", "state"},
+	)
+
+	// DatabaseMaxConnections tracks the configured connection limit per pool
+	DatabaseMaxConnections = factory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "database_max_connections",
+			Help: "Maximum number of open database connections by pool",
+		},
+		[]string{"pool"},
+	)
+
+	// DatabaseReplicaHealthy tracks whether each read replica is used for reads
+	DatabaseReplicaHealthy = factory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "database_replica_healthy",
+			Help: "Whether a read replica passes health checks (1) or its reads go to the primary (0)",
+		},
+		[]string{"pool"},
+	)
+
+	// Clicks tracks click tracking requests by outcome (logged, invalid, not_found, error)
+	Clicks = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "clicks_total",
+			Help: "Total number of clicks received by status",
+		},
+		[]string{"status"},
+	)
+
+	// DatabaseQueryDuration tracks the duration of each Repository method
+	DatabaseQueryDuration = factory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "database_query_duration_seconds",
+			Help:    "Duration of database operations in seconds by Repository method",
+			Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		},
+		[]string{"method"},
+	)
+
+	// DatabaseQueryErrors tracks failed Repository method calls, not found errors excluded
+	DatabaseQueryErrors = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "database_query_errors_total",
+			Help: "Total number of failed database operations by Repository method",
+		},
+		[]string{"method"},
+	)
+
+	// ArchivedClicks tracks the number of clicks moved to the archived_clicks table
+	ArchivedClicks = factory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "archived_clicks_total",
+			Help: "Total number of clicks moved to the archive",
+		},
+	)
+
+	// ArchiveDuration tracks how long each archiving run takes
+	ArchiveDuration = factory.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "archive_duration_seconds",
+			Help:    "Duration of click archiving runs in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+		},
+	)
+
+	// ArchiveLastSuccess tracks when archiving last completed without errors
+	ArchiveLastSuccess = factory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "archive_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful click archiving run",
 		},
 	)
 
-	// ClicksLogged tracks the rate of clicks being logged
-	ClicksLogged = promauto.NewCounter(
+	// IsLeader tracks whether this replica currently runs singleton background work
+	IsLeader = factory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "leader_is_leader",
+			Help: "Whether this replica is the leader (1) or not (0)",
+		},
+	)
+
+	// StreamSubscribers tracks the clients of the live streams served by this replica, per kind of stream
+	StreamSubscribers = factory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stream_subscribers",
+			Help: "Number of live stream subscribers by stream (analytics, tail)",
+		},
+		[]string{"stream"},
+	)
+
+	// StreamSubscribersLagged tracks the subscribers closed for not reading their events fast enough
+	StreamSubscribersLagged = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stream_subscribers_lagged_total",
+			Help: "Total number of live stream subscribers closed for lagging behind by stream",
+		},
+		[]string{"stream"},
+	)
+
+	// StreamEventsDropped tracks the events not sent to subscribers which drop them when lagging behind
+	StreamEventsDropped = factory.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "clicks_logged_total",
-			Help: "Total number of ad clicks logged",
+			Name: "stream_events_dropped_total",
+			Help: "Total number of live stream events dropped for lagging subscribers by stream",
+		},
+		[]string{"stream"},
+	)
+
+	// LeaderTransitions tracks how often this replica gained or lost leadership
+	LeaderTransitions = factory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "leader_transitions_total",
+			Help: "Total number of times this replica gained or lost leadership",
 		},
 	)
 )
 
 // MetricsHandler returns a handler for the /metrics endpoint
 func MetricsHandler() http.Handler {
-	return promhttp.Handler()
+	// exemplars are only exposed in the OpenMetrics format
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
 }
 
-// PrometheusMiddleware adds metrics to HTTP requests
+// PrometheusMiddleware adds metrics to HTTP requests, labelled by route (see apihelpers.RouteMiddleware)
+// so requests for different ads share a time series
 func PrometheusMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		RequestsInFlight.Inc()
+		defer RequestsInFlight.Dec()
 
 		// Create a custom response writer to capture the status code
-		wrapped := newResponseWriter(w)
+		wrapped := apihelpers.NewResponseRecorder(w)
+
+		// Record metrics once the request is complete, or aborted by a panic (e.g.
+		// http.ErrAbortHandler cutting an export short) which counts as a server error
+		completed := false
+		defer func() {
+			duration := time.Since(start).Seconds()
+			statusCode := wrapped.StatusCode
+			if !completed {
+				statusCode = http.StatusInternalServerError
+			}
+
+			route := apihelpers.GetRoute(r)
+			exemplar := exemplarLabels(r.Context())
+			RequestsTotal.WithLabelValues(r.Method, route, strconv.Itoa(statusCode)).(prometheus.ExemplarAdder).AddWithExemplar(1, exemplar)
+			RequestDuration.WithLabelValues(r.Method, route).(prometheus.ExemplarObserver).ObserveWithExemplar(duration, exemplar)
+		}()
 
 		// Process the request
 		next.ServeHTTP(wrapped, r)
+		completed = true
+	})
+}
 
-		// Record metrics after the request is complete
-		duration := time.Since(start).Seconds()
-		statusCode := wrapped.statusCode
+// exemplarLabels links a sample to the request it came from, by its request ID and,
+// when the trace is sampled (so it can be looked up), its trace ID
+func exemplarLabels(ctx context.Context) prometheus.Labels {
+	labels := prometheus.Labels{}
+	if requestID := apihelpers.RequestIDFromContext(ctx); requestID != "" {
+		labels["request_id"] = requestID
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsSampled() {
+		labels["trace_id"] = spanContext.TraceID().String()
+	}
+	return labels
+}
 
-		RequestsTotal.WithLabelValues(r.Method, r.URL.Path, strconv.Itoa(statusCode)).Inc()
-		RequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(duration)
-	})
+// SetDatabasePoolStats records the connection stats of a database pool
+func SetDatabasePoolStats(pool string, stats sql.DBStats) {
+	DatabaseConnections.WithLabelValues(pool, "open").Set(float64(stats.OpenConnections))
+	DatabaseConnections.WithLabelValues(pool, "in_use").Set(float64(stats.InUse))
+	DatabaseConnections.WithLabelValues(pool, "idle").Set(float64(stats.Idle))
+	DatabaseMaxConnections.WithLabelValues(pool).Set(float64(stats.MaxOpenConnections))
+}
+
+// RegisterDatabasePool exposes how often and how long queries waited for a free connection of a pool.
+// stats is read on every scrape since sql.DBStats reports these as running totals.
+func RegisterDatabasePool(pool string, stats func() sql.DBStats) {
+	databasePools.add(pool, stats)
+}
+
+// SetDatabaseReplicaHealthy records the health of a read replica
+func SetDatabaseReplicaHealthy(pool string, healthy bool) {
+	if healthy {
+		DatabaseReplicaHealthy.WithLabelValues(pool).Set(1)
+	} else {
+		DatabaseReplicaHealthy.WithLabelValues(pool).Set(0)
+	}
+}
+
+// IncrementClicks counts a click tracking request by its outcome. Each request logs a single
+// click, so there is no ingest batch size to record.
+func IncrementClicks(status string) {
+	Clicks.WithLabelValues(status).Inc()
+}
+
+// ObserveDatabaseQuery records the duration of a Repository method
+func ObserveDatabaseQuery(method string, duration time.Duration) {
+	DatabaseQueryDuration.WithLabelValues(method).Observe(duration.Seconds())
 }
 
-// responseWriter is a wrapper for http.ResponseWriter that captures the status code
-type responseWriter struct {
-	http.ResponseWriter
-	statusCode int
+// IncrementDatabaseQueryErrors counts a failed Repository method call
+func IncrementDatabaseQueryErrors(method string) {
+	DatabaseQueryErrors.WithLabelValues(method).Inc()
 }
 
-func newResponseWriter(w http.ResponseWriter) *responseWriter {
-	return &responseWriter{w, http.StatusOK}
+// AddArchivedClicks increases the archived clicks counter
+func AddArchivedClicks(count int64) {
+	ArchivedClicks.Add(float64(count))
 }
 
-func (rw *responseWriter) WriteHeader(code int) {
-	rw.statusCode = code
-	rw.ResponseWriter.WriteHeader(code)
+// ObserveArchiveDuration records the duration of an archiving run
+func ObserveArchiveDuration(duration time.Duration) {
+	ArchiveDuration.Observe(duration.Seconds())
 }
 
-// SetDatabaseConnections sets the current number of database connections
-func SetDatabaseConnections(count int) {
-	DatabaseConnections.Set(float64(count))
+// SetArchiveLastSuccess records the time of the last successful archiving run
+func SetArchiveLastSuccess(t time.Time) {
+	ArchiveLastSuccess.Set(float64(t.Unix()))
 }
 
-// IncrementClicksLogged increases the clicks logged counter
-func IncrementClicksLogged() {
-	ClicksLogged.Inc()
+// SetLeader records a change of leadership of this replica
+func SetLeader(isLeader bool) {
+	LeaderTransitions.Inc()
+	if isLeader {
+		IsLeader.Set(1)
+	} else {
+		IsLeader.Set(0)
+	}
 }
@@ -0,0 +1,44 @@
+package monitoring
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrometheusMiddlewareCountsAbortedRequests(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /test/aborted", func(w http.ResponseWriter, r *http.Request) {
+		// like an export failing once its first rows were sent
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("id,ad_id\n"))
+		http.NewResponseController(w).Flush()
+		panic(http.ErrAbortHandler)
+	})
+	server := httptest.NewServer(apihelpers.RouteMiddleware(mux)(PrometheusMiddleware(mux)))
+	t.Cleanup(server.Close)
+	aborted := RequestsTotal.WithLabelValues("GET", "/test/aborted", "500")
+	succeeded := RequestsTotal.WithLabelValues("GET", "/test/aborted", "200")
+	abortedBefore, succeededBefore := testutil.ToFloat64(aborted), testutil.ToFloat64(succeeded)
+
+	res, err := http.Get(server.URL + "/test/aborted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the connection is closed once the request was counted
+	if _, err := io.ReadAll(res.Body); err == nil {
+		t.Error("aborted response was read without error")
+	}
+	res.Body.Close()
+
+	if count := testutil.ToFloat64(aborted) - abortedBefore; count != 1 {
+		t.Errorf("counted %v aborted requests as 500s, want 1", count)
+	}
+	if count := testutil.ToFloat64(succeeded) - succeededBefore; count != 0 {
+		t.Errorf("counted %v aborted requests as 200s, want 0", count)
+	}
+}
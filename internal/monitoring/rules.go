@@ -0,0 +1,192 @@
+package monitoring
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RuleOptions sets the objectives the generated rules alert on
+type RuleOptions struct {
+	// fraction of click tracking requests which must not fail with a server error, e.g. 0.999
+	ClickAvailability float64
+	// p99 latency of click tracking requests
+	ClickLatencyP99 time.Duration
+	// how long archiving may go without succeeding
+	ArchiveMaxAge time.Duration
+	// fraction of the pool's connections in use considered saturated
+	PoolSaturation float64
+}
+
+// Default sets the unset objectives, matching the service's defaults (e.g. health.archive_max_age)
+func (o *RuleOptions) Default() {
+	if o.ClickAvailability <= 0 {
+		o.ClickAvailability = 0.999
+	}
+	if o.ClickLatencyP99 <= 0 {
+		o.ClickLatencyP99 = 500 * time.Millisecond
+	}
+	if o.ArchiveMaxAge <= 0 {
+		o.ArchiveMaxAge = 48 * time.Hour
+	}
+	if o.PoolSaturation <= 0 {
+		o.PoolSaturation = 0.9
+	}
+}
+
+// RuleFile is a Prometheus rule file
+type RuleFile struct {
+	Groups []RuleGroup `yaml:"groups"`
+}
+
+type RuleGroup struct {
+	Name     string `yaml:"name"`
+	Interval string `yaml:"interval,omitempty"`
+	Rules    []Rule `yaml:"rules"`
+}
+
+// Rule is a recording rule (Record set) or an alerting rule (Alert set)
+type Rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+const clickSelector = `http_requests_total{method="POST",path="/ads/clicks"}`
+
+// burn rate windows of the multiwindow alerts, see https://sre.google/workbook/alerting-on-slos/
+var clickErrorWindows = []string{"5m", "30m", "1h", "6h"}
+
+// Rules builds the recording and alerting rules for the service level objectives of opts
+func Rules(opts RuleOptions) RuleFile {
+	opts.Default()
+	var recording []Rule
+	for _, window := range clickErrorWindows {
+		recording = append(recording, Rule{
+			Record: "job:click_ingest_errors:ratio_rate" + window,
+			Expr: fmt.Sprintf(`sum by (job) (rate(%s[%s])) / sum by (job) (rate(%s[%s]))`,
+				withMatcher(clickSelector, `status=~"5.."`), window, clickSelector, window),
+		})
+	}
+	recording = append(recording,
+		Rule{
+			Record: "job:http_request_duration_seconds:p99_rate5m",
+			Expr:   `histogram_quantile(0.99, sum by (job, method, path, le) (rate(http_request_duration_seconds_bucket[5m])))`,
+		},
+		Rule{
+			Record: "job:archive_last_success:age_seconds",
+			Expr:   `time() - max by (job) (archive_last_success_timestamp_seconds)`,
+		},
+		Rule{
+			Record: "instance:database_connections_in_use:ratio",
+			Expr:   `sum by (job, instance, pool) (database_connections{state="in_use"}) / sum by (job, instance, pool) (database_max_connections)`,
+		},
+	)
+
+	budget := 1 - opts.ClickAvailability
+	alerts := []Rule{
+		{
+			Alert: "ClickIngestErrorBudgetBurn",
+			Expr: fmt.Sprintf(`job:click_ingest_errors:ratio_rate1h > %[1]s and job:click_ingest_errors:ratio_rate5m > %[1]s`,
+				formatFloat(14.4*budget)),
+			For:    "2m",
+			Labels: map[string]string{"severity": "page"},
+			Annotations: map[string]string{
+				"summary":     "Click tracking is failing fast enough to use 2% of the monthly error budget in an hour",
+				"description": fmt.Sprintf("{{ $value | humanizePercentage }} of POST /ads/clicks requests failed over the last hour, the objective is %s availability.", formatPercent(opts.ClickAvailability)),
+			},
+		},
+		{
+			Alert: "ClickIngestErrorBudgetBurnSlow",
+			Expr: fmt.Sprintf(`job:click_ingest_errors:ratio_rate6h > %[1]s and job:click_ingest_errors:ratio_rate30m > %[1]s`,
+				formatFloat(6*budget)),
+			For:    "15m",
+			Labels: map[string]string{"severity": "ticket"},
+			Annotations: map[string]string{
+				"summary":     "Click tracking is failing fast enough to use 5% of the monthly error budget in six hours",
+				"description": fmt.Sprintf("{{ $value | humanizePercentage }} of POST /ads/clicks requests failed over the last 6 hours, the objective is %s availability.", formatPercent(opts.ClickAvailability)),
+			},
+		},
+		{
+			Alert: "ClickIngestLatencyHigh",
+			Expr: fmt.Sprintf(`job:http_request_duration_seconds:p99_rate5m{method="POST",path="/ads/clicks"} > %s`,
+				formatFloat(opts.ClickLatencyP99.Seconds())),
+			For:    "10m",
+			Labels: map[string]string{"severity": "ticket"},
+			Annotations: map[string]string{
+				"summary":     "Click tracking is slow",
+				"description": fmt.Sprintf("p99 latency of POST /ads/clicks is {{ $value | humanizeDuration }}, the objective is %s.", opts.ClickLatencyP99),
+			},
+		},
+		{
+			Alert:  "ArchivingStale",
+			Expr:   fmt.Sprintf(`job:archive_last_success:age_seconds > %s`, formatFloat(opts.ArchiveMaxAge.Seconds())),
+			For:    "30m",
+			Labels: map[string]string{"severity": "ticket"},
+			Annotations: map[string]string{
+				"summary":     "Clicks are not being archived",
+				"description": fmt.Sprintf("Click archiving last succeeded {{ $value | humanizeDuration }} ago, more than %s. Check the archive-clicks runs in GET /admin/jobs/archive-clicks/runs.", opts.ArchiveMaxAge),
+			},
+		},
+		{
+			Alert:  "DatabasePoolSaturated",
+			Expr:   fmt.Sprintf(`instance:database_connections_in_use:ratio > %s`, formatFloat(opts.PoolSaturation)),
+			For:    "10m",
+			Labels: map[string]string{"severity": "ticket"},
+			Annotations: map[string]string{
+				"summary":     "Database pool {{ $labels.pool }} is saturated",
+				"description": "{{ $value | humanizePercentage }} of the connections of pool {{ $labels.pool }} are in use on {{ $labels.instance }}, queries wait for a free connection (see database_wait_duration_seconds_total). Raise database.max_open_conns or find the slow queries.",
+			},
+		},
+	}
+
+	return RuleFile{Groups: []RuleGroup{
+		{Name: "video-ad-metrics.rules", Interval: "1m", Rules: recording},
+		{Name: "video-ad-metrics.alerts", Rules: alerts},
+	}}
+}
+
+// Check fails when a rule references a metric which doesn't exist, rules may use series
+// recorded by any rule of the file
+func (f RuleFile) Check() error {
+	recorded := f.Recorded()
+	var errs []error
+	for _, group := range f.Groups {
+		for _, rule := range group.Rules {
+			if err := CheckExpression(rule.Expr, recorded); err != nil {
+				errs = append(errs, fmt.Errorf("rule %s%s: %w", rule.Record, rule.Alert, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Recorded returns the names of the series recorded by the file's recording rules
+func (f RuleFile) Recorded() map[string]bool {
+	recorded := map[string]bool{}
+	for _, group := range f.Groups {
+		for _, rule := range group.Rules {
+			if rule.Record != "" {
+				recorded[rule.Record] = true
+			}
+		}
+	}
+	return recorded
+}
+
+// withMatcher adds a label matcher to a selector ending with }
+func withMatcher(selector, matcher string) string {
+	return selector[:len(selector)-1] + "," + matcher + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', 6, 64)
+}
+
+func formatPercent(f float64) string {
+	return strconv.FormatFloat(f*100, 'g', 6, 64) + "%"
+}
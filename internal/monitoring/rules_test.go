@@ -0,0 +1,52 @@
+package monitoring
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRulesReferenceKnownMetrics(t *testing.T) {
+	if err := Rules(RuleOptions{}).Check(); err != nil {
+		t.Fatalf("rules reference unknown metrics:\n%v", err)
+	}
+}
+
+func TestDashboardReferencesKnownMetrics(t *testing.T) {
+	recorded := Rules(RuleOptions{}).Recorded()
+	if err := NewDashboard().Check(recorded); err != nil {
+		t.Fatalf("dashboard references unknown metrics:\n%v", err)
+	}
+}
+
+func TestCheckExpression(t *testing.T) {
+	recorded := map[string]bool{"job:clicks:rate5m": true}
+	tests := []struct {
+		expr    string
+		unknown string // empty when the expression is valid
+	}{
+		{expr: `sum by (status) (rate(clicks_total{status="logged"}[5m]))`},
+		{expr: `histogram_quantile(0.99, sum by (le) (rate(http_request_duration_seconds_bucket[5m])))`},
+		{expr: `job:clicks:rate5m > 1e-3`},
+		{expr: `time() - archive_last_success_timestamp_seconds > 3600`},
+		{expr: `rate(clicks_logged_total[5m])`, unknown: "clicks_logged_total"},
+		{expr: `job:clicks:rate1h / on (job) job:clicks:rate5m`, unknown: "job:clicks:rate1h"},
+		{expr: `http_request_duration_seconds > 1`, unknown: "http_request_duration_seconds"},
+	}
+	for _, tt := range tests {
+		err := CheckExpression(tt.expr, recorded)
+		switch {
+		case tt.unknown == "" && err != nil:
+			t.Errorf("CheckExpression(%q) = %v, want no error", tt.expr, err)
+		case tt.unknown != "" && (err == nil || !strings.Contains(err.Error(), tt.unknown)):
+			t.Errorf("CheckExpression(%q) = %v, want unknown metric %s", tt.expr, err, tt.unknown)
+		}
+	}
+}
+
+func TestRulesCheckFailsOnUnknownMetric(t *testing.T) {
+	rules := Rules(RuleOptions{})
+	rules.Groups[0].Rules = append(rules.Groups[0].Rules, Rule{Alert: "Unknown", Expr: `rate(no_such_metric_total[5m]) > 0`})
+	if err := rules.Check(); err == nil || !strings.Contains(err.Error(), "no_such_metric_total") {
+		t.Fatalf("Check() = %v, want an error for no_such_metric_total", err)
+	}
+}
@@ -0,0 +1,266 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Document is an OpenAPI 3.0 document
+type Document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components Components                      `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme"`
+	Description string `json:"description,omitempty"`
+}
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Route is an endpoint of the API, registered on a ServeMux by Register and described by Spec
+type Route struct {
+	Method  string
+	Path    string // ServeMux path, e.g. /ads/{id}
+	Name    string // operationId
+	Summary string
+	Tags    []string
+	// query parameters, and descriptions of path parameters (path parameters default to required strings)
+	Params []Parameter
+	// zero value of the JSON request body type, nil when there is no body
+	Body any
+	// success status, 200 when zero
+	Status int
+	// zero value of the type of the success envelope's result, nil when there is no result
+	Result any
+	// content types of responses sent as is instead of in the envelope, e.g. text/plain for /metrics
+	Produces []string
+	// error statuses the route responds with, in the error envelope
+	Errors []int
+	// zero value of the type of the error envelope's result, nil when errors have no result
+	ErrorResult any
+	// Auth marks admin routes, requiring the admin token
+	Auth    bool
+	Handler http.Handler
+}
+
+// Pattern returns the ServeMux pattern of the route
+func (r Route) Pattern() string {
+	return r.Method + " " + r.Path
+}
+
+// Query describes a query parameter
+func Query(name, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// Path describes a path parameter
+func Path(name, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "path", Description: description, Required: true, Schema: schema}
+}
+
+// Header describes a request header
+func Header(name, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "header", Description: description, Schema: schema}
+}
+
+// Register adds every route to mux, wrapping the handlers of Auth routes with auth
+func Register(mux *http.ServeMux, routes []Route, auth func(http.Handler) http.Handler) {
+	for _, route := range routes {
+		handler := route.Handler
+		if route.Auth {
+			handler = auth(handler)
+		}
+		mux.Handle(route.Pattern(), handler)
+	}
+}
+
+const adminScheme = "adminToken"
+
+var pathParam = regexp.MustCompile(`\{([^}.]+)(?:\.\.\.)?\}`)
+
+// Spec describes routes as an OpenAPI document, request and response schemas are derived
+// from the Body, Result and ErrorResult types and wrapped in the SuccessResponse and
+// ErrorResponse envelopes of apihelpers
+func Spec(info Info, routes []Route) Document {
+	schemas := newSchemas()
+	schemas.components["SuccessResponse"] = &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"success":  {Type: "boolean", Enum: []any{true}},
+			"message":  {Type: "string"},
+			"trace_id": {Type: "string", Description: "OpenTelemetry trace ID of the request"},
+		},
+		Required: []string{"success", "message", "trace_id"},
+	}
+	schemas.components["ErrorResponse"] = &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"success":  {Type: "boolean", Enum: []any{false}},
+			"message":  {Type: "string"},
+			"trace_id": {Type: "string", Description: "OpenTelemetry trace ID of the request"},
+		},
+		Required: []string{"success", "message", "trace_id"},
+	}
+
+	doc := Document{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Paths:   map[string]map[string]Operation{},
+		Components: Components{
+			Schemas: schemas.components,
+			SecuritySchemes: map[string]SecurityScheme{
+				adminScheme: {Type: "http", Scheme: "bearer", Description: "ADMIN_TOKEN configured on the service"},
+			},
+		},
+	}
+	for _, route := range routes {
+		if doc.Paths[route.Path] == nil {
+			doc.Paths[route.Path] = map[string]Operation{}
+		}
+		doc.Paths[route.Path][strings.ToLower(route.Method)] = operation(route, schemas)
+	}
+	return doc
+}
+
+func operation(route Route, schemas *schemas) Operation {
+	op := Operation{
+		OperationID: route.Name,
+		Summary:     route.Summary,
+		Tags:        route.Tags,
+		Responses:   map[string]Response{},
+	}
+
+	for _, match := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+		param := Path(match[1], "", &Schema{Type: "string"})
+		for _, p := range route.Params {
+			if p.In == "path" && p.Name == match[1] {
+				param = p
+			}
+		}
+		op.Parameters = append(op.Parameters, param)
+	}
+	for _, p := range route.Params {
+		if p.In != "path" {
+			op.Parameters = append(op.Parameters, p)
+		}
+	}
+
+	if body := schemas.of(route.Body); body != nil {
+		op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: body}}}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status == http.StatusSwitchingProtocols {
+		// WebSocket upgrades, the messages can't be described here
+		op.Responses[strconv.Itoa(status)] = Response{Description: http.StatusText(status)}
+	} else if len(route.Produces) > 0 {
+		content := map[string]MediaType{}
+		for _, contentType := range route.Produces {
+			schema := &Schema{Type: "string"}
+			if !strings.HasPrefix(contentType, "text/") && !strings.HasSuffix(contentType, "json") {
+				// e.g. application/vnd.apache.parquet
+				schema.Format = "binary"
+			}
+			content[contentType] = MediaType{Schema: schema}
+		}
+		op.Responses[strconv.Itoa(status)] = Response{Description: http.StatusText(status), Content: content}
+	} else {
+		op.Responses[strconv.Itoa(status)] = envelope(status, "SuccessResponse", schemas.of(route.Result))
+	}
+
+	errorResult := schemas.of(route.ErrorResult)
+	for _, status := range route.Errors {
+		op.Responses[strconv.Itoa(status)] = envelope(status, "ErrorResponse", errorResult)
+	}
+	if route.Auth {
+		op.Security = []map[string][]string{{adminScheme: {}}}
+		op.Responses["401"] = envelope(http.StatusUnauthorized, "ErrorResponse", nil)
+		op.Responses["403"] = envelope(http.StatusForbidden, "ErrorResponse", nil)
+	}
+	return op
+}
+
+// envelope describes a JSON response in the named envelope, with result when not nil
+func envelope(status int, name string, result *Schema) Response {
+	schema := &Schema{Ref: "#/components/schemas/" + name}
+	if result != nil {
+		schema = &Schema{AllOf: []*Schema{schema, {
+			Type:       "object",
+			Properties: map[string]*Schema{"result": result},
+			Required:   []string{"result"},
+		}}}
+	}
+	return Response{
+		Description: http.StatusText(status),
+		Content:     map[string]MediaType{"application/json": {Schema: schema}},
+	}
+}
+
+// Handler serves the spec of routes as JSON, the routes may include the one served by Handler
+func Handler(info Info, routes *[]Route) http.Handler {
+	spec := sync.OnceValues(func() ([]byte, error) {
+		return json.MarshalIndent(Spec(info, *routes), "", "  ")
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := spec()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to encode spec: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
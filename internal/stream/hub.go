@@ -0,0 +1,222 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/JalajGoswami/video-ad-metrics/internal/monitoring"
+)
+
+var (
+	// ErrClosed is returned when subscribing to a hub which was closed while shutting down
+	ErrClosed = errors.New("stream hub closed")
+	// ErrTooManySubscribers is returned when the hub already has the maximum number of subscribers of a kind
+	ErrTooManySubscribers = errors.New("too many stream subscribers")
+)
+
+// Event is a click published to the hub once its transaction committed
+type Event struct {
+	// ID orders the events of a hub, it is only meaningful to the hub which published it
+	ID    string
+	Click models.Click
+}
+
+// Hub fans out the clicks logged by this process to its subscribers and keeps the most
+// recent ones, so that subscribers reconnecting with the ID of the last event they received
+// get the events they missed. Clicks logged by other replicas are not seen.
+type Hub struct {
+	mu sync.Mutex
+	// identifies this hub in event IDs, so that the IDs of a previous process are not replayed from
+	epoch       string
+	seq         uint64
+	buffer      []Event // ring of the last len(buffer) events
+	subscribers map[*Subscription]struct{}
+	// subscribers and maximum number of subscribers by kind, see SubscribeOptions.Kind
+	counts         map[string]int
+	maxSubscribers map[string]int
+	closed         bool
+}
+
+// NewHub creates a hub keeping the last bufferSize events for replay, without limiting subscribers
+func NewHub(bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+	return &Hub{
+		epoch:          strconv.FormatInt(time.Now().UnixNano(), 36),
+		buffer:         make([]Event, bufferSize),
+		subscribers:    map[*Subscription]struct{}{},
+		counts:         map[string]int{},
+		maxSubscribers: map[string]int{},
+	}
+}
+
+// SetMaxSubscribers changes the maximum number of subscribers of a kind, 0 for no limit.
+// The current subscribers are kept.
+func (h *Hub) SetMaxSubscribers(kind string, n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.maxSubscribers[kind] = n
+}
+
+// Subscribers returns the current number of subscribers of a kind
+func (h *Hub) Subscribers(kind string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.counts[kind]
+}
+
+// Publish sends a committed click to the matching subscribers without blocking. A subscriber
+// whose buffer is full is closed instead of missing events, so that it resumes from its last
+// event, unless it subscribed with DropWhenFull.
+func (h *Hub) Publish(click models.Click) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.seq++
+	event := Event{ID: h.eventID(h.seq), Click: click}
+	h.buffer[h.seq%uint64(len(h.buffer))] = event
+
+	for sub := range h.subscribers {
+		if sub.filter != nil && !sub.filter(click) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			if sub.dropWhenFull {
+				sub.dropped.Add(1)
+				monitoring.StreamEventsDropped.WithLabelValues(sub.kind).Inc()
+				continue
+			}
+			monitoring.StreamSubscribersLagged.WithLabelValues(sub.kind).Inc()
+			h.remove(sub)
+		}
+	}
+}
+
+// SubscribeOptions selects the events of a subscription
+type SubscribeOptions struct {
+	// kind of stream, each kind has its own maximum number of subscribers
+	Kind string
+	// events received by the subscription, all when nil
+	Filter func(models.Click) bool
+	// number of events waiting to be read before the subscription is closed
+	Buffer int
+	// ID of the last event received before reconnecting, empty for a new subscription
+	LastEventID string
+	// drop the events published while the buffer is full instead of closing the subscription
+	DropWhenFull bool
+}
+
+// Subscription receives the events published after it was created
+type Subscription struct {
+	// Events is closed when the subscription lags behind, is closed or the hub is closed
+	Events <-chan Event
+	// Missed are the events published between LastEventID and the subscription, when Resumed
+	Missed []Event
+	// Resumed is whether the events after LastEventID were all still buffered, so that
+	// Missed and Events have no gap. Otherwise the subscriber has to start over.
+	Resumed bool
+	// ID of the last event published before the subscription, to resume from
+	LastID string
+
+	hub          *Hub
+	kind         string
+	events       chan Event
+	filter       func(models.Click) bool
+	dropWhenFull bool
+	dropped      atomic.Int64
+}
+
+// Subscribe adds a subscriber, see SubscribeOptions
+func (h *Hub) Subscribe(opts SubscribeOptions) (*Subscription, error) {
+	if opts.Buffer <= 0 {
+		opts.Buffer = 64
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, ErrClosed
+	}
+	if max := h.maxSubscribers[opts.Kind]; max > 0 && h.counts[opts.Kind] >= max {
+		return nil, ErrTooManySubscribers
+	}
+
+	events := make(chan Event, opts.Buffer)
+	sub := &Subscription{
+		Events: events, LastID: h.eventID(h.seq),
+		hub: h, kind: opts.Kind, events: events, filter: opts.Filter, dropWhenFull: opts.DropWhenFull,
+	}
+	if opts.LastEventID != "" {
+		sub.Missed, sub.Resumed = h.since(opts.LastEventID, opts.Filter)
+	}
+	h.subscribers[sub] = struct{}{}
+	h.counts[sub.kind]++
+	monitoring.StreamSubscribers.WithLabelValues(sub.kind).Set(float64(h.counts[sub.kind]))
+	return sub, nil
+}
+
+// since returns the buffered events after the event with the given ID, and whether none of them was overwritten
+func (h *Hub) since(id string, filter func(models.Click) bool) ([]Event, bool) {
+	epoch, value, ok := strings.Cut(id, "-")
+	if !ok || epoch != h.epoch {
+		return nil, false
+	}
+	seq, err := strconv.ParseUint(value, 10, 64)
+	size := uint64(len(h.buffer))
+	if err != nil || seq > h.seq || h.seq-seq > size {
+		return nil, false
+	}
+	var events []Event
+	for next := seq + 1; next <= h.seq; next++ {
+		event := h.buffer[next%size]
+		if filter == nil || filter(event.Click) {
+			events = append(events, event)
+		}
+	}
+	return events, true
+}
+
+// TakeDropped returns the number of events dropped since the previous call, see DropWhenFull
+func (s *Subscription) TakeDropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+// Close removes the subscription and closes its Events channel, it may be called more than once
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// Close closes every subscription and rejects new ones, so that streams end when shutting down
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subscribers {
+		h.remove(sub)
+	}
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+		h.counts[sub.kind]--
+		monitoring.StreamSubscribers.WithLabelValues(sub.kind).Set(float64(h.counts[sub.kind]))
+	}
+}
+
+func (h *Hub) eventID(seq uint64) string {
+	return fmt.Sprintf("%s-%d", h.epoch, seq)
+}
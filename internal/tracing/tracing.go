@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// Span exporters
+const (
+	ExporterNone   = "none"   // spans are created (so trace IDs exist) but not exported
+	ExporterOTLP   = "otlp"   // OTLP over HTTP to a collector
+	ExporterStdout = "stdout" // pretty printed JSON on stdout, for local use
+)
+
+// Options controls how spans are sampled and where they are exported
+type Options struct {
+	Exporter string
+	// OTLP/HTTP collector url, e.g. http://otel-collector:4318, when empty the
+	// standard OTEL_EXPORTER_OTLP_* environment variables apply
+	Endpoint    string
+	SampleRatio float64
+	ServiceName string
+	Instance    string
+}
+
+func (o *Options) Default() {
+	if o.Exporter == "" {
+		o.Exporter = ExporterNone
+	}
+	if o.ServiceName == "" {
+		o.ServiceName = "video-ad-metrics"
+	}
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator.
+// A tracer provider is installed even without an exporter so every request gets a real
+// trace ID, honoring the traceparent sent by the caller. The returned shutdown func
+// flushes the spans not exported yet.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	opts.Default()
+
+	providerOptions := []sdktrace.TracerProviderOption{
+		// follow the caller's sampling decision, sample new traces by ratio
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(opts.ServiceName),
+			semconv.ServiceInstanceID(opts.Instance),
+		)),
+	}
+
+	switch opts.Exporter {
+	case ExporterOTLP:
+		var exporterOptions []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			exporterOptions = append(exporterOptions, otlptracehttp.WithEndpointURL(opts.Endpoint))
+		}
+		exporter, err := otlptracehttp.New(ctx, exporterOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		providerOptions = append(providerOptions, sdktrace.WithBatcher(exporter))
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+		providerOptions = append(providerOptions, sdktrace.WithSyncer(exporter))
+	case ExporterNone:
+	default:
+		return nil, fmt.Errorf("unknown span exporter %q", opts.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(providerOptions...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return provider.Shutdown, nil
+}
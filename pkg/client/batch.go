@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BatchOptions configures a ClickBatcher, zero values are replaced by defaults
+type BatchOptions struct {
+	// clicks buffered before they are sent
+	Size int
+	// time a click may wait in the buffer before it is sent
+	Interval time.Duration
+	// clicks of a batch sent at the same time
+	Concurrency int
+	// called with every click failing after its retries, from the goroutine sending the batch
+	OnError func(click Click, err error)
+}
+
+func (o *BatchOptions) Default() {
+	if o.Size <= 0 {
+		o.Size = 100
+	}
+	if o.Interval <= 0 {
+		o.Interval = time.Second
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 8
+	}
+}
+
+// ClickBatcher buffers clicks and logs them in batches, so callers on a hot path don't wait
+// for the API. Batches are sent when Size clicks are buffered, every Interval and on Close.
+// The API has no bulk endpoint, the clicks of a batch are sent as concurrent LogClick calls.
+type ClickBatcher struct {
+	client *Client
+	opts   BatchOptions
+
+	mu      sync.Mutex
+	pending []Click
+	full    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewClickBatcher starts a ClickBatcher logging clicks through c, stop it with Close
+func (c *Client) NewClickBatcher(opts BatchOptions) *ClickBatcher {
+	opts.Default()
+	b := &ClickBatcher{
+		client: c,
+		opts:   opts,
+		full:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Add buffers a click, it never blocks on the API
+func (b *ClickBatcher) Add(click Click) {
+	b.mu.Lock()
+	b.pending = append(b.pending, click)
+	full := len(b.pending) >= b.opts.Size
+	b.mu.Unlock()
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close sends the buffered clicks and stops the batcher, giving up on unsent clicks once ctx is done
+func (b *ClickBatcher) Close(ctx context.Context) error {
+	close(b.stop)
+	<-b.done
+	return b.flush(ctx)
+}
+
+func (b *ClickBatcher) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-b.full:
+		case <-ticker.C:
+		}
+		b.flush(context.Background())
+	}
+}
+
+// flush logs the buffered clicks with up to Concurrency requests at a time
+func (b *ClickBatcher) flush(ctx context.Context) error {
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	sem := make(chan struct{}, b.opts.Concurrency)
+	var wg sync.WaitGroup
+	for i, click := range batch {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			if b.opts.OnError != nil {
+				for _, unsent := range batch[i:] {
+					b.opts.OnError(unsent, ctx.Err())
+				}
+			}
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			if _, err := b.client.LogClick(ctx, click); err != nil && b.opts.OnError != nil {
+				b.opts.OnError(click, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
@@ -0,0 +1,312 @@
+// Package client is a typed Go client of the video ad metrics API.
+//
+//	c := client.New("http://video-ad-metrics:5000", client.Options{})
+//	ad, err := c.GetAd(ctx, id)
+//	var apiErr *client.Error
+//	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Options configures a Client, zero values are replaced by defaults
+type Options struct {
+	HTTPClient *http.Client
+	// attempts of LogClick after the first one, other calls aren't retried. Negative disables retries.
+	MaxRetries int
+	// delay before the first retry, doubled on every retry up to MaxBackoff, with jitter
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	UserAgent  string
+}
+
+func (o *Options) Default() {
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 3
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 100 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 2 * time.Second
+	}
+	if o.UserAgent == "" {
+		o.UserAgent = "video-ad-metrics-client"
+	}
+}
+
+// Client calls the video ad metrics API, it is safe for concurrent use
+type Client struct {
+	baseURL string
+	opts    Options
+}
+
+// New creates a new Client of the API served at baseURL
+func New(baseURL string, opts Options) *Client {
+	opts.Default()
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), opts: opts}
+}
+
+// Error is a failed API call, TraceID identifies the request in the server's logs and traces
+type Error struct {
+	StatusCode int
+	Message    string
+	TraceID    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("video-ad-metrics: %d %s (trace_id %s)", e.StatusCode, e.Message, e.TraceID)
+}
+
+// IsNotFound reports whether err is an API error with status 404, e.g. an unknown ad
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// envelope is the body of every JSON response
+type envelope struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	TraceID string          `json:"trace_id"`
+	Result  json.RawMessage `json:"result"`
+}
+
+// CreateAd creates an ad
+func (c *Client) CreateAd(ctx context.Context, ad NewAd) (*Ad, error) {
+	var created Ad
+	if err := c.do(ctx, http.MethodPost, "/ads", nil, ad, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetAd retrieves an ad by ID
+func (c *Client) GetAd(ctx context.Context, id string) (*Ad, error) {
+	var ad Ad
+	if err := c.do(ctx, http.MethodGet, "/ads/"+url.PathEscape(id), nil, nil, &ad); err != nil {
+		return nil, err
+	}
+	return &ad, nil
+}
+
+// UpdateAd changes the status or campaign of an ad
+func (c *Client) UpdateAd(ctx context.Context, id string, update AdUpdate) (*Ad, error) {
+	var ad Ad
+	if err := c.do(ctx, http.MethodPatch, "/ads/"+url.PathEscape(id), nil, update, &ad); err != nil {
+		return nil, err
+	}
+	return &ad, nil
+}
+
+// ListAds returns a page of ads
+func (c *Client) ListAds(ctx context.Context, params ListAdsParams) (*AdList, error) {
+	query := url.Values{}
+	if params.Cursor != nil {
+		query.Set("cursor", *params.Cursor)
+		if params.IncludeTotal {
+			query.Set("include_total", "true")
+		}
+	} else if params.Page > 0 {
+		query.Set("page", strconv.Itoa(params.Page))
+	}
+	if params.Rows > 0 {
+		query.Set("rows", strconv.Itoa(params.Rows))
+	}
+	if params.Sort != "" {
+		query.Set("sort", params.Sort)
+	}
+	if params.Order != "" {
+		query.Set("order", params.Order)
+	}
+	if params.Search != "" {
+		query.Set("search", params.Search)
+	}
+	if params.SearchMode != "" {
+		query.Set("search_mode", params.SearchMode)
+	}
+	if !params.CreatedFrom.IsZero() {
+		query.Set("created_from", params.CreatedFrom.Format(time.RFC3339Nano))
+	}
+	if !params.CreatedTo.IsZero() {
+		query.Set("created_to", params.CreatedTo.Format(time.RFC3339Nano))
+	}
+	if params.TargetDomain != "" {
+		query.Set("target_domain", params.TargetDomain)
+	}
+	if len(params.Statuses) > 0 {
+		query.Set("status", strings.Join(params.Statuses, ","))
+	}
+	if params.Campaign != "" {
+		query.Set("campaign", params.Campaign)
+	}
+	if params.IncludeAnalytics {
+		query.Set("include", "analytics")
+	}
+	var list AdList
+	if err := c.do(ctx, http.MethodGet, "/ads", query, nil, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// AllAds iterates over every ad matching params, fetching pages in cursor mode as it goes.
+// Iteration stops after yielding an error.
+func (c *Client) AllAds(ctx context.Context, params ListAdsParams) iter.Seq2[Ad, error] {
+	return func(yield func(Ad, error) bool) {
+		cursor := ""
+		for {
+			params.Cursor = &cursor
+			list, err := c.ListAds(ctx, params)
+			if err != nil {
+				yield(Ad{}, err)
+				return
+			}
+			for _, ad := range list.Values {
+				if !yield(ad, nil) {
+					return
+				}
+			}
+			if list.NextCursor == "" {
+				return
+			}
+			cursor = list.NextCursor
+		}
+	}
+}
+
+// LogClick records a click, retrying with backoff on network errors, 429 and 5xx responses.
+// A retried click may be recorded twice when a response was lost after the server stored it.
+func (c *Client) LogClick(ctx context.Context, click Click) (*Click, error) {
+	payload := newClick{AdID: click.AdID, IPAddress: click.IPAddress, PlaybackTime: click.PlaybackTime}
+	if !click.Timestamp.IsZero() {
+		payload.Timestamp = &click.Timestamp
+	}
+
+	var logged Click
+	backoff := c.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		err := c.do(ctx, http.MethodPost, "/ads/clicks", nil, payload, &logged)
+		if err == nil {
+			return &logged, nil
+		}
+		if attempt >= c.opts.MaxRetries || !retryable(err) {
+			return nil, err
+		}
+
+		// full jitter, so clients failing together don't retry together
+		delay := time.Duration(rand.Int64N(int64(backoff) + 1))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, errors.Join(err, ctx.Err())
+		}
+		backoff = min(backoff*2, c.opts.MaxBackoff)
+	}
+}
+
+func retryable(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	// the caller gave up, retrying won't help
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// GetAdsAnalytics retrieves the analytics of all ads, period defaults to hour when empty
+func (c *Client) GetAdsAnalytics(ctx context.Context, period Period) (*AdsAnalytics, error) {
+	var analytics AdsAnalytics
+	if err := c.do(ctx, http.MethodGet, "/ads/analytics", periodQuery(period), nil, &analytics); err != nil {
+		return nil, err
+	}
+	return &analytics, nil
+}
+
+// GetAdAnalytics retrieves the analytics of an ad, period defaults to hour when empty
+func (c *Client) GetAdAnalytics(ctx context.Context, id string, period Period) (*AdAnalytics, error) {
+	var analytics AdAnalytics
+	if err := c.do(ctx, http.MethodGet, "/ads/analytics/"+url.PathEscape(id), periodQuery(period), nil, &analytics); err != nil {
+		return nil, err
+	}
+	return &analytics, nil
+}
+
+func periodQuery(period Period) url.Values {
+	query := url.Values{}
+	if period != "" {
+		query.Set("period", string(period))
+	}
+	return query
+}
+
+// do sends a request with body encoded as JSON and decodes the result of the envelope into result
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, result any) error {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", c.opts.UserAgent)
+	// continue the caller's trace, when it uses OpenTelemetry
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	res, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var env envelope
+	if err := json.NewDecoder(res.Body).Decode(&env); err != nil {
+		if res.StatusCode >= 400 {
+			// e.g. a proxy's error page
+			return &Error{StatusCode: res.StatusCode, Message: http.StatusText(res.StatusCode)}
+		}
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if res.StatusCode >= 400 || !env.Success {
+		return &Error{StatusCode: res.StatusCode, Message: env.Message, TraceID: env.TraceID}
+	}
+	if result != nil && len(env.Result) > 0 {
+		if err := json.Unmarshal(env.Result, result); err != nil {
+			return fmt.Errorf("failed to decode result (trace_id %s): %w", env.TraceID, err)
+		}
+	}
+	return nil
+}
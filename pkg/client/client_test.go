@@ -0,0 +1,532 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+
+	apihelpers "github.com/JalajGoswami/video-ad-metrics/internal/api-helpers"
+	"github.com/JalajGoswami/video-ad-metrics/internal/database"
+	"github.com/JalajGoswami/video-ad-metrics/internal/handlers"
+	"github.com/JalajGoswami/video-ad-metrics/internal/models"
+	"github.com/JalajGoswami/video-ad-metrics/internal/openapi"
+	"github.com/JalajGoswami/video-ad-metrics/internal/stream"
+	"github.com/JalajGoswami/video-ad-metrics/internal/tracing"
+	"github.com/JalajGoswami/video-ad-metrics/pkg/client"
+)
+
+func TestMain(m *testing.M) {
+	// a tracer provider gives every request a real trace ID, as in the server
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{SampleRatio: 1})
+	if err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	shutdown(context.Background())
+	os.Exit(code)
+}
+
+// repository keeps ads and clicks in memory, the methods the tests don't use panic
+type repository struct {
+	database.Repository
+
+	mu     sync.Mutex
+	ads    []models.Ad // most recent first, the default sort of ListAds
+	clicks []models.Click
+	// number of LogClick calls failing before they succeed again
+	failClicks int
+	clickCalls int
+}
+
+func (r *repository) CreateAd(ctx context.Context, ad *models.Ad) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ads = append([]models.Ad{*ad}, r.ads...)
+	return nil
+}
+
+func (r *repository) GetAd(ctx context.Context, id string) (*models.Ad, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ad := range r.ads {
+		if ad.ID == id {
+			return &ad, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+func (r *repository) UpdateAd(ctx context.Context, id string, update models.AdUpdate) (*models.Ad, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, ad := range r.ads {
+		if ad.ID != id {
+			continue
+		}
+		if update.Status != nil {
+			r.ads[i].Status = *update.Status
+		}
+		if update.Campaign != nil {
+			r.ads[i].Campaign = *update.Campaign
+		}
+		updated := r.ads[i]
+		return &updated, nil
+	}
+	return nil, database.ErrNotFound
+}
+
+// filteredAds returns the ads matching the status and campaign filters of opts, r.mu must be held
+func (r *repository) filteredAds(opts database.ListAdOptions) []models.Ad {
+	return slices.DeleteFunc(slices.Clone(r.ads), func(ad models.Ad) bool {
+		return (len(opts.Statuses) > 0 && !slices.Contains(opts.Statuses, ad.Status)) ||
+			(opts.Campaign != "" && ad.Campaign != opts.Campaign)
+	})
+}
+
+func (r *repository) ListAds(ctx context.Context, opts database.ListAdOptions) (*[]models.ListedAd, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ads := r.filteredAds(opts)
+	start, end := opts.Offset, opts.Offset+opts.Limit
+	if opts.Keyset {
+		start = 0
+		if opts.After != nil {
+			start = slices.IndexFunc(ads, func(ad models.Ad) bool { return ad.ID == opts.After.ID }) + 1
+		}
+		// one more row tells whether there is a next page
+		end = start + opts.Limit + 1
+	}
+	listed := []models.ListedAd{}
+	for _, ad := range ads[min(start, len(ads)):min(end, len(ads))] {
+		listed = append(listed, models.ListedAd{Ad: ad})
+	}
+	return &listed, nil
+}
+
+func (r *repository) CountAds(ctx context.Context, opts database.ListAdOptions) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.filteredAds(opts)), nil
+}
+
+func (r *repository) LogClick(ctx context.Context, click *models.Click) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clickCalls++
+	if r.failClicks > 0 {
+		r.failClicks--
+		return errors.New("connection reset")
+	}
+	if !slices.ContainsFunc(r.ads, func(ad models.Ad) bool { return ad.ID == click.AdID }) {
+		return database.ErrNotFound
+	}
+	r.clicks = append(r.clicks, *click)
+	return nil
+}
+
+func (r *repository) GetAdAnalytics(ctx context.Context, adID string, rangeDate time.Time) (*models.AdAnalyticsData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !slices.ContainsFunc(r.ads, func(ad models.Ad) bool { return ad.ID == adID }) {
+		return nil, database.ErrNotFound
+	}
+	analytics := &models.AdAnalyticsData{AdID: adID}
+	for _, click := range r.clicks {
+		if click.AdID != adID {
+			continue
+		}
+		analytics.TotalClicks++
+		analytics.TotalPlaybackTime += click.PlaybackTime
+		if !click.Timestamp.Before(rangeDate) {
+			analytics.TotalClicksInRange++
+			analytics.TotalPlaybackTimeInRange += click.PlaybackTime
+		}
+	}
+	return analytics, nil
+}
+
+func (r *repository) GetAdsAnalytics(ctx context.Context, rangeDate time.Time) (*models.AnalyticsData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	analytics := &models.AnalyticsData{}
+	for _, click := range r.clicks {
+		analytics.TotalClicks++
+		analytics.TotalPlaybackTime += click.PlaybackTime
+		if !click.Timestamp.Before(rangeDate) {
+			analytics.TotalClicksInRange++
+			analytics.TotalPlaybackTimeInRange += click.PlaybackTime
+		}
+	}
+	if len(r.ads) > 0 {
+		analytics.AverageClicksPerAd = float64(analytics.TotalClicks) / float64(len(r.ads))
+		analytics.AverageClicksPerAdInRange = float64(analytics.TotalClicksInRange) / float64(len(r.ads))
+	}
+	return analytics, nil
+}
+
+func (r *repository) loggedClicks() []models.Click {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.clicks)
+}
+
+// newServer serves the real routes and middlewares of the API on top of repo
+func newServer(t *testing.T, repo database.Repository) *httptest.Server {
+	t.Helper()
+	clicks := stream.NewHub(16)
+	mux := http.NewServeMux()
+	openapi.Register(mux, handlers.Routes(
+		handlers.NewHandler(repo, clicks), nil, nil, nil, nil,
+		handlers.NewStreamHandler(repo, clicks, handlers.StreamOptions{Heartbeat: time.Minute}), http.NotFoundHandler(),
+	), apihelpers.AdminAuthMiddleware(func() string { return "" }))
+	handler := apihelpers.TraceMiddleware(mux)
+	handler = apihelpers.RequestIDMiddleware("X-Request-ID")(handler)
+	handler = apihelpers.RouteMiddleware(mux)(handler)
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newClient creates a client of server retrying quickly
+func newClient(server *httptest.Server, opts client.Options) *client.Client {
+	if opts.Backoff == 0 {
+		opts.Backoff = time.Millisecond
+	}
+	return client.New(server.URL, opts)
+}
+
+var traceIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func createAds(t *testing.T, c *client.Client, n int) []client.Ad {
+	t.Helper()
+	var ads []client.Ad
+	for i := range n {
+		ad, err := c.CreateAd(context.Background(), client.NewAd{
+			Name:      "Ad " + string(rune('A'+i)),
+			ImageURL:  "https://cdn.example.com/ad.png",
+			TargetURL: "https://example.com",
+		})
+		if err != nil {
+			t.Fatalf("CreateAd: %v", err)
+		}
+		ads = append(ads, *ad)
+	}
+	return ads
+}
+
+func TestAds(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(newServer(t, &repository{}), client.Options{})
+
+	ads := createAds(t, c, 5)
+	if ads[0].ID == "" || ads[0].Name != "Ad A" || ads[0].CreatedAt.IsZero() {
+		t.Fatalf("CreateAd returned %+v, want the created ad with its ID and creation date", ads[0])
+	}
+
+	ad, err := c.GetAd(ctx, ads[2].ID)
+	if err != nil {
+		t.Fatalf("GetAd: %v", err)
+	}
+	if ad.ID != ads[2].ID || ad.Name != ads[2].Name || ad.TargetURL != ads[2].TargetURL {
+		t.Errorf("GetAd returned %+v, want %+v", ad, ads[2])
+	}
+
+	list, err := c.ListAds(ctx, client.ListAdsParams{Page: 2, Rows: 2})
+	if err != nil {
+		t.Fatalf("ListAds: %v", err)
+	}
+	if len(list.Values) != 2 || list.Pages == nil || list.Pages.PageNumber != 2 || list.Pages.TotalPages != 3 || list.Total == nil || *list.Total != 5 {
+		t.Errorf("ListAds page 2 returned %+v, want 2 of 5 ads on page 2 of 3", list)
+	}
+
+	var iterated []string
+	for ad, err := range c.AllAds(ctx, client.ListAdsParams{Rows: 2}) {
+		if err != nil {
+			t.Fatalf("AllAds: %v", err)
+		}
+		iterated = append(iterated, ad.Name)
+	}
+	if want := []string{"Ad E", "Ad D", "Ad C", "Ad B", "Ad A"}; !slices.Equal(iterated, want) {
+		t.Errorf("AllAds iterated over %v, want %v", iterated, want)
+	}
+}
+
+func TestAdStatusAndCampaign(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(newServer(t, &repository{}), client.Options{})
+
+	ads := createAds(t, c, 3)
+	if ads[0].Status != "active" || ads[0].Campaign != "" {
+		t.Fatalf("CreateAd returned %+v, want an active ad without campaign", ads[0])
+	}
+	launch, err := c.CreateAd(ctx, client.NewAd{
+		Name: "Launch", ImageURL: "https://cdn.example.com/ad.png", TargetURL: "https://example.com",
+		Status: "paused", Campaign: "launch",
+	})
+	if err != nil {
+		t.Fatalf("CreateAd: %v", err)
+	}
+	if launch.Status != "paused" || launch.Campaign != "launch" {
+		t.Errorf("CreateAd returned %+v, want a paused ad of the launch campaign", launch)
+	}
+
+	archived, campaign := "archived", "launch"
+	updated, err := c.UpdateAd(ctx, ads[1].ID, client.AdUpdate{Status: &archived, Campaign: &campaign})
+	if err != nil {
+		t.Fatalf("UpdateAd: %v", err)
+	}
+	if updated.Status != "archived" || updated.Campaign != "launch" || updated.Name != ads[1].Name {
+		t.Errorf("UpdateAd returned %+v, want %s archived in the launch campaign", updated, ads[1].Name)
+	}
+
+	tests := []struct {
+		params client.ListAdsParams
+		want   []string
+	}{
+		{client.ListAdsParams{Campaign: "launch"}, []string{"Launch", "Ad B"}},
+		{client.ListAdsParams{Statuses: []string{"active"}}, []string{"Ad C", "Ad A"}},
+		{client.ListAdsParams{Statuses: []string{"paused", "archived"}, Campaign: "launch"}, []string{"Launch", "Ad B"}},
+		{client.ListAdsParams{Statuses: []string{"active"}, Campaign: "launch"}, nil},
+	}
+	for _, tt := range tests {
+		list, err := c.ListAds(ctx, tt.params)
+		if err != nil {
+			t.Fatalf("ListAds(%+v): %v", tt.params, err)
+		}
+		var names []string
+		for _, ad := range list.Values {
+			names = append(names, ad.Name)
+		}
+		if !slices.Equal(names, tt.want) || *list.Total != len(tt.want) {
+			t.Errorf("ListAds(%+v) returned %v of %d, want %v", tt.params, names, *list.Total, tt.want)
+		}
+	}
+
+	var apiErr *client.Error
+	if _, err := c.ListAds(ctx, client.ListAdsParams{Statuses: []string{"deleted"}}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("ListAds with an unknown status returned %v, want a 400 error", err)
+	}
+	stopped := "stopped"
+	if _, err := c.UpdateAd(ctx, ads[0].ID, client.AdUpdate{Status: &stopped}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("UpdateAd with an unknown status returned %v, want a 400 error", err)
+	}
+	if _, err := c.UpdateAd(ctx, "00000000-0000-0000-0000-000000000000", client.AdUpdate{Status: &archived}); !client.IsNotFound(err) {
+		t.Errorf("UpdateAd of an unknown ad returned %v, want a not found error", err)
+	}
+}
+
+func TestErrorTraceID(t *testing.T) {
+	c := newClient(newServer(t, &repository{}), client.Options{})
+
+	_, err := c.GetAd(context.Background(), "00000000-0000-0000-0000-000000000000")
+	if !client.IsNotFound(err) {
+		t.Fatalf("GetAd of an unknown ad returned %v, want a not found error", err)
+	}
+	var apiErr *client.Error
+	errors.As(err, &apiErr)
+	if apiErr.Message != "Ad not found" || !traceIDPattern.MatchString(apiErr.TraceID) {
+		t.Errorf("GetAd returned %+v, want the server's message and trace ID", apiErr)
+	}
+
+	// the server continues the caller's trace
+	ctx, span := otel.Tracer("client_test").Start(context.Background(), "caller")
+	defer span.End()
+	_, err = c.GetAd(ctx, "not-a-uuid")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("GetAd with an invalid ID returned %v, want a 400 error", err)
+	}
+	if want := span.SpanContext().TraceID().String(); apiErr.TraceID != want {
+		t.Errorf("error trace ID is %s, want the caller's %s", apiErr.TraceID, want)
+	}
+}
+
+func TestLogClickRetries(t *testing.T) {
+	ctx := context.Background()
+	repo := &repository{}
+	c := newClient(newServer(t, repo), client.Options{MaxRetries: 2})
+	ad := createAds(t, c, 1)[0]
+
+	// a 500 followed by a success
+	repo.failClicks = 1
+	click, err := c.LogClick(ctx, client.Click{AdID: ad.ID, IPAddress: "10.0.0.1", PlaybackTime: 30})
+	if err != nil {
+		t.Fatalf("LogClick after one server error: %v", err)
+	}
+	if click.ID == "" || click.AdID != ad.ID || click.PlaybackTime != 30 || click.IPAddress != "10.0.0.1" {
+		t.Errorf("LogClick returned %+v, want the logged click", click)
+	}
+	if repo.clickCalls != 2 || len(repo.loggedClicks()) != 1 {
+		t.Errorf("LogClick made %d calls logging %d clicks, want 2 calls logging 1 click", repo.clickCalls, len(repo.loggedClicks()))
+	}
+
+	// gives up after MaxRetries
+	repo.failClicks, repo.clickCalls = 10, 0
+	_, err = c.LogClick(ctx, client.Click{AdID: ad.ID, PlaybackTime: 30})
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("LogClick failing every time returned %v, want the last 500 error", err)
+	}
+	if repo.clickCalls != 3 {
+		t.Errorf("LogClick made %d calls, want 3 with 2 retries", repo.clickCalls)
+	}
+
+	// client errors aren't retried
+	repo.failClicks, repo.clickCalls = 0, 0
+	if _, err = c.LogClick(ctx, client.Click{AdID: "not-a-uuid"}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("LogClick with an invalid ad ID returned %v, want a 400 error", err)
+	}
+	if repo.clickCalls != 0 {
+		t.Errorf("LogClick with an invalid ad ID reached the repository %d times", repo.clickCalls)
+	}
+}
+
+func TestLogClickBackoff(t *testing.T) {
+	repo := &repository{}
+	server := newServer(t, repo)
+	ad := createAds(t, newClient(server, client.Options{}), 1)[0]
+
+	// the delays are random with full jitter, MaxBackoff bounds each of them
+	c := newClient(server, client.Options{MaxRetries: 3, Backoff: 20 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+	repo.failClicks = 10
+	start := time.Now()
+	if _, err := c.LogClick(context.Background(), client.Click{AdID: ad.ID}); err == nil {
+		t.Fatal("LogClick failing every time succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("3 retries capped at 20ms took %v", elapsed)
+	}
+}
+
+func TestAnalytics(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(newServer(t, &repository{}), client.Options{})
+	ads := createAds(t, c, 2)
+	for _, click := range []client.Click{
+		{AdID: ads[0].ID, PlaybackTime: 10},
+		{AdID: ads[0].ID, PlaybackTime: 30},
+		// out of the hour range
+		{AdID: ads[0].ID, PlaybackTime: 20, Timestamp: time.Now().Add(-2 * time.Hour)},
+		{AdID: ads[1].ID, PlaybackTime: 60},
+	} {
+		if _, err := c.LogClick(ctx, click); err != nil {
+			t.Fatalf("LogClick: %v", err)
+		}
+	}
+
+	analytics, err := c.GetAdAnalytics(ctx, ads[0].ID, client.PeriodHour)
+	if err != nil {
+		t.Fatalf("GetAdAnalytics: %v", err)
+	}
+	want := client.AdAnalytics{
+		AdID: ads[0].ID, Period: client.PeriodHour,
+		TotalClicks: 3, TotalPlaybackTime: 60, AveragePlaybackTime: 20,
+		TotalClicksInRange: 2, TotalPlaybackTimeInRange: 40, AveragePlaybackTimeInRange: 20,
+	}
+	if *analytics != want {
+		t.Errorf("GetAdAnalytics returned %+v, want %+v", *analytics, want)
+	}
+
+	all, err := c.GetAdsAnalytics(ctx, "")
+	if err != nil {
+		t.Fatalf("GetAdsAnalytics: %v", err)
+	}
+	if all.Period != client.PeriodHour || all.TotalClicks != 4 || all.TotalPlaybackTime != 120 || all.AverageClicksPerAd != 2 || all.TotalClicksInRange != 3 {
+		t.Errorf("GetAdsAnalytics returned %+v, want 4 clicks of 2 ads, 3 in the last hour", *all)
+	}
+
+	if _, err := c.GetAdAnalytics(ctx, ads[0].ID, "year"); err == nil {
+		t.Error("GetAdAnalytics with an invalid period succeeded")
+	}
+}
+
+func TestClickBatcher(t *testing.T) {
+	repo := &repository{}
+	c := newClient(newServer(t, repo), client.Options{})
+	ad := createAds(t, c, 1)[0]
+
+	var mu sync.Mutex
+	var failed []error
+	batcher := c.NewClickBatcher(client.BatchOptions{
+		Size: 10, Interval: time.Hour, Concurrency: 4,
+		OnError: func(click client.Click, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed = append(failed, err)
+		},
+	})
+	for range 25 {
+		batcher.Add(client.Click{AdID: ad.ID, PlaybackTime: 5})
+	}
+	// unknown ad
+	batcher.Add(client.Click{AdID: "00000000-0000-0000-0000-000000000000"})
+
+	// the two full batches are sent without waiting for the interval
+	deadline := time.Now().Add(5 * time.Second)
+	for len(repo.loggedClicks()) < 20 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if logged := len(repo.loggedClicks()); logged < 20 {
+		t.Fatalf("%d clicks logged before Close, want the 20 clicks of the full batches", logged)
+	}
+
+	if err := batcher.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if logged := len(repo.loggedClicks()); logged != 25 {
+		t.Errorf("%d clicks logged after Close, want 25", logged)
+	}
+	if len(failed) != 1 || !client.IsNotFound(failed[0]) {
+		t.Errorf("OnError got %v, want a single not found error", failed)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	repo := &repository{}
+	server := newServer(t, repo)
+	ad := createAds(t, newClient(server, client.Options{}), 1)[0]
+
+	// cancelled while waiting to retry
+	c := newClient(server, client.Options{MaxRetries: 5, Backoff: time.Hour, MaxBackoff: time.Hour})
+	repo.failClicks = 10
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.LogClick(ctx, client.Click{AdID: ad.ID})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("LogClick cancelled while backing off returned %v, want the context's error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("LogClick returned %v after its context was done", elapsed)
+	}
+
+	// cancelled before sending
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	repo.clickCalls = 0
+	if _, err := c.LogClick(ctx, client.Click{AdID: ad.ID}); !errors.Is(err, context.Canceled) {
+		t.Errorf("LogClick with a cancelled context returned %v, want context.Canceled", err)
+	}
+	if repo.clickCalls != 0 {
+		t.Errorf("LogClick with a cancelled context reached the repository %d times", repo.clickCalls)
+	}
+	if _, err := c.GetAd(ctx, ad.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetAd with a cancelled context returned %v, want context.Canceled", err)
+	}
+
+	// a batcher closed with a done context reports the unsent clicks
+	batcher := c.NewClickBatcher(client.BatchOptions{Interval: time.Hour, Concurrency: 1, OnError: func(client.Click, error) {}})
+	batcher.Add(client.Click{AdID: ad.ID})
+	if err := batcher.Close(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Close with a cancelled context returned %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,148 @@
+package client
+
+import "time"
+
+// Ad is a video advertisement
+type Ad struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	ImageURL    string    `json:"image_url"`
+	TargetURL   string    `json:"target_url"`
+	Status      string    `json:"status"`   // active, paused or archived
+	Campaign    string    `json:"campaign"` // empty when the ad belongs to no campaign
+	CreatedAt   time.Time `json:"created_at"`
+	// in listings with IncludeAnalytics only
+	Analytics *AdStats `json:"analytics,omitempty"`
+	// in listings with a Search only
+	Search *SearchMatch `json:"search,omitempty"`
+}
+
+// SearchMatch tells how an ad matches a search
+type SearchMatch struct {
+	Rank float64 `json:"rank"` // higher is more relevant
+	// excerpt of the description, HTML escaped, with the matched words between <mark> and </mark>
+	Snippet string `json:"snippet"`
+}
+
+// AdStats are the all time aggregate stats of an ad
+type AdStats struct {
+	TotalClicks       int `json:"total_clicks"`
+	TotalPlaybackTime int `json:"total_playback_time"` // in seconds
+}
+
+// NewAd is the payload of CreateAd
+type NewAd struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url"`
+	TargetURL   string `json:"target_url"`
+	Status      string `json:"status,omitempty"` // active by default
+	Campaign    string `json:"campaign,omitempty"`
+}
+
+// AdUpdate is the payload of UpdateAd, nil fields are kept
+type AdUpdate struct {
+	Status   *string `json:"status,omitempty"`
+	Campaign *string `json:"campaign,omitempty"` // empty to remove the ad from its campaign
+}
+
+// ListAdsParams filters and paginates ListAds, zero values use the server's defaults
+type ListAdsParams struct {
+	Page  int
+	Rows  int    // page size, at most 100
+	Sort  string // created_at, name, total_clicks, total_playback_time or relevance (when searching)
+	Order string // asc or desc
+	// searches the name and description, setting Ad.Search
+	Search     string
+	SearchMode string // fulltext or trigram
+	// ads created from CreatedFrom (inclusive) to CreatedTo (exclusive)
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// host of the target url, also matching its subdomains
+	TargetDomain string
+	// ads with one of these statuses
+	Statuses []string
+	// ads of this campaign
+	Campaign string
+	// embeds the all time stats of every ad
+	IncludeAnalytics bool
+	// Cursor selects cursor mode instead of pages: point it to "" for the first page,
+	// then to the NextCursor of the previous page. Page is ignored then.
+	Cursor *string
+	// IncludeTotal counts the ads in cursor mode as well
+	IncludeTotal bool
+}
+
+// Pages describes the page of a list in page mode
+type Pages struct {
+	PageNumber int `json:"page_number"`
+	TotalPages int `json:"total_pages"`
+	TotalRows  int `json:"total_rows"`
+	PageSize   int `json:"page_size"`
+}
+
+// AdList is a page of ads
+type AdList struct {
+	Values []Ad   `json:"values"`
+	Pages  *Pages `json:"pages"` // page mode only
+	// cursor mode only, empty on the last page
+	NextCursor string `json:"next_cursor"`
+	// in page mode, and in cursor mode with IncludeTotal
+	Total *int `json:"total"`
+}
+
+// Click is a user interaction with an ad. When logging it ID and CreatedAt are ignored,
+// a zero Timestamp defaults to now minus the playback time and an empty IPAddress to the caller's.
+type Click struct {
+	ID           string    `json:"id"`
+	AdID         string    `json:"ad_id"`
+	Timestamp    time.Time `json:"timestamp"`
+	IPAddress    string    `json:"ip_address"`
+	PlaybackTime int       `json:"playback_time"` // in seconds
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// newClick is the payload of LogClick, leaving out the fields defaulted by the server
+type newClick struct {
+	AdID         string     `json:"ad_id"`
+	Timestamp    *time.Time `json:"timestamp,omitempty"`
+	IPAddress    string     `json:"ip_address,omitempty"`
+	PlaybackTime int        `json:"playback_time"`
+}
+
+// Period is the range of the in_range analytics, counted back from now
+type Period string
+
+const (
+	PeriodMinute Period = "minute"
+	PeriodHour   Period = "hour"
+	PeriodDay    Period = "day"
+	PeriodWeek   Period = "week"
+	PeriodMonth  Period = "month"
+)
+
+// AdsAnalytics is the click and playback analytics of all ads
+type AdsAnalytics struct {
+	TotalClicks                int     `json:"total_clicks"`
+	AverageClicksPerAd         float64 `json:"average_clicks_per_ad"`
+	TotalPlaybackTime          int     `json:"total_playback_time"`
+	AveragePlaybackTime        float64 `json:"average_playback_time"`
+	Period                     Period  `json:"period"`
+	TotalClicksInRange         int     `json:"total_clicks_in_range"`
+	AverageClicksPerAdInRange  float64 `json:"average_clicks_per_ad_in_range"`
+	TotalPlaybackTimeInRange   int     `json:"total_playback_time_in_range"`
+	AveragePlaybackTimeInRange float64 `json:"average_playback_time_in_range"`
+}
+
+// AdAnalytics is the click and playback analytics of an ad
+type AdAnalytics struct {
+	AdID                       string  `json:"ad_id"`
+	TotalClicks                int     `json:"total_clicks"`
+	TotalPlaybackTime          int     `json:"total_playback_time"`
+	AveragePlaybackTime        float64 `json:"average_playback_time"`
+	Period                     Period  `json:"period"`
+	TotalClicksInRange         int     `json:"total_clicks_in_range"`
+	TotalPlaybackTimeInRange   int     `json:"total_playback_time_in_range"`
+	AveragePlaybackTimeInRange float64 `json:"average_playback_time_in_range"`
+}